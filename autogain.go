@@ -0,0 +1,111 @@
+package ffgoconv
+
+import (
+	"errors"
+	"math"
+	"sync/atomic"
+)
+
+const (
+	// autoGainWindow is the number of interleaved samples the loudness estimate is averaged over, 3 seconds at 48kHz stereo.
+	autoGainWindow = 3 * 48000 * 2
+	// autoGainSmoothing is the number of interleaved samples it takes the applied gain to settle, 500ms at 48kHz stereo.
+	autoGainSmoothing = 48000
+	// autoGainGate is the linear RMS level below which a source is considered silent and its gain is held, -60 dBFS.
+	autoGainGate = 0.001
+
+	defaultAutoGainTarget   = -18.0
+	defaultAutoGainMaxBoost = 12.0
+)
+
+// autoGain continuously adjusts a makeup gain so that the short-term loudness of a source approaches a target level.
+//
+// The estimate and the gain are only updated by the mix loop, while the settings and the applied gain are stored as
+// atomic float bits so they can be changed and read from any goroutine.
+type autoGain struct {
+	enabled  atomic.Bool
+	target   atomic.Uint64
+	maxBoost atomic.Uint64
+	applied  atomic.Uint64
+
+	meanSquare float64
+	samples    int64
+	gain       float64
+}
+
+// init sets the default auto-gain settings.
+func (autoGain *autoGain) init() {
+	autoGain.target.Store(math.Float64bits(dbToLinear(defaultAutoGainTarget)))
+	autoGain.maxBoost.Store(math.Float64bits(dbToLinear(defaultAutoGainMaxBoost)))
+	autoGain.applied.Store(math.Float64bits(1.0))
+	autoGain.gain = 1.0
+}
+
+// apply measures sample and returns it with the current makeup gain applied.
+func (autoGain *autoGain) apply(sample float64) float64 {
+	if !autoGain.enabled.Load() {
+		if autoGain.gain != 1.0 {
+			autoGain.gain = 1.0
+			autoGain.applied.Store(math.Float64bits(1.0))
+		}
+		return sample
+	}
+
+	if autoGain.samples < autoGainWindow {
+		autoGain.samples++
+	}
+	autoGain.meanSquare += (sample*sample - autoGain.meanSquare) / float64(autoGain.samples)
+
+	rms := math.Sqrt(autoGain.meanSquare)
+	if rms > autoGainGate {
+		desired := math.Float64frombits(autoGain.target.Load()) / rms
+		if maxBoost := math.Float64frombits(autoGain.maxBoost.Load()); desired > maxBoost {
+			desired = maxBoost
+		}
+
+		autoGain.gain += (desired - autoGain.gain) / autoGainSmoothing
+		autoGain.applied.Store(math.Float64bits(autoGain.gain))
+	}
+
+	return sample * autoGain.gain
+}
+
+// dbToLinear converts a level in decibels to a linear gain.
+func dbToLinear(db float64) float64 {
+	return math.Pow(10, db/20)
+}
+
+// SetAutoGain sets whether or not the transmuxing session continuously adjusts a makeup gain, applied before the volume
+// of the streamer, so that its short-term loudness approaches the auto-gain target. Auto-gain is disabled by default.
+func (streamer *Streamer) SetAutoGain(enabled bool) error {
+	if streamer.closed.Load() {
+		return ErrStreamerClosed
+	}
+
+	streamer.autoGain.enabled.Store(enabled)
+	return nil
+}
+
+// SetAutoGainTarget sets the RMS level in dBFS that auto-gain adjusts the streamer towards, as well as the maximum boost
+// in dB it may apply to quiet sources. The defaults are a target of -18 dBFS and a maximum boost of 12 dB.
+func (streamer *Streamer) SetAutoGainTarget(target, maxBoost float64) error {
+	if streamer.closed.Load() {
+		return ErrStreamerClosed
+	}
+	if target > 0.0 {
+		return errors.New("ffgoconv: autogain: target must not be greater than 0 dBFS")
+	}
+	if maxBoost < 0.0 {
+		return errors.New("ffgoconv: autogain: maximum boost must not be less than 0 dB")
+	}
+
+	streamer.autoGain.target.Store(math.Float64bits(dbToLinear(target)))
+	streamer.autoGain.maxBoost.Store(math.Float64bits(dbToLinear(maxBoost)))
+	return nil
+}
+
+// AppliedGain returns the linear makeup gain currently applied to the streamer by auto-gain, which is 1.0 while
+// auto-gain is disabled.
+func (streamer *Streamer) AppliedGain() float64 {
+	return math.Float64frombits(streamer.autoGain.applied.Load())
+}
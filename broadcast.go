@@ -0,0 +1,148 @@
+package ffgoconv
+
+import (
+	"errors"
+	"sync"
+)
+
+const (
+	// broadcastChunkSize is the amount of encoded audio read from the final stream at once.
+	broadcastChunkSize = 4096
+	// broadcastBacklog is the number of chunks a subscriber may fall behind by.
+	broadcastBacklog = 64
+)
+
+// broadcaster fans the encoded output of a transmuxing session out to every subscriber, such as HTTP clients and
+// Icecast servers, as the final stream may only be read once.
+type broadcaster struct {
+	sync.Mutex
+
+	subscribers map[*subscription]struct{}
+	recent      []byte
+	prime       int
+	done        bool
+}
+
+// subscription receives chunks of the encoded output of a transmuxing session.
+type subscription struct {
+	chunks chan []byte
+	// lossy subscriptions drop their oldest chunks once they fall too far behind, rather than being unsubscribed.
+	lossy bool
+}
+
+// broadcaster returns the broadcaster of the transmuxing session, starting to read the output of the final stream if
+// it hasn't been yet.
+func (transmuxer *Transmuxer) broadcaster() (*broadcaster, error) {
+	if transmuxer.closed {
+		return nil, errors.New("ffgoconv: transmuxer: closed")
+	}
+	if transmuxer.FinalStream == nil {
+		return nil, errors.New("ffgoconv: broadcast: transmuxer has no encoded output")
+	}
+
+	transmuxer.Lock()
+	defer transmuxer.Unlock()
+
+	if transmuxer.broadcast == nil {
+		transmuxer.broadcast = &broadcaster{subscribers: make(map[*subscription]struct{})}
+		go transmuxer.broadcast.run(transmuxer.FinalStream)
+	}
+	return transmuxer.broadcast, nil
+}
+
+// setPrime sets how many bytes of the most recent output new subscribers are primed with.
+func (broadcaster *broadcaster) setPrime(size int) {
+	broadcaster.Lock()
+	defer broadcaster.Unlock()
+
+	broadcaster.prime = size
+	if len(broadcaster.recent) > size {
+		broadcaster.recent = append([]byte(nil), broadcaster.recent[len(broadcaster.recent)-size:]...)
+	}
+}
+
+// subscribe registers a new subscription, returning it along with the recent output it is primed with, or nil if the
+// output has already ended.
+func (broadcaster *broadcaster) subscribe(lossy bool) (*subscription, []byte) {
+	broadcaster.Lock()
+	defer broadcaster.Unlock()
+
+	if broadcaster.done {
+		return nil, nil
+	}
+
+	subscription := &subscription{chunks: make(chan []byte, broadcastBacklog), lossy: lossy}
+	broadcaster.subscribers[subscription] = struct{}{}
+	return subscription, append([]byte(nil), broadcaster.recent...)
+}
+
+// unsubscribe unregisters a subscription, closing its channel.
+func (broadcaster *broadcaster) unsubscribe(subscription *subscription) {
+	broadcaster.Lock()
+	defer broadcaster.Unlock()
+
+	if _, ok := broadcaster.subscribers[subscription]; ok {
+		delete(broadcaster.subscribers, subscription)
+		close(subscription.chunks)
+	}
+}
+
+// run reads the output of the final stream and hands it to every subscriber until the output ends. Subscribers that
+// fall too far behind are unsubscribed rather than holding up the stream for everyone else, unless they are lossy.
+func (broadcaster *broadcaster) run(finalStream *Streamer) {
+	defer func() {
+		broadcaster.Lock()
+		broadcaster.done = true
+		for subscription := range broadcaster.subscribers {
+			delete(broadcaster.subscribers, subscription)
+			close(subscription.chunks)
+		}
+		broadcaster.Unlock()
+	}()
+
+	for {
+		chunk := make([]byte, broadcastChunkSize)
+		n, err := finalStream.Read(chunk)
+		if n > 0 {
+			broadcaster.send(chunk[:n])
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// send hands a chunk to every subscriber.
+func (broadcaster *broadcaster) send(chunk []byte) {
+	broadcaster.Lock()
+	defer broadcaster.Unlock()
+
+	if broadcaster.prime > 0 {
+		broadcaster.recent = append(broadcaster.recent, chunk...)
+		if len(broadcaster.recent) > broadcaster.prime {
+			broadcaster.recent = broadcaster.recent[len(broadcaster.recent)-broadcaster.prime:]
+		}
+	}
+
+	for subscription := range broadcaster.subscribers {
+		select {
+		case subscription.chunks <- chunk:
+		default:
+			if !subscription.lossy {
+				delete(broadcaster.subscribers, subscription)
+				close(subscription.chunks)
+				continue
+			}
+
+			// The oldest chunk makes room for the new one, so that the subscription keeps the most recent output.
+			select {
+			case <-subscription.chunks:
+			default:
+			}
+			select {
+			case subscription.chunks <- chunk:
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package ffgoconv
+
+import "testing"
+
+func TestBroadcastLossyKeepsNewest(t *testing.T) {
+	broadcaster := &broadcaster{subscribers: make(map[*subscription]struct{})}
+	lossy, _ := broadcaster.subscribe(true)
+	strict, _ := broadcaster.subscribe(false)
+
+	for i := 0; i < broadcastBacklog+36; i++ {
+		broadcaster.send([]byte{byte(i)})
+	}
+
+	// A subscriber that fell behind without reading anything is left with the most recent chunks only.
+	for want := 36; want < broadcastBacklog+36; want++ {
+		select {
+		case chunk := <-lossy.chunks:
+			if chunk[0] != byte(want) {
+				t.Fatalf("got chunk %d, want %d", chunk[0], want)
+			}
+		default:
+			t.Fatalf("ran out of chunks before chunk %d", want)
+		}
+	}
+	select {
+	case chunk := <-lossy.chunks:
+		t.Fatalf("got chunk %d past the most recent one", chunk[0])
+	default:
+	}
+
+	if _, ok := broadcaster.subscribers[strict]; ok {
+		t.Error("a subscriber that isn't lossy wasn't unsubscribed once it fell behind")
+	}
+}
@@ -0,0 +1,70 @@
+package ffgoconv
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// NewCaptureStreamer returns an initialized *Streamer capturing live audio from an OS capture device, or an error if one
+// could not be created.
+//
+// The inputFormat is the ffmpeg input device used to capture from device, such as "alsa" or "pulse" with a device of
+// "default" on Linux, "avfoundation" with a device of ":0" on macOS, or "dshow" with a device of "audio=Microphone" on
+// Windows. An error is returned if the local ffmpeg wasn't built with support for inputFormat.
+//
+// The variable volume must be a floating-point number between 0 and 1, representing a percentage value. For example, 20% volume would be 0.2.
+func NewCaptureStreamer(device, inputFormat string, volume float64) (*Streamer, error) {
+	if device == "" {
+		return nil, errors.New("ffgoconv: capture: device must not be empty string")
+	}
+	if inputFormat == "" {
+		return nil, errors.New("ffgoconv: capture: input format must not be empty string")
+	}
+
+	ffmpegPath, err := lookFFmpeg("")
+	if err != nil {
+		return nil, err
+	}
+	if err := checkInputDevice(ffmpegPath, inputFormat); err != nil {
+		return nil, err
+	}
+
+	return NewStreamerWithOptions(&StreamerOptions{
+		Input:          device,
+		ExtraInputArgs: []string{"-f", inputFormat},
+		Volume:         volume,
+		FFmpegPath:     ffmpegPath,
+	})
+}
+
+// checkInputDevice returns an error if the ffmpeg executable at ffmpegPath doesn't support capturing with inputFormat.
+func checkInputDevice(ffmpegPath, inputFormat string) error {
+	var stdout bytes.Buffer
+	ffmpeg := exec.Command(ffmpegPath, "-hide_banner", "-devices")
+	ffmpeg.Stdout = &stdout
+
+	if err := ffmpeg.Run(); err != nil {
+		return fmt.Errorf("ffgoconv: capture: error listing ffmpeg devices: %v", err)
+	}
+
+	// Devices are listed as their capabilities followed by their name, such as " D  alsa  ALSA audio output", where D
+	// means capturing is supported.
+	scanner := bufio.NewScanner(&stdout)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || !strings.Contains(fields[0], "D") {
+			continue
+		}
+		for _, name := range strings.Split(fields[1], ",") {
+			if name == inputFormat {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("ffgoconv: capture: input format %s isn't supported by %s", inputFormat, ffmpegPath)
+}
@@ -0,0 +1,258 @@
+package ffgoconv
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// CodecInfo describes a codec supported by the local ffmpeg build, as listed by "ffmpeg -codecs".
+type CodecInfo struct {
+	Name        string
+	Description string
+	Type        string   // Type of the codec, such as "audio" or "video"
+	Decode      bool     // Whether or not ffmpeg can decode the codec
+	Encode      bool     // Whether or not ffmpeg can encode the codec
+	Lossy       bool     // Whether or not the codec supports lossy compression
+	Lossless    bool     // Whether or not the codec supports lossless compression
+	Decoders    []string // Names of the decoders of the codec, such as "libopus"
+	Encoders    []string // Names of the encoders of the codec, which are passed to -acodec
+}
+
+// FormatInfo describes a container format supported by the local ffmpeg build, as listed by "ffmpeg -formats".
+type FormatInfo struct {
+	Name        string
+	Description string
+	Demux       bool // Whether or not ffmpeg can read the format
+	Mux         bool // Whether or not ffmpeg can write the format
+}
+
+// capabilities caches the version, codecs and formats of an ffmpeg executable.
+type capabilities struct {
+	once    sync.Once
+	codecs  map[string]CodecInfo
+	formats map[string]FormatInfo
+	err     error
+
+	versionOnce sync.Once
+	version     ffmpegVersion
+	versionErr  error
+}
+
+var (
+	capabilitiesMu sync.Mutex
+	capabilitiesOf = make(map[string]*capabilities)
+)
+
+// SupportedCodecs returns the codecs supported by the ffmpeg executable set with SetFFmpegPath, keyed by their name.
+// The list is only read from ffmpeg once and then cached, with every call returning a copy that may be modified freely.
+func SupportedCodecs() (map[string]CodecInfo, error) {
+	caps, err := lookCapabilities("")
+	if err != nil {
+		return nil, err
+	}
+
+	codecs := make(map[string]CodecInfo, len(caps.codecs))
+	for name, codec := range caps.codecs {
+		codec.Decoders = append([]string(nil), codec.Decoders...)
+		codec.Encoders = append([]string(nil), codec.Encoders...)
+		codecs[name] = codec
+	}
+	return codecs, nil
+}
+
+// SupportedFormats returns the container formats supported by the ffmpeg executable set with SetFFmpegPath, keyed by
+// their name. The list is only read from ffmpeg once and then cached, with every call returning a copy that may be
+// modified freely.
+func SupportedFormats() (map[string]FormatInfo, error) {
+	caps, err := lookCapabilities("")
+	if err != nil {
+		return nil, err
+	}
+
+	formats := make(map[string]FormatInfo, len(caps.formats))
+	for name, format := range caps.formats {
+		formats[name] = format
+	}
+	return formats, nil
+}
+
+// cachedCapabilities returns the cache of the ffmpeg executable at path, or of the package-level ffmpeg if path is
+// empty, along with the resolved path of the executable.
+func cachedCapabilities(path string) (*capabilities, string, error) {
+	ffmpegPath, err := lookFFmpeg(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	capabilitiesMu.Lock()
+	defer capabilitiesMu.Unlock()
+
+	caps := capabilitiesOf[ffmpegPath]
+	if caps == nil {
+		caps = &capabilities{}
+		capabilitiesOf[ffmpegPath] = caps
+	}
+	return caps, ffmpegPath, nil
+}
+
+// lookCapabilities returns the cached codecs and formats of the ffmpeg executable at path, or of the package-level
+// ffmpeg if path is empty, reading them first if they haven't been yet.
+func lookCapabilities(path string) (*capabilities, error) {
+	caps, ffmpegPath, err := cachedCapabilities(path)
+	if err != nil {
+		return nil, err
+	}
+
+	caps.once.Do(func() {
+		var output []byte
+		if output, caps.err = listCapabilities(ffmpegPath, "-codecs"); caps.err != nil {
+			return
+		}
+		caps.codecs = parseCodecs(output)
+
+		if output, caps.err = listCapabilities(ffmpegPath, "-formats"); caps.err != nil {
+			return
+		}
+		caps.formats = parseFormats(output)
+	})
+	if caps.err != nil {
+		return nil, caps.err
+	}
+	return caps, nil
+}
+
+// listCapabilities runs ffmpeg with the given listing flag, such as "-codecs" or "-version", and returns its output.
+func listCapabilities(ffmpegPath, flag string) ([]byte, error) {
+	var stdout bytes.Buffer
+	ffmpeg := exec.Command(ffmpegPath, "-hide_banner", flag)
+	ffmpeg.Stdout = &stdout
+
+	if err := ffmpeg.Run(); err != nil {
+		return nil, fmt.Errorf("ffgoconv: ffmpeg: error listing %s with %s: %v", strings.TrimPrefix(flag, "-"), ffmpegPath, err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// capabilityLines returns the lines of an ffmpeg listing following the legend, which ends with a line of dashes.
+func capabilityLines(output []byte) []string {
+	var lines []string
+	listing := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !listing {
+			listing = strings.HasPrefix(strings.TrimSpace(line), "--")
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// parseCodecs parses the output of "ffmpeg -codecs", which lists codecs as their capabilities followed by their name
+// and description, such as " DEA.L. mp3  MP3 (MPEG audio layer 3) (decoders: mp3float mp3) (encoders: libmp3lame)".
+func parseCodecs(output []byte) map[string]CodecInfo {
+	codecs := make(map[string]CodecInfo)
+	types := map[byte]string{'V': "video", 'A': "audio", 'S': "subtitle", 'D': "data", 'T': "attachment"}
+
+	for _, line := range capabilityLines(output) {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || len(fields[0]) < 6 {
+			continue
+		}
+		flags := fields[0]
+
+		codec := CodecInfo{
+			Name:     fields[1],
+			Type:     types[flags[2]],
+			Decode:   flags[0] == 'D',
+			Encode:   flags[1] == 'E',
+			Lossy:    flags[4] == 'L',
+			Lossless: flags[5] == 'S',
+		}
+
+		description := strings.TrimSpace(strings.Join(fields[2:], " "))
+		description, codec.Encoders = cutCoderList(description, "encoders")
+		description, codec.Decoders = cutCoderList(description, "decoders")
+		codec.Description = description
+
+		codecs[codec.Name] = codec
+	}
+	return codecs
+}
+
+// cutCoderList cuts a trailing list of coders such as "(encoders: libopus opus)" out of a codec description.
+func cutCoderList(description, kind string) (string, []string) {
+	i := strings.LastIndex(description, "("+kind+":")
+	if i < 0 {
+		return description, nil
+	}
+
+	list := strings.TrimSuffix(description[i+len(kind)+2:], ")")
+	return strings.TrimSpace(description[:i]), strings.Fields(list)
+}
+
+// parseFormats parses the output of "ffmpeg -formats", which lists formats as their capabilities followed by their
+// names and description, such as " DE  mp3  MP3 (MPEG audio layer 3)". Formats going by several names are listed under
+// each of them.
+func parseFormats(output []byte) map[string]FormatInfo {
+	formats := make(map[string]FormatInfo)
+
+	for _, line := range capabilityLines(output) {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		flags := fields[0]
+
+		description := strings.Join(fields[2:], " ")
+		for _, name := range strings.Split(fields[1], ",") {
+			format := formats[name]
+			format.Name = name
+			if format.Description == "" {
+				format.Description = description
+			}
+			format.Demux = format.Demux || strings.Contains(flags, "D")
+			format.Mux = format.Mux || strings.Contains(flags, "E")
+			formats[name] = format
+		}
+	}
+	return formats
+}
+
+// checkEncoding returns an error if the ffmpeg executable at path, or the package-level ffmpeg if path is empty, can't
+// encode codec or mux format.
+func checkEncoding(path, codec, format string) error {
+	caps, err := lookCapabilities(path)
+	if err != nil {
+		return err
+	}
+
+	if !caps.canEncode(codec) {
+		return fmt.Errorf("ffgoconv: transmuxer: codec %s can't be encoded by this ffmpeg build", codec)
+	}
+	if !caps.formats[format].Mux {
+		return fmt.Errorf("ffgoconv: transmuxer: format %s can't be written by this ffmpeg build", format)
+	}
+	return nil
+}
+
+// canEncode returns whether or not codec names either an encodable codec or an encoder of one.
+func (caps *capabilities) canEncode(codec string) bool {
+	if info, ok := caps.codecs[codec]; ok && info.Encode {
+		return true
+	}
+	for _, info := range caps.codecs {
+		for _, encoder := range info.Encoders {
+			if encoder == codec {
+				return true
+			}
+		}
+	}
+	return false
+}
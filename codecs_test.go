@@ -0,0 +1,83 @@
+package ffgoconv
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// fakeCapabilities is a script standing in for ffmpeg, listing a few codecs and formats like "ffmpeg -codecs" and
+// "ffmpeg -formats" do.
+const fakeCapabilities = `#!/bin/sh
+case "$2" in
+-codecs)
+	echo "Codecs:"
+	echo " D..... = Decoding supported"
+	echo " -------"
+	echo " DEA.L. mp3                  MP3 (MPEG audio layer 3) (decoders: mp3float mp3) (encoders: libmp3lame)"
+	echo " DEAI.S flac                 FLAC (Free Lossless Audio Codec)"
+	;;
+-formats)
+	echo "File formats:"
+	echo " D. = Demuxing supported"
+	echo " --"
+	echo " DE mp3             MP3 (MPEG audio layer 3)"
+	echo " DE matroska,webm   Matroska / WebM"
+	;;
+esac
+`
+
+func TestSupportedCodecsCopies(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake ffmpeg is a shell script")
+	}
+
+	path := filepath.Join(t.TempDir(), "ffmpeg")
+	if err := os.WriteFile(path, []byte(fakeCapabilities), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	SetFFmpegPath(path)
+	t.Cleanup(func() { SetFFmpegPath("ffmpeg") })
+
+	codecs, err := SupportedCodecs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mp3 := codecs["mp3"]
+	if !mp3.Encode || len(mp3.Encoders) != 1 || mp3.Encoders[0] != "libmp3lame" || len(mp3.Decoders) != 2 {
+		t.Fatalf("mp3 is %+v", mp3)
+	}
+
+	// Modifying what was returned must not modify the cache.
+	mp3.Encoders[0] = "modified"
+	mp3.Decoders = append(mp3.Decoders[:0], "modified")
+	delete(codecs, "flac")
+
+	codecs, err = SupportedCodecs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := codecs["flac"]; !ok {
+		t.Error("deleting flac from the codecs removed it from the cache")
+	}
+	if mp3 := codecs["mp3"]; mp3.Encoders[0] != "libmp3lame" || mp3.Decoders[0] != "mp3float" {
+		t.Errorf("modifying the coders of mp3 modified the cache: %+v", mp3)
+	}
+
+	formats, err := SupportedFormats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(formats) != 3 || !formats["webm"].Mux {
+		t.Fatalf("formats are %+v", formats)
+	}
+	delete(formats, "mp3")
+
+	if formats, err = SupportedFormats(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := formats["mp3"]; !ok {
+		t.Error("deleting mp3 from the formats removed it from the cache")
+	}
+}
@@ -0,0 +1,43 @@
+package ffgoconv
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// sanitize returns sample, or 0 if it is NaN or infinite, keeping count of how many such corrupt samples the streamer
+// has produced. It returns an error once the streamer has produced more consecutive corrupt samples than allowed.
+func (streamer *Streamer) sanitize(sample float64) (float64, error) {
+	if !math.IsNaN(sample) && !math.IsInf(sample, 0) {
+		streamer.corruptRun = 0
+		return sample, nil
+	}
+
+	streamer.corrupt.Add(1)
+	streamer.corruptRun++
+
+	if max := streamer.maxCorrupt.Load(); max > 0 && streamer.corruptRun >= max {
+		return 0, fmt.Errorf("ffgoconv: streamer: %d consecutive corrupt samples", streamer.corruptRun)
+	}
+	return 0, nil
+}
+
+// CorruptSamples returns the number of NaN or infinite samples the streamer has produced, which are mixed as silence.
+func (streamer *Streamer) CorruptSamples() uint64 {
+	return uint64(streamer.corrupt.Load())
+}
+
+// SetMaxCorruptSamples sets the number of consecutive NaN or infinite samples after which the streamer is closed and
+// removed from the mix. A maximum of 0 means that corrupt samples are always mixed as silence, which is the default.
+func (streamer *Streamer) SetMaxCorruptSamples(max int) error {
+	if streamer.closed.Load() {
+		return ErrStreamerClosed
+	}
+	if max < 0 {
+		return errors.New("ffgoconv: streamer: maximum corrupt samples must not be negative")
+	}
+
+	streamer.maxCorrupt.Store(int64(max))
+	return nil
+}
@@ -0,0 +1,73 @@
+package ffgoconv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCorruptSamplesMixedAsSilence(t *testing.T) {
+	values := []float64{0.25, math.NaN(), math.Inf(1), math.Inf(-1), -0.25, math.Float64frombits(0x7ff8000000000001)}
+
+	// The literal bytes of every value are decoded by the streamer, as they would be from ffmpeg.
+	var pcm bytes.Buffer
+	var bs [8]byte
+	for i := 0; i < 1000; i++ {
+		for _, value := range values {
+			binary.LittleEndian.PutUint64(bs[:], math.Float64bits(value))
+			pcm.Write(bs[:])
+		}
+	}
+	streamer := newPCMStreamer(&pcm)
+
+	samples := mixAll(t, 100*time.Millisecond, streamer)
+	if len(samples) != int(durationSamples(100*time.Millisecond)) {
+		t.Fatalf("mixed %d samples, want %d", len(samples), durationSamples(100*time.Millisecond))
+	}
+
+	for i, sample := range samples {
+		if math.IsNaN(sample) || math.IsInf(sample, 0) {
+			t.Fatalf("sample %d is %v", i, sample)
+		}
+
+		want := values[i%len(values)]
+		if math.IsNaN(want) || math.IsInf(want, 0) {
+			want = 0
+		}
+		if i < len(values)*1000 && sample != want {
+			t.Fatalf("sample %d is %v, want %v", i, sample, want)
+		}
+	}
+
+	if corrupt := streamer.CorruptSamples(); corrupt != 4000 {
+		t.Errorf("streamer counted %d corrupt samples, want 4000", corrupt)
+	}
+}
+
+func TestMaxCorruptSamples(t *testing.T) {
+	var pcm bytes.Buffer
+	var bs [8]byte
+	for i := 0; i < 9600; i++ {
+		binary.LittleEndian.PutUint64(bs[:], math.Float64bits(math.NaN()))
+		pcm.Write(bs[:])
+	}
+	streamer := newPCMStreamer(&pcm)
+	if err := streamer.SetMaxCorruptSamples(100); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, sample := range mixAll(t, 100*time.Millisecond, streamer) {
+		if sample != 0 {
+			t.Fatalf("sample %d is %v, want 0", i, sample)
+		}
+	}
+
+	if !streamer.closed.Load() {
+		t.Error("streamer wasn't closed after too many consecutive corrupt samples")
+	}
+	if streamer.Err() == nil {
+		t.Error("streamer was closed without an error")
+	}
+}
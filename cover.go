@@ -0,0 +1,63 @@
+package ffgoconv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// ErrNoCoverArt is returned when an input doesn't embed any cover art.
+var ErrNoCoverArt = errors.New("ffgoconv: cover: input has no cover art")
+
+// coverCodecs maps the image formats cover art can be extracted as to their ffmpeg codec.
+var coverCodecs = map[string]string{
+	"":     "copy",
+	"jpeg": "mjpeg",
+	"jpg":  "mjpeg",
+	"png":  "png",
+}
+
+// ExtractCover returns the cover art embedded in input, which may either be a file or a URL, as an image of the given
+// format, either "jpeg" or "png". An empty format returns the art as it is embedded, without converting it. If input
+// has no cover art, ErrNoCoverArt is returned.
+func ExtractCover(input, format string) ([]byte, error) {
+	codec, ok := coverCodecs[strings.ToLower(format)]
+	if !ok {
+		return nil, fmt.Errorf("ffgoconv: cover: unsupported image format %s", format)
+	}
+
+	ffmpegPath, err := lookFFmpeg("")
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	ffmpeg := exec.Command(ffmpegPath,
+		"-hide_banner",
+		"-v", "error",
+		"-i", input,
+		"-an",
+		"-map", "0:v:0",
+		"-frames:v", "1",
+		"-c:v", codec,
+		"-f", "image2pipe",
+		"pipe:1",
+	)
+	ffmpeg.Stdout = &stdout
+	ffmpeg.Stderr = &stderr
+
+	if err := ffmpeg.Run(); err != nil {
+		// Mapping the first video stream fails if there is none, which is where cover art is embedded.
+		if strings.Contains(stderr.String(), "matches no streams") {
+			return nil, ErrNoCoverArt
+		}
+		return nil, fmt.Errorf("ffgoconv: cover: error extracting cover art from %s: %w; %s", input, err, strings.TrimSpace(stderr.String()))
+	}
+	if stdout.Len() == 0 {
+		return nil, ErrNoCoverArt
+	}
+
+	return stdout.Bytes(), nil
+}
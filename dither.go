@@ -0,0 +1,56 @@
+package ffgoconv
+
+import (
+	"errors"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// ditherLSB is the size of one least significant bit of 16-bit audio, relative to full scale.
+const ditherLSB = 1.0 / 32768
+
+// ditherer adds triangular probability density function (TPDF) dither noise to samples before they are quantized.
+type ditherer struct {
+	rand *rand.Rand
+}
+
+// newDitherer returns an initialized *ditherer.
+func newDitherer() *ditherer {
+	return &ditherer{
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// dither returns sample with up to ±1 LSB of triangular noise added to it.
+func (ditherer *ditherer) dither(sample float64) float64 {
+	return sample + (ditherer.rand.Float64()-ditherer.rand.Float64())*ditherLSB
+}
+
+// isSixteenBitOutput returns whether or not the final stream encodes 16-bit integer samples, which is where dithering
+// matters. A sample format picked with -sample_fmt in extraArgs decides, such as s16 for flac, alac or wavpack, and
+// otherwise only the 16-bit PCM codecs are.
+func isSixteenBitOutput(codec string, extraArgs []string) bool {
+	sampleFormat := ""
+	for i := 0; i+1 < len(extraArgs); i++ {
+		if arg := extraArgs[i]; arg == "-sample_fmt" || strings.HasPrefix(arg, "-sample_fmt:") {
+			sampleFormat = extraArgs[i+1]
+		}
+	}
+	if sampleFormat != "" {
+		return sampleFormat == "s16" || sampleFormat == "s16p"
+	}
+	return strings.HasPrefix(codec, "pcm_") && strings.Contains(codec, "16")
+}
+
+// SetDither sets whether or not TPDF dither is applied to the finalized audio before it is written to the final stream.
+// Dithering only takes effect when the output is encoded as 16-bit samples, either by a 16-bit PCM codec or by picking
+// s16 or s16p with -sample_fmt in the extra output args, and is a no-op for float and higher bit depth outputs.
+func (transmuxer *Transmuxer) SetDither(dither bool) error {
+	if transmuxer.closed {
+		return errors.New("ffgoconv: transmuxer: closed")
+	}
+
+	transmuxer.dither.Store(dither)
+	return nil
+}
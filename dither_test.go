@@ -0,0 +1,68 @@
+package ffgoconv
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestDitherNoiseFloor(t *testing.T) {
+	ditherer := &ditherer{rand: rand.New(rand.NewSource(1))}
+
+	// A constant signal of a fraction of an LSB, which plain rounding would quantize away entirely.
+	for _, level := range []float64{0, 0.3, -0.7, 2.5} {
+		signal := level * ditherLSB
+
+		const samples = 200000
+		var sum, sumSquares, peak float64
+		for i := 0; i < samples; i++ {
+			quantized := float64(floatToS16(ditherer.dither(signal))) / 32768
+			err := (quantized - signal) / ditherLSB
+
+			sum += err
+			sumSquares += err * err
+			peak = math.Max(peak, math.Abs(err))
+		}
+		mean := sum / samples
+		rms := math.Sqrt(sumSquares / samples)
+
+		// TPDF dither of ±1 LSB makes the quantization error independent of the signal, with a mean of 0 and an RMS of
+		// sqrt(1/6 + 1/12) = 0.5 LSB, and it never strays further than 1.5 LSB from the signal.
+		if math.Abs(mean) > 0.01 {
+			t.Errorf("level %v LSB: mean error is %.4f LSB, want about 0", level, mean)
+		}
+		if rms < 0.45 || rms > 0.55 {
+			t.Errorf("level %v LSB: noise floor is %.4f LSB RMS, want about 0.5", level, rms)
+		}
+		if peak > 1.5 {
+			t.Errorf("level %v LSB: peak error is %.4f LSB, want at most 1.5", level, peak)
+		}
+	}
+}
+
+func TestIsSixteenBitOutput(t *testing.T) {
+	tests := []struct {
+		codec     string
+		extraArgs []string
+		want      bool
+	}{
+		{"pcm_s16le", nil, true},
+		{"pcm_s16be", nil, true},
+		{"pcm_s24le", nil, false},
+		{"pcm_f32le", nil, false},
+		{"flac", nil, false},
+		{"flac", []string{"-sample_fmt", "s16"}, true},
+		{"alac", []string{"-sample_fmt", "s16p"}, true},
+		{"wavpack", []string{"-compression_level", "3", "-sample_fmt:a", "s16p"}, true},
+		{"flac", []string{"-sample_fmt", "s32"}, false},
+		{"flac", []string{"-sample_fmt", "s16", "-sample_fmt", "s32"}, false},
+		{"pcm_s16le", []string{"-sample_fmt"}, true},
+		{"libopus", nil, false},
+	}
+
+	for _, test := range tests {
+		if got := isSixteenBitOutput(test.codec, test.extraArgs); got != test.want {
+			t.Errorf("isSixteenBitOutput(%q, %q) is %v, want %v", test.codec, test.extraArgs, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package ffgoconv
+
+import (
+	"errors"
+	"time"
+)
+
+// ducker lowers the gain of a set of target streamers while a trigger streamer is active.
+type ducker struct {
+	trigger *Streamer
+	targets []*Streamer
+
+	threshold float64
+	reduction float64
+
+	attackStep  float64
+	releaseStep float64
+
+	envelope float64
+}
+
+// duckingRule is a ducker along with its trigger and targets as of the start of a block, which the mix loop works with
+// without holding the lock as they may be swapped out by ReplaceStreamer in the meantime.
+type duckingRule struct {
+	ducker  *ducker
+	trigger *Streamer
+	targets []*Streamer
+}
+
+// update advances the ducking envelope by one sample, based on the current RMS level of trigger. The envelope is only
+// ever touched by the mix loop.
+func (ducker *ducker) update(trigger *Streamer) {
+	_, rms := trigger.Levels()
+
+	if rms > ducker.threshold {
+		ducker.envelope += ducker.attackStep
+		if ducker.envelope > 1.0 {
+			ducker.envelope = 1.0
+		}
+		return
+	}
+
+	ducker.envelope -= ducker.releaseStep
+	if ducker.envelope < 0.0 {
+		ducker.envelope = 0.0
+	}
+}
+
+// gain returns the gain currently applied to the targets.
+func (ducker *ducker) gain() float64 {
+	return 1.0 - ducker.reduction*ducker.envelope
+}
+
+// rampStep returns the per-sample envelope step needed to ramp fully over d.
+func rampStep(d time.Duration) float64 {
+	samples := float64(d) * 48000 * 2 / float64(time.Second)
+	if samples < 1 {
+		return 1.0
+	}
+	return 1.0 / samples
+}
+
+// SetDucking adds a ducking rule to the transmuxing session: whenever the RMS level of trigger exceeds threshold, the
+// gain of every streamer in targets ramps down by reduction over attack, and ramps back up over release once trigger
+// goes quiet again.
+//
+// The variable threshold is a linear RMS level measured after the trigger's volume, as returned by Levels.
+//
+// The variable reduction must be a floating-point number between 0 and 1, representing the percentage of gain to take
+// away from the targets. For example, a reduction of 0.7 lowers the targets to 30% of their volume.
+//
+// Rules are composable, with a streamer targeted by multiple rules being ducked by each of them. A rule is removed
+// once its trigger is closed and its targets have ramped back up over release.
+func (transmuxer *Transmuxer) SetDucking(trigger *Streamer, targets []*Streamer, threshold, reduction float64, attack, release time.Duration) error {
+	if transmuxer.closed {
+		return errors.New("ffgoconv: transmuxer: closed")
+	}
+
+	if trigger == nil {
+		return errors.New("ffgoconv: ducking: trigger must not be nil")
+	}
+	if threshold < 0.0 {
+		return errors.New("ffgoconv: ducking: threshold must not be less than 0.0")
+	}
+	if reduction < 0.0 || reduction > 1.0 {
+		return errors.New("ffgoconv: ducking: reduction must not be less than 0.0 (0%) or greater than 1.0 (100%)")
+	}
+	if attack < 0 || release < 0 {
+		return errors.New("ffgoconv: ducking: attack and release must not be negative")
+	}
+
+	ducker := &ducker{
+		trigger:     trigger,
+		targets:     append([]*Streamer(nil), targets...),
+		threshold:   threshold,
+		reduction:   reduction,
+		attackStep:  rampStep(attack),
+		releaseStep: rampStep(release),
+	}
+
+	transmuxer.Lock()
+	transmuxer.duckers = append(transmuxer.duckers, ducker)
+	transmuxer.Unlock()
+
+	return nil
+}
+
+// duckingRules appends the ducking rules of the transmuxing session to rules and returns them, dropping any rule whose
+// trigger has been closed and whose targets have ramped back up to full gain. It is called once per block by the mix
+// loop, which then applies the rules without holding the lock.
+func (transmuxer *Transmuxer) duckingRules(rules []duckingRule) []duckingRule {
+	transmuxer.Lock()
+	defer transmuxer.Unlock()
+
+	duckers := transmuxer.duckers[:0]
+	for _, ducker := range transmuxer.duckers {
+		if ducker.trigger.closed.Load() && ducker.envelope == 0.0 {
+			continue
+		}
+
+		rules = append(rules, duckingRule{
+			ducker:  ducker,
+			trigger: ducker.trigger,
+			targets: ducker.targets,
+		})
+		duckers = append(duckers, ducker)
+	}
+	for i := len(duckers); i < len(transmuxer.duckers); i++ {
+		transmuxer.duckers[i] = nil
+	}
+	transmuxer.duckers = duckers
+
+	return rules
+}
+
+// updateDucking advances every ducking rule by one sample and applies the resulting gains to their targets among
+// streamers. The trigger of a rule that has been closed is silent, so its targets ramp back up over release.
+func updateDucking(streamers []*Streamer, rules []duckingRule) {
+	if len(rules) == 0 {
+		return
+	}
+
+	for _, streamer := range streamers {
+		streamer.ducking = 1.0
+	}
+
+	for _, rule := range rules {
+		rule.ducker.update(rule.trigger)
+		gain := rule.ducker.gain()
+		for _, target := range rule.targets {
+			target.ducking *= gain
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package ffgoconv
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// endWaitTimeout is how long to wait for ffmpeg to exit once its output has ended, to learn whether or not it failed.
+const endWaitTimeout = time.Second
+
+var (
+	// ErrStreamerClosed is returned when using a streamer that has been closed.
+	ErrStreamerClosed = errors.New("ffgoconv: streamer: closed")
+	// ErrStreamEnded is returned once the output of a streamer has ended cleanly, with ffmpeg exiting successfully. It
+	// matches io.EOF with errors.Is.
+	ErrStreamEnded = fmt.Errorf("ffgoconv: streamer: stream ended: %w", io.EOF)
+)
+
+// StreamerError is returned once the output of a streamer has ended because ffmpeg failed.
+type StreamerError struct {
+	ExitCode int    // Exit code of ffmpeg, or -1 if it was terminated by a signal
+	Output   string // Tail of the stderr output of ffmpeg, which explains why it failed
+	Err      error  // Error returned by waiting for ffmpeg to exit
+}
+
+// Error implements error.
+func (err *StreamerError) Error() string {
+	if err.Output == "" {
+		return fmt.Sprintf("ffgoconv: streamer: ffmpeg exited: %v", err.Err)
+	}
+	return fmt.Sprintf("ffgoconv: streamer: ffmpeg exited: %v; ffmpeg output: %s", err.Err, err.Output)
+}
+
+// Unwrap returns the error returned by waiting for ffmpeg to exit.
+func (err *StreamerError) Unwrap() error {
+	return err.Err
+}
+
+// exitError returns a *StreamerError for the error ffmpeg exited with.
+func (streamer *Streamer) exitError(err error) *StreamerError {
+	exitCode := -1
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		exitCode = exitErr.ExitCode()
+	}
+
+	return &StreamerError{
+		ExitCode: exitCode,
+		Output:   strings.TrimSpace(streamer.FFmpegOutput()),
+		Err:      err,
+	}
+}
+
+// readErr returns the error to report for err, which ended a read of the output of ffmpeg.
+func (streamer *Streamer) readErr(err error) error {
+	if streamer.closed.Load() {
+		return ErrStreamerClosed
+	}
+	// The output is closed as soon as ffmpeg fails, so that also means the output has ended.
+	if errors.Is(err, io.EOF) || errors.Is(err, os.ErrClosed) {
+		return streamer.endErr()
+	}
+	return streamer.withOutput(err)
+}
+
+// endErr returns ErrStreamEnded if ffmpeg exited successfully once its output ended, or a *StreamerError if it didn't.
+func (streamer *Streamer) endErr() error {
+	streamer.Lock()
+	ffmpeg, exited := streamer.Process, streamer.exited
+	streamer.Unlock()
+
+	if ffmpeg == nil {
+		return ErrStreamEnded
+	}
+
+	select {
+	case <-exited:
+	case <-time.After(endWaitTimeout):
+		return ErrStreamEnded
+	}
+
+	streamer.Lock()
+	err := streamer.exitErr
+	streamer.Unlock()
+
+	if err == nil {
+		return ErrStreamEnded
+	}
+	return streamer.exitError(err)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/JoshuaDoes/ffgoconv"
+)
+
+// lowpass is a one-pole lowpass filter for interleaved 48kHz stereo audio
+type lowpass struct {
+	alpha float64
+	last  [2]float64
+}
+
+func newLowpass(cutoff float64) *lowpass {
+	rc := 1 / (2 * math.Pi * cutoff)
+	dt := 1.0 / 48000
+	return &lowpass{alpha: dt / (rc + dt)}
+}
+
+// Process implements ffgoconv.SampleProcessor, filtering the samples in place
+func (filter *lowpass) Process(samples []float64) []float64 {
+	for i := range samples {
+		channel := i % 2
+		filter.last[channel] += filter.alpha * (samples[i] - filter.last[channel])
+		samples[i] = filter.last[channel]
+	}
+	return samples
+}
+
+func main() {
+	//Make sure a cutoff and audio files are specified in program args
+	if len(os.Args) < 3 {
+		panic("You must specify a lowpass cutoff in Hz followed by one or more audio files to transmux")
+	}
+
+	cutoff, err := strconv.ParseFloat(os.Args[1], 64)
+	if err != nil {
+		panic(err)
+	}
+
+	//Get list of specified files
+	files := os.Args[2:]
+
+	//Remove test file if exists
+	os.Remove("./test.mp3")
+
+	//Create new transmuxing session, tell it to output encoded data to final stream's stdout, use MP3 params at 320Kbps
+	transmuxer, err := ffgoconv.NewTransmuxer(nil, "pipe:1", "libmp3lame", "mp3", "320k", 1)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	//Add all files to transmuxer, giving each streamer its own lowpass filter
+	for i, file := range files {
+		log.Println("Adding stream [:", i+1, "]:", file)
+		streamer, err := transmuxer.AddStreamer(file, nil, 1.0)
+		if err != nil {
+			panic(err)
+		}
+
+		err = streamer.AddProcessor(newLowpass(cutoff))
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	go transmuxer.Run()
+
+	log.Println("Sleeping for 5 seconds...")
+	time.Sleep(5 * time.Second)
+
+	log.Println("Reading as much as possible...")
+	test := make([]byte, 150000)
+	transmuxer.FinalStream.Read(test)
+
+	log.Println("Writing", len(test), "bytes to test.mp3...")
+	ioutil.WriteFile("test.mp3", test, 0644)
+}
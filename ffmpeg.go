@@ -0,0 +1,59 @@
+package ffgoconv
+
+import (
+	"fmt"
+	"os/exec"
+	"sync"
+)
+
+var (
+	binaryPathsMu sync.Mutex
+	ffmpegPath    = "ffmpeg"
+	ffprobePath   = "ffprobe"
+)
+
+// SetFFmpegPath sets the ffmpeg executable used by every session that doesn't specify its own, which is "ffmpeg" by
+// default. The path may either be a name to look up in $PATH or the location of the executable.
+func SetFFmpegPath(path string) {
+	binaryPathsMu.Lock()
+	defer binaryPathsMu.Unlock()
+
+	ffmpegPath = path
+}
+
+// SetFFprobePath sets the ffprobe executable used by every probe, which is "ffprobe" by default. The path may either be
+// a name to look up in $PATH or the location of the executable.
+func SetFFprobePath(path string) {
+	binaryPathsMu.Lock()
+	defer binaryPathsMu.Unlock()
+
+	ffprobePath = path
+}
+
+// lookFFmpeg resolves path, or the package-level ffmpeg path if path is empty, to an ffmpeg executable.
+func lookFFmpeg(path string) (string, error) {
+	if path == "" {
+		binaryPathsMu.Lock()
+		path = ffmpegPath
+		binaryPathsMu.Unlock()
+	}
+
+	resolved, err := exec.LookPath(path)
+	if err != nil {
+		return "", fmt.Errorf("ffgoconv: ffmpeg not found at %s: %v", path, err)
+	}
+	return resolved, nil
+}
+
+// lookFFprobe resolves the package-level ffprobe path to an ffprobe executable.
+func lookFFprobe() (string, error) {
+	binaryPathsMu.Lock()
+	path := ffprobePath
+	binaryPathsMu.Unlock()
+
+	resolved, err := exec.LookPath(path)
+	if err != nil {
+		return "", fmt.Errorf("ffgoconv: ffprobe not found at %s: %v", path, err)
+	}
+	return resolved, nil
+}
@@ -0,0 +1,161 @@
+package ffgoconv
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// FilterChain builds an ffmpeg audio filter chain, such as the value of StreamerOptions.AudioFilter, one filter at a
+// time. Parameters are validated as filters are added, with the first invalid parameter reported by Err.
+type FilterChain struct {
+	filters []string
+	err     error
+}
+
+// NewFilterChain returns an empty *FilterChain.
+func NewFilterChain() *FilterChain {
+	return &FilterChain{}
+}
+
+// Filter adds a filter with options, which are escaped as needed and passed in the order of their names.
+func (chain *FilterChain) Filter(name string, options map[string]string) *FilterChain {
+	if name == "" || strings.ContainsAny(name, "=:,;[]'\\ ") {
+		return chain.fail(fmt.Errorf("ffgoconv: filter: invalid filter name %q", name))
+	}
+
+	keys := make([]string, 0, len(options))
+	for key := range options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	args := make([]string, len(keys))
+	for i, key := range keys {
+		args[i] = key + "=" + EscapeFilterValue(options[key])
+	}
+
+	filter := name
+	if len(args) > 0 {
+		filter += "=" + strings.Join(args, ":")
+	}
+	return chain.add(filter)
+}
+
+// Volume adds a volume filter, where 1.0 leaves the volume unchanged.
+func (chain *FilterChain) Volume(volume float64) *FilterChain {
+	if volume < 0 {
+		return chain.fail(errors.New("ffgoconv: filter: volume must not be negative"))
+	}
+	return chain.add("volume=" + formatFloat(volume))
+}
+
+// LowPass adds a lowpass filter cutting frequencies above frequency Hz.
+func (chain *FilterChain) LowPass(frequency float64) *FilterChain {
+	if err := checkFilterFrequency(frequency); err != nil {
+		return chain.fail(err)
+	}
+	return chain.add("lowpass=f=" + formatFloat(frequency))
+}
+
+// HighPass adds a highpass filter cutting frequencies below frequency Hz.
+func (chain *FilterChain) HighPass(frequency float64) *FilterChain {
+	if err := checkFilterFrequency(frequency); err != nil {
+		return chain.fail(err)
+	}
+	return chain.add("highpass=f=" + formatFloat(frequency))
+}
+
+// Equalizer adds a peaking equalizer filter changing the gain of the band around frequency Hz by gain dB, with q
+// being the Q factor of the band.
+func (chain *FilterChain) Equalizer(frequency, q, gain float64) *FilterChain {
+	if err := checkFilterFrequency(frequency); err != nil {
+		return chain.fail(err)
+	}
+	if q <= 0 {
+		return chain.fail(errors.New("ffgoconv: filter: equalizer Q factor must be greater than 0"))
+	}
+	if gain < -900 || gain > 900 {
+		return chain.fail(errors.New("ffgoconv: filter: equalizer gain must not be less than -900 or greater than 900 dB"))
+	}
+	return chain.add("equalizer=f=" + formatFloat(frequency) + ":t=q:w=" + formatFloat(q) + ":g=" + formatFloat(gain))
+}
+
+// Loudnorm adds a single pass loudnorm filter normalizing to the given integrated loudness in LUFS, loudness range in
+// LU and true peak in dBTP. See LoudnessTarget and NormalizeLoudness for more accurate two pass normalization.
+func (chain *FilterChain) Loudnorm(integrated, loudnessRange, truePeak float64) *FilterChain {
+	if integrated < -70 || integrated > -5 {
+		return chain.fail(errors.New("ffgoconv: filter: integrated loudness must not be less than -70 or greater than -5 LUFS"))
+	}
+	if loudnessRange < 1 || loudnessRange > 50 {
+		return chain.fail(errors.New("ffgoconv: filter: loudness range must not be less than 1 or greater than 50 LU"))
+	}
+	if truePeak < -9 || truePeak > 0 {
+		return chain.fail(errors.New("ffgoconv: filter: true peak must not be less than -9 or greater than 0 dBTP"))
+	}
+	return chain.add("loudnorm=I=" + formatFloat(integrated) + ":LRA=" + formatFloat(loudnessRange) + ":TP=" + formatFloat(truePeak))
+}
+
+// Atempo adds the atempo filters changing the tempo of the audio without changing its pitch, where 1.0 is the
+// original speed. Like StreamerOptions.Tempo, it must be between 0.25 and 4.0.
+func (chain *FilterChain) Atempo(tempo float64) *FilterChain {
+	if tempo == 0 {
+		return chain.fail(errors.New("ffgoconv: filter: tempo must not be 0"))
+	}
+	if err := validateTempo(tempo); err != nil {
+		return chain.fail(errors.New("ffgoconv: filter: tempo must not be less than 0.25 or greater than 4.0"))
+	}
+	return chain.add(atempoFilter(tempo))
+}
+
+// Err returns the first error encountered while adding filters to the chain, if any.
+func (chain *FilterChain) Err() error {
+	return chain.err
+}
+
+// String returns the filter chain as passed to ffmpeg with -af, or an empty string if any of its filters were
+// invalid.
+func (chain *FilterChain) String() string {
+	if chain.err != nil {
+		return ""
+	}
+	return strings.Join(chain.filters, ",")
+}
+
+// add appends filter to the chain unless an earlier filter was invalid.
+func (chain *FilterChain) add(filter string) *FilterChain {
+	if chain.err == nil {
+		chain.filters = append(chain.filters, filter)
+	}
+	return chain
+}
+
+// fail records err unless an earlier filter was invalid.
+func (chain *FilterChain) fail(err error) *FilterChain {
+	if chain.err == nil {
+		chain.err = err
+	}
+	return chain
+}
+
+// checkFilterFrequency returns an error if frequency isn't a positive frequency below the Nyquist frequency of the
+// output.
+func checkFilterFrequency(frequency float64) error {
+	if frequency <= 0 || frequency >= 24000 {
+		return errors.New("ffgoconv: filter: frequency must be greater than 0 and less than 24000 Hz")
+	}
+	return nil
+}
+
+// filterOptionEscaper escapes the characters with a special meaning inside the option list of a single filter.
+var filterOptionEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`, `:`, `\:`)
+
+// filterGraphEscaper escapes the characters with a special meaning between the filters of a filtergraph.
+var filterGraphEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`, `[`, `\[`, `]`, `\]`, `,`, `\,`, `;`, `\;`)
+
+// EscapeFilterValue escapes value for use as a filter option inside a filter chain, such as a file name passed to
+// amovie on Windows, so that colons, commas, brackets, quotes and backslashes are passed through literally.
+func EscapeFilterValue(value string) string {
+	return filterGraphEscaper.Replace(filterOptionEscaper.Replace(value))
+}
@@ -0,0 +1,162 @@
+package ffgoconv
+
+import (
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestFilterChainString(t *testing.T) {
+	tests := []struct {
+		name  string
+		chain *FilterChain
+		want  string
+	}{
+		{
+			name:  "empty",
+			chain: NewFilterChain(),
+			want:  "",
+		},
+		{
+			name:  "volume and lowpass",
+			chain: NewFilterChain().Volume(0.5).LowPass(3000),
+			want:  "volume=0.5,lowpass=f=3000",
+		},
+		{
+			name:  "highpass and equalizer",
+			chain: NewFilterChain().HighPass(80.5).Equalizer(1000, 1.41, -3),
+			want:  "highpass=f=80.5,equalizer=f=1000:t=q:w=1.41:g=-3",
+		},
+		{
+			name:  "loudnorm",
+			chain: NewFilterChain().Loudnorm(-16, 11, -1.5),
+			want:  "loudnorm=I=-16:LRA=11:TP=-1.5",
+		},
+		{
+			name:  "atempo beyond a single stage",
+			chain: NewFilterChain().Atempo(3),
+			want:  "atempo=2.0,atempo=1.5",
+		},
+		{
+			name:  "options in the order of their names",
+			chain: NewFilterChain().Filter("aecho", map[string]string{"in_gain": "0.8", "out_gain": "0.9", "delays": "60|120", "decays": "0.4|0.3"}),
+			want:  "aecho=decays=0.4|0.3:delays=60|120:in_gain=0.8:out_gain=0.9",
+		},
+		{
+			name:  "filter without options",
+			chain: NewFilterChain().Filter("anull", nil).Volume(2),
+			want:  "anull,volume=2",
+		},
+		{
+			name:  "windows path inside amovie",
+			chain: NewFilterChain().Filter("amovie", map[string]string{"filename": `C:\Music\a, b.wav`}),
+			want:  `amovie=filename=C\\:\\\\Music\\\\a\, b.wav`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.chain.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if got := test.chain.String(); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFilterChainInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		chain *FilterChain
+	}{
+		{"negative volume", NewFilterChain().Volume(-1)},
+		{"lowpass at the nyquist frequency", NewFilterChain().LowPass(24000)},
+		{"highpass at 0 Hz", NewFilterChain().HighPass(0)},
+		{"equalizer without a Q factor", NewFilterChain().Equalizer(1000, 0, 3)},
+		{"loudnorm above -5 LUFS", NewFilterChain().Loudnorm(-4, 11, -1)},
+		{"tempo of 0", NewFilterChain().Atempo(0)},
+		{"tempo above 4.0", NewFilterChain().Atempo(4.5)},
+		{"empty filter name", NewFilterChain().Filter("", nil)},
+		{"filter name with a comma", NewFilterChain().Filter("anull,volume", nil)},
+		{"valid filters after an invalid one", NewFilterChain().Volume(-1).LowPass(3000)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.chain.Err() == nil {
+				t.Error("the chain has no error")
+			}
+			if got := test.chain.String(); got != "" {
+				t.Errorf("got %q, want an empty string", got)
+			}
+		})
+	}
+
+	// The first invalid filter is the one reported.
+	if err := NewFilterChain().Volume(-1).LowPass(0).Err(); !strings.Contains(err.Error(), "volume") {
+		t.Errorf("got error %v, want the volume error", err)
+	}
+}
+
+func TestEscapeFilterValue(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"song.wav", "song.wav"},
+		{"/music/a b.wav", "/music/a b.wav"},
+		{`C:\Music\a, b.wav`, `C\\:\\\\Music\\\\a\, b.wav`},
+		{"it's", `it\\\'s`},
+		{"[1];[2]", `\[1\]\;\[2\]`},
+		{"a=b", "a=b"},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if got := EscapeFilterValue(test.value); got != test.want {
+			t.Errorf("EscapeFilterValue(%q) is %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestFilterChainFFmpeg(t *testing.T) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg isn't on the PATH")
+	}
+
+	chains := []*FilterChain{
+		NewFilterChain().Volume(0.5).LowPass(3000),
+		NewFilterChain().HighPass(80.5).Equalizer(1000, 1.41, -3),
+		NewFilterChain().Loudnorm(-16, 11, -1.5),
+		NewFilterChain().Atempo(3),
+		NewFilterChain().Atempo(0.3),
+		NewFilterChain().Filter("aecho", map[string]string{"in_gain": "0.8", "out_gain": "0.9", "delays": "60|120", "decays": "0.4|0.3"}),
+	}
+	for _, chain := range chains {
+		output, err := exec.Command("ffmpeg", "-v", "error", "-f", "lavfi", "-i", "anullsrc=r=48000:cl=stereo", "-t", "0.1",
+			"-af", chain.String(), "-f", "null", "-").CombinedOutput()
+		if err != nil {
+			t.Errorf("ffmpeg rejected %q: %v\n%s", chain.String(), err, output)
+		}
+	}
+
+	// A file name with every character that has to be escaped must reach ffmpeg intact through amovie.
+	name := "it's [a], b;c.wav"
+	if runtime.GOOS != "windows" {
+		name = "a:" + name
+	}
+	path := filepath.Join(t.TempDir(), name)
+	if output, err := exec.Command("ffmpeg", "-v", "error", "-f", "lavfi", "-i", "anullsrc=r=48000:cl=stereo", "-t", "0.1",
+		path).CombinedOutput(); err != nil {
+		t.Fatalf("writing %q: %v\n%s", path, err, output)
+	}
+
+	graph := NewFilterChain().Filter("amovie", map[string]string{"filename": path}).String()
+	if output, err := exec.Command("ffmpeg", "-v", "error", "-f", "lavfi", "-i", graph, "-f", "null", "-").CombinedOutput(); err != nil {
+		t.Errorf("ffmpeg rejected %q: %v\n%s", graph, err, output)
+	}
+}
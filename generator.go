@@ -0,0 +1,104 @@
+package ffgoconv
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// generator is an io.Reader producing an endless stream of 48kHz stereo float64 PCM, with the same value on both
+// channels of every frame.
+type generator struct {
+	next func() float64
+
+	frame   [16]byte // sizeof(float64) * 2 channels
+	pending []byte
+}
+
+// Read implements io.Reader, filling all of p with generated samples.
+func (generator *generator) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(generator.pending) == 0 {
+			bits := math.Float64bits(generator.next())
+			binary.LittleEndian.PutUint64(generator.frame[0:], bits)
+			binary.LittleEndian.PutUint64(generator.frame[8:], bits)
+			generator.pending = generator.frame[:]
+		}
+
+		copied := copy(p[n:], generator.pending)
+		generator.pending = generator.pending[copied:]
+		n += copied
+	}
+	return n, nil
+}
+
+// newGeneratorStreamer returns an initialized *Streamer producing the values returned by next in pure Go, without an
+// ffmpeg process, until it is closed.
+func newGeneratorStreamer(next func() float64) *Streamer {
+	return newPCMStreamer(&generator{next: next})
+}
+
+// newPCMStreamer returns an initialized *Streamer decoding the 48kHz stereo float64 PCM read from r, without an ffmpeg
+// process.
+func newPCMStreamer(r io.Reader) *Streamer {
+	streamer := &Streamer{
+		running:    true,
+		ducking:    1.0,
+		sampleRate: 48000,
+		channels:   2,
+		precision:  PrecisionFloat64,
+		tempo:      1.0,
+		bufferSize: defaultPipeBufferSize,
+		ended:      make(chan struct{}),
+		progress:   make(chan TranscodeStats, 1),
+		stderrLog:  newStderrLog(strings.NewReader(""), nil, nil),
+	}
+	streamer.volume.Store(math.Float64bits(1.0))
+	streamer.probed = true
+	streamer.durationErr = ErrUnknownDuration
+	streamer.lastData.Store(time.Now().UnixNano())
+	streamer.autoGain.init()
+	streamer.stdout.Store(bufio.NewReaderSize(&dataReader{r: r, lastData: &streamer.lastData}, streamer.bufferSize))
+
+	return streamer
+}
+
+// NewToneStreamer returns an initialized *Streamer producing a sine wave of the given frequency in Hz and amplitude
+// between 0 and 1, or an error if one could not be created. The tone is generated in pure Go without running ffmpeg,
+// which makes it useful for testing and calibrating levels, and lasts until the streamer is closed.
+func NewToneStreamer(freqHz, amplitude float64) (*Streamer, error) {
+	if freqHz <= 0 || freqHz >= 24000 {
+		return nil, errors.New("ffgoconv: generator: frequency must be greater than 0Hz and less than 24000Hz")
+	}
+	if amplitude < 0 || amplitude > 1 {
+		return nil, errors.New("ffgoconv: generator: amplitude must not be less than 0.0 or greater than 1.0")
+	}
+
+	step := 2 * math.Pi * freqHz / 48000
+	phase := 0.0
+	return newGeneratorStreamer(func() float64 {
+		sample := amplitude * math.Sin(phase)
+		phase = math.Mod(phase+step, 2*math.Pi)
+		return sample
+	}), nil
+}
+
+// NewNoiseStreamer returns an initialized *Streamer producing white noise with the given amplitude between 0 and 1, or
+// an error if one could not be created. The noise is generated in pure Go without running ffmpeg from a fixed seed, so
+// that every noise streamer produces the same samples, and lasts until the streamer is closed.
+func NewNoiseStreamer(amplitude float64) (*Streamer, error) {
+	if amplitude < 0 || amplitude > 1 {
+		return nil, errors.New("ffgoconv: generator: amplitude must not be less than 0.0 or greater than 1.0")
+	}
+
+	random := rand.New(rand.NewSource(1))
+	return newGeneratorStreamer(func() float64 {
+		return amplitude * (2*random.Float64() - 1)
+	}), nil
+}
@@ -0,0 +1,190 @@
+package ffgoconv
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// icyMetaInt is the number of audio bytes sent between ICY metadata blocks.
+const icyMetaInt = 16000
+
+// httpContentTypes maps output formats to the content type they are served with.
+var httpContentTypes = map[string]string{
+	"adts": "audio/aac",
+	"flac": "audio/flac",
+	"mp3":  "audio/mpeg",
+	"ogg":  "audio/ogg",
+	"opus": "audio/ogg",
+	"wav":  "audio/wav",
+	"webm": "audio/webm",
+}
+
+// HTTPHandler is an http.Handler streaming the live encoded output of a transmuxing session to every client, in the
+// manner of an Icecast server. Clients join the stream as it is now rather than from the start, so formats with a stream
+// header of their own, such as ogg, can't be joined once the session has started. Formats that can be picked up at any
+// point, such as mp3 or adts, should be used instead.
+type HTTPHandler struct {
+	sync.Mutex
+
+	broadcaster *broadcaster
+	contentType string
+	title       string
+}
+
+// NewHTTPHandler returns an *HTTPHandler streaming the encoded output of transmuxer, which must have been created with
+// an output of "pipe:1", or an error if one could not be created. If contentType is empty, it is derived from the
+// output format.
+//
+// The output of the final stream is read from then on, and discarded while nothing is connected to it.
+func NewHTTPHandler(transmuxer *Transmuxer, contentType string) (*HTTPHandler, error) {
+	broadcaster, err := transmuxer.broadcaster()
+	if err != nil {
+		return nil, err
+	}
+
+	if contentType == "" {
+		contentType = httpContentTypes[transmuxer.format]
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+	}
+
+	return &HTTPHandler{broadcaster: broadcaster, contentType: contentType}, nil
+}
+
+// SetPrimeSize sets how many bytes of the most recent output are sent to clients as soon as they connect, so that
+// players lock onto the stream faster. Clients aren't primed by default.
+func (handler *HTTPHandler) SetPrimeSize(size int) error {
+	if size < 0 {
+		return errors.New("ffgoconv: http: prime size must not be negative")
+	}
+
+	handler.broadcaster.setPrime(size)
+	return nil
+}
+
+// SetTitle sets the title sent as ICY metadata to clients requesting it, such as the track that is now playing.
+func (handler *HTTPHandler) SetTitle(title string) {
+	handler.Lock()
+	defer handler.Unlock()
+
+	handler.title = title
+}
+
+// ServeHTTP implements http.Handler, streaming the output to the client until it disconnects or the output ends.
+func (handler *HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	icy := r.Header.Get("Icy-MetaData") == "1"
+
+	w.Header().Set("Content-Type", handler.contentType)
+	w.Header().Set("Cache-Control", "no-cache, no-store")
+	if icy {
+		w.Header().Set("icy-metaint", strconv.Itoa(icyMetaInt))
+	}
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	client, primed := handler.broadcaster.subscribe(false)
+	if client == nil {
+		return
+	}
+	defer handler.broadcaster.unsubscribe(client)
+
+	writer := &icyWriter{w: w, handler: handler, icy: icy}
+	flusher, _ := w.(http.Flusher)
+
+	send := func(chunk []byte) bool {
+		if _, err := writer.Write(chunk); err != nil {
+			return false
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return true
+	}
+
+	if len(primed) > 0 && !send(primed) {
+		return
+	}
+	for {
+		select {
+		case chunk, ok := <-client.chunks:
+			if !ok || !send(chunk) {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// icyWriter writes audio to a client, interleaving it with ICY metadata blocks every icyMetaInt bytes if the client
+// requested them.
+type icyWriter struct {
+	w       http.ResponseWriter
+	handler *HTTPHandler
+	icy     bool
+	sent    int
+}
+
+// Write implements io.Writer.
+func (writer *icyWriter) Write(p []byte) (int, error) {
+	if !writer.icy {
+		return writer.w.Write(p)
+	}
+
+	written := 0
+	for len(p) > 0 {
+		n := icyMetaInt - writer.sent
+		if n > len(p) {
+			n = len(p)
+		}
+		if _, err := writer.w.Write(p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		writer.sent += n
+		p = p[n:]
+
+		if writer.sent == icyMetaInt {
+			writer.handler.Lock()
+			title := writer.handler.title
+			writer.handler.Unlock()
+
+			if _, err := writer.w.Write(icyMetadata(title)); err != nil {
+				return written, err
+			}
+			writer.sent = 0
+		}
+	}
+	return written, nil
+}
+
+// icyMetadata returns an ICY metadata block announcing title, which is its length in 16-byte units followed by the
+// padded metadata.
+func icyMetadata(title string) []byte {
+	if title == "" {
+		return []byte{0}
+	}
+
+	metadata := "StreamTitle='" + strings.ReplaceAll(title, "'", "’") + "';"
+	if len(metadata) > 255*16 {
+		metadata = metadata[:255*16]
+	}
+	blocks := (len(metadata) + 15) / 16
+
+	block := make([]byte, 1+blocks*16)
+	block[0] = byte(blocks)
+	copy(block[1:], metadata)
+	return block
+}
@@ -0,0 +1,355 @@
+package ffgoconv
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// icecastDialTimeout is how long connecting to an Icecast server may take.
+	icecastDialTimeout = 10 * time.Second
+	// icecastWriteTimeout is how long sending a chunk of the output may take before the connection is considered lost.
+	icecastWriteTimeout = 10 * time.Second
+	// icecastReconnectDelayMax caps the exponential backoff between reconnects to an Icecast server.
+	icecastReconnectDelayMax = 30 * time.Second
+)
+
+// IcecastConfig contains the options used to stream to an Icecast server.
+type IcecastConfig struct {
+	URL      string // Address of the server, such as "http://localhost:8000", on port 8000 if it has none
+	Mount    string // Mount point to stream to, such as "/live.mp3"
+	Username string // Source username, which is "source" by default
+	Password string // Source password
+
+	// ContentType is the content type of the stream, which is derived from the output format if empty.
+	ContentType string
+
+	Name        string
+	Genre       string
+	Description string
+	Public      bool // Whether or not the server may list the stream in public directories
+
+	// MaxReconnects is how many times in a row reconnecting is attempted once the connection is lost. By default, it is 0
+	// and reconnecting is attempted forever, while -1 disables reconnecting so that the source stops streaming as soon
+	// as the connection is lost.
+	MaxReconnects int
+	// ReconnectDelay is how long to wait before the first reconnect, doubling after every attempt up to 30 seconds. It
+	// is 1 second by default.
+	ReconnectDelay time.Duration
+	// BufferDuringOutage keeps the most recent few hundred kilobytes of the output produced while disconnected and sends
+	// it once reconnected, instead of picking the stream back up as it is now. Anything older is dropped.
+	BufferDuringOutage bool
+}
+
+// IcecastSource streams the encoded output of a transmuxing session to an Icecast server as its source client.
+type IcecastSource struct {
+	sync.Mutex
+
+	config       IcecastConfig
+	server       *url.URL
+	broadcaster  *broadcaster
+	subscription *subscription
+
+	conn  net.Conn
+	err   error
+	stop  chan struct{}
+	done  chan struct{}
+	close sync.Once
+}
+
+// StreamToIcecast connects to an Icecast server and streams the encoded output of the transmuxing session to it from
+// then on, which must have been created with an output of "pipe:1". The connection is reestablished whenever it is
+// lost while the mix keeps running, according to the reconnect options of config.
+func (transmuxer *Transmuxer) StreamToIcecast(config IcecastConfig) (*IcecastSource, error) {
+	server, err := url.Parse(config.URL)
+	if err != nil || (server.Scheme != "http" && server.Scheme != "https") || server.Host == "" {
+		return nil, errors.New("ffgoconv: icecast: URL must be an http or https address")
+	}
+	// The port is resolved once, so that the stream and metadata updates both go to the same one.
+	if server.Port() == "" {
+		server.Host = net.JoinHostPort(server.Hostname(), "8000")
+	}
+	if !strings.HasPrefix(config.Mount, "/") {
+		return nil, errors.New("ffgoconv: icecast: mount must start with '/'")
+	}
+	if config.MaxReconnects < -1 {
+		return nil, errors.New("ffgoconv: icecast: maximum reconnects must not be less than -1")
+	}
+	if config.ReconnectDelay < 0 {
+		return nil, errors.New("ffgoconv: icecast: reconnect delay must not be negative")
+	}
+	if config.Username == "" {
+		config.Username = "source"
+	}
+	if config.ReconnectDelay == 0 {
+		config.ReconnectDelay = time.Second
+	}
+	if config.ContentType == "" {
+		config.ContentType = httpContentTypes[transmuxer.format]
+		if config.ContentType == "" {
+			return nil, fmt.Errorf("ffgoconv: icecast: content type of format %s is unknown", transmuxer.format)
+		}
+	}
+
+	broadcaster, err := transmuxer.broadcaster()
+	if err != nil {
+		return nil, err
+	}
+
+	source := &IcecastSource{
+		config:      config,
+		server:      server,
+		broadcaster: broadcaster,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	if source.conn, err = source.connect(); err != nil {
+		return nil, err
+	}
+
+	source.subscription, _ = broadcaster.subscribe(true)
+	if source.subscription == nil {
+		source.conn.Close()
+		return nil, errors.New("ffgoconv: icecast: transmuxer output has ended")
+	}
+
+	go source.run()
+	return source, nil
+}
+
+// connect connects to the server and performs the source handshake, returning the connection to stream to.
+func (source *IcecastSource) connect() (net.Conn, error) {
+	host := source.server.Host
+
+	dialer := &net.Dialer{Timeout: icecastDialTimeout}
+	var conn net.Conn
+	var err error
+	if source.server.Scheme == "https" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, &tls.Config{ServerName: source.server.Hostname()})
+	} else {
+		conn, err = dialer.Dial("tcp", host)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ffgoconv: icecast: error connecting to %s: %w", host, err)
+	}
+
+	public := "0"
+	if source.config.Public {
+		public = "1"
+	}
+
+	var request strings.Builder
+	fmt.Fprintf(&request, "PUT %s HTTP/1.1\r\n", source.config.Mount)
+	fmt.Fprintf(&request, "Host: %s\r\n", source.server.Host)
+	fmt.Fprintf(&request, "Authorization: %s\r\n", source.authorization())
+	fmt.Fprintf(&request, "User-Agent: ffgoconv\r\n")
+	fmt.Fprintf(&request, "Content-Type: %s\r\n", source.config.ContentType)
+	fmt.Fprintf(&request, "Ice-Public: %s\r\n", public)
+	for header, value := range map[string]string{
+		"Ice-Name":        source.config.Name,
+		"Ice-Genre":       source.config.Genre,
+		"Ice-Description": source.config.Description,
+	} {
+		if value != "" {
+			fmt.Fprintf(&request, "%s: %s\r\n", header, strings.NewReplacer("\r", "", "\n", "").Replace(value))
+		}
+	}
+	fmt.Fprintf(&request, "Expect: 100-continue\r\n\r\n")
+
+	conn.SetDeadline(time.Now().Add(icecastDialTimeout))
+	if _, err := conn.Write([]byte(request.String())); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("ffgoconv: icecast: error sending handshake: %w", err)
+	}
+
+	// The server either asks to continue or accepts the stream outright, without any body of its own.
+	response := textproto.NewReader(bufio.NewReader(conn))
+	status, err := response.ReadLine()
+	if err == nil {
+		_, err = response.ReadMIMEHeader()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("ffgoconv: icecast: error reading handshake response: %w", err)
+	}
+	if fields := strings.Fields(status); len(fields) < 2 || (fields[1] != "100" && fields[1] != "200") {
+		conn.Close()
+		return nil, fmt.Errorf("ffgoconv: icecast: server refused the stream: %s", status)
+	}
+	conn.SetDeadline(time.Time{})
+
+	return conn, nil
+}
+
+// authorization returns the basic authorization header of the source credentials.
+func (source *IcecastSource) authorization() string {
+	credentials := source.config.Username + ":" + source.config.Password
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+}
+
+// run streams the output to the server until the output ends or the source is closed, reconnecting whenever the
+// connection is lost.
+func (source *IcecastSource) run() {
+	defer close(source.done)
+	defer source.broadcaster.unsubscribe(source.subscription)
+
+	for {
+		err := source.stream()
+		if err == nil {
+			return
+		}
+
+		conn, err := source.reconnect(err)
+		if err != nil {
+			source.Lock()
+			source.err = err
+			source.Unlock()
+			return
+		}
+		if conn == nil {
+			return
+		}
+
+		source.Lock()
+		source.conn = conn
+		source.Unlock()
+	}
+}
+
+// stream sends chunks of the output to the server until the output ends or the source is closed, in which case it
+// returns nil, or until the connection is lost.
+func (source *IcecastSource) stream() error {
+	source.Lock()
+	conn := source.conn
+	source.Unlock()
+
+	for {
+		select {
+		case chunk, ok := <-source.subscription.chunks:
+			if !ok {
+				conn.Close()
+				return nil
+			}
+			// A stalled server makes the write time out, which is handled like any other lost connection.
+			conn.SetWriteDeadline(time.Now().Add(icecastWriteTimeout))
+			if _, err := conn.Write(chunk); err != nil {
+				conn.Close()
+				select {
+				case <-source.stop:
+					return nil
+				default:
+					return err
+				}
+			}
+		case <-source.stop:
+			return nil
+		}
+	}
+}
+
+// reconnect reconnects to the server after the connection was lost with err, backing off between attempts. It returns
+// a nil connection if the source was closed in the meantime.
+func (source *IcecastSource) reconnect(err error) (net.Conn, error) {
+	delay := source.config.ReconnectDelay
+	for attempt := 1; source.config.MaxReconnects == 0 || attempt <= source.config.MaxReconnects; attempt++ {
+		packageLog().Infof("ffgoconv: icecast: reconnecting in %v after losing the connection: %v", delay, err)
+
+		select {
+		case <-time.After(delay):
+		case <-source.stop:
+			return nil, nil
+		}
+
+		var conn net.Conn
+		if conn, err = source.connect(); err == nil {
+			if !source.config.BufferDuringOutage {
+				source.drain()
+			}
+			return conn, nil
+		}
+
+		if delay *= 2; delay > icecastReconnectDelayMax {
+			delay = icecastReconnectDelayMax
+		}
+	}
+	return nil, fmt.Errorf("ffgoconv: icecast: gave up reconnecting: %w", err)
+}
+
+// drain discards the output that piled up while disconnected.
+func (source *IcecastSource) drain() {
+	for {
+		select {
+		case <-source.subscription.chunks:
+		default:
+			return
+		}
+	}
+}
+
+// SetNowPlaying updates the title of the track that is now playing on the server, through its admin endpoint.
+func (source *IcecastSource) SetNowPlaying(title string) error {
+	query := url.Values{}
+	query.Set("mount", source.config.Mount)
+	query.Set("mode", "updinfo")
+	query.Set("song", title)
+
+	endpoint := *source.server
+	endpoint.Path = "/admin/metadata"
+	endpoint.RawQuery = query.Encode()
+
+	request, err := http.NewRequest(http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", source.authorization())
+	request.Header.Set("User-Agent", "ffgoconv")
+
+	client := &http.Client{Timeout: icecastDialTimeout}
+	response, err := client.Do(request)
+	if err != nil {
+		return fmt.Errorf("ffgoconv: icecast: error updating metadata: %w", err)
+	}
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("ffgoconv: icecast: error updating metadata: %s", response.Status)
+	}
+	return nil
+}
+
+// Done returns a channel that is closed once the source has stopped streaming, either because it was closed, the
+// output ended or reconnecting failed, which Err reports.
+func (source *IcecastSource) Done() <-chan struct{} {
+	return source.done
+}
+
+// Err returns the error that stopped the source from streaming, if any.
+func (source *IcecastSource) Err() error {
+	source.Lock()
+	defer source.Unlock()
+
+	return source.err
+}
+
+// Close stops streaming and disconnects from the server.
+func (source *IcecastSource) Close() error {
+	source.close.Do(func() {
+		close(source.stop)
+
+		source.Lock()
+		source.conn.Close()
+		source.Unlock()
+	})
+	<-source.done
+	return nil
+}
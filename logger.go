@@ -0,0 +1,96 @@
+package ffgoconv
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// Logger receives the diagnostic output of ffgoconv, such as ffmpeg processes being started, restarted or failing.
+type Logger interface {
+	Debugf(format string, args ...any)
+	Infof(format string, args ...any)
+	Errorf(format string, args ...any)
+}
+
+// nopLogger discards everything logged to it, and is used until a logger is set.
+type nopLogger struct{}
+
+func (nopLogger) Debugf(string, ...any) {}
+func (nopLogger) Infof(string, ...any)  {}
+func (nopLogger) Errorf(string, ...any) {}
+
+// packageLogger is the logger used by every session that doesn't set its own.
+var packageLogger atomic.Pointer[Logger]
+
+func init() {
+	SetLogger(nil)
+}
+
+// SetLogger sets the logger used by every session that doesn't set its own. Nothing is logged by default, and a nil
+// logger discards everything again.
+func SetLogger(logger Logger) {
+	if logger == nil {
+		logger = nopLogger{}
+	}
+	packageLogger.Store(&logger)
+}
+
+// SetLogger sets the logger used by the streamer in place of the package-level logger, or restores the package-level
+// logger if logger is nil.
+func (streamer *Streamer) SetLogger(logger Logger) error {
+	if streamer.closed.Load() {
+		return ErrStreamerClosed
+	}
+
+	streamer.setLogger(logger)
+	return nil
+}
+
+// SetLogger sets the logger used by the transmuxing session in place of the package-level logger, or restores the
+// package-level logger if logger is nil. The logger is also used by the final stream and by every streamer added to the
+// session afterwards, unless it sets its own.
+func (transmuxer *Transmuxer) SetLogger(logger Logger) error {
+	if transmuxer.closed {
+		return errors.New("ffgoconv: transmuxer: closed")
+	}
+
+	if logger == nil {
+		transmuxer.logger.Store(nil)
+	} else {
+		transmuxer.logger.Store(&logger)
+	}
+	if transmuxer.FinalStream != nil {
+		transmuxer.FinalStream.setLogger(logger)
+	}
+	return nil
+}
+
+// setLogger sets the logger of the streamer, restoring the package-level logger if logger is nil.
+func (streamer *Streamer) setLogger(logger Logger) {
+	if logger == nil {
+		streamer.logger.Store(nil)
+		return
+	}
+	streamer.logger.Store(&logger)
+}
+
+// log returns the logger of the streamer.
+func (streamer *Streamer) log() Logger {
+	if logger := streamer.logger.Load(); logger != nil {
+		return *logger
+	}
+	return packageLog()
+}
+
+// log returns the logger of the transmuxing session.
+func (transmuxer *Transmuxer) log() Logger {
+	if logger := transmuxer.logger.Load(); logger != nil {
+		return *logger
+	}
+	return packageLog()
+}
+
+// packageLog returns the package-level logger.
+func packageLog() Logger {
+	return *packageLogger.Load()
+}
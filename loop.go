@@ -0,0 +1,144 @@
+package ffgoconv
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// loopArgs returns the ffmpeg input args that make ffmpeg loop its input, unless it is looped by restarting ffmpeg.
+func (options *StreamerOptions) loopArgs() []string {
+	if options.Loop == 0 || options.LoopByRestart {
+		return nil
+	}
+	return []string{"-stream_loop", strconv.Itoa(options.Loop)}
+}
+
+// validateLoopOptions returns an error if the loop options can't be applied to the input.
+func (options *StreamerOptions) validateLoopOptions() error {
+	if options.Loop < -1 {
+		return errors.New("ffgoconv: streamer: loop must not be less than -1")
+	}
+	if options.Loop != 0 && options.Reader != nil {
+		return errors.New("ffgoconv: streamer: inputs read from an io.Reader can't be looped")
+	}
+	return nil
+}
+
+// canLoop returns whether or not ffmpeg is to be restarted once its output has been read to the end. The lock must be
+// held.
+func (streamer *Streamer) canLoop() bool {
+	return streamer.loopArgs != nil && streamer.loopsLeft != 0 && !streamer.closed.Load()
+}
+
+// loop restarts ffmpeg to play the input again once its output has been read to the end, returning whether or not it
+// was restarted. The new output is spliced onto the old one, so the streamer never reports the end of its output
+// between repetitions.
+func (streamer *Streamer) loop() bool {
+	streamer.Lock()
+	exited := streamer.exited
+	looping := streamer.loopArgs != nil
+	streamer.Unlock()
+
+	if !looping {
+		return false
+	}
+
+	// ffmpeg closes its output as it exits, so it is only restarted once it is known to have finished cleanly.
+	<-exited
+
+	streamer.Lock()
+	if streamer.exitErr != nil || !streamer.canLoop() {
+		streamer.Unlock()
+		return false
+	}
+	if streamer.loopsLeft > 0 {
+		streamer.loopsLeft--
+	}
+	args := streamer.loopArgs
+	streamer.Unlock()
+
+	if err := streamer.respawn(args); err != nil {
+		streamer.setError(err)
+		streamer.end()
+		return false
+	}
+	return true
+}
+
+// respawn starts a new ffmpeg process with the given args in place of the one that has exited, closing the pipes of
+// the old one.
+func (streamer *Streamer) respawn(args []string) error {
+	ffmpeg, stdinPipe, stdoutPipe, stderrPipe, err := startFFmpeg(streamer.ffmpegPath, args)
+	if err != nil {
+		return err
+	}
+
+	streamer.Lock()
+	if streamer.closed.Load() {
+		streamer.Unlock()
+
+		kill(ffmpeg.Process)
+		ffmpeg.Wait()
+		stdinPipe.Close()
+		stdoutPipe.Close()
+		stderrPipe.Close()
+		return ErrStreamerClosed
+	}
+
+	pipes := []io.Closer{streamer.Stderr, streamer.Stdin, streamer.Stdout}
+	streamer.attach(ffmpeg, stdinPipe, stdoutPipe, stderrPipe)
+	streamer.Unlock()
+
+	streamer.log().Debugf("ffgoconv: streamer: restarted %s %s", streamer.ffmpegPath, strings.Join(args, " "))
+
+	for _, pipe := range pipes {
+		pipe.Close()
+	}
+	return nil
+}
+
+// restart replaces the running ffmpeg process with one decoding the input according to options from the given
+// position, which becomes the new offset of the streamer.
+func (streamer *Streamer) restart(options *StreamerOptions, position time.Duration) error {
+	restartArgs := options.streamerArgs(options.Input)
+	ffmpeg, stdinPipe, stdoutPipe, stderrPipe, err := startFFmpeg(streamer.ffmpegPath, withSeek(restartArgs, position))
+	if err != nil {
+		return err
+	}
+
+	streamer.Lock()
+	if streamer.closed.Load() {
+		streamer.Unlock()
+
+		kill(ffmpeg.Process)
+		ffmpeg.Wait()
+		stdinPipe.Close()
+		stdoutPipe.Close()
+		stderrPipe.Close()
+		return ErrStreamerClosed
+	}
+
+	old, oldStdin, oldExited := streamer.Process, streamer.Stdin, streamer.exited
+	oldStdout := streamer.Stdout
+
+	streamer.options = *options
+	streamer.offset = position
+	streamer.tempo = options.tempo()
+	streamer.consumed.Store(0)
+	if streamer.loopArgs != nil {
+		streamer.loopArgs = restartArgs
+	}
+	// Restarting after a failure resumes with the new options, rather than reverting to the ones first started with.
+	if streamer.startArgs != nil {
+		streamer.startArgs = withSeek(restartArgs, position)
+	}
+	streamer.attach(ffmpeg, stdinPipe, stdoutPipe, stderrPipe)
+	streamer.Unlock()
+
+	// Closing the old output interrupts a read that is blocked on it, which then carries on with the new output.
+	oldStdout.Close()
+	return stop(old, oldStdin, oldExited, 0)
+}
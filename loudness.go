@@ -0,0 +1,273 @@
+package ffgoconv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// LoudnessTarget is the EBU R128 loudness to normalize audio to. Any field left at 0 uses the default of ffmpeg's
+// loudnorm filter.
+type LoudnessTarget struct {
+	Integrated float64 // Integrated loudness in LUFS, between -70 and -5, which is -24 by default
+	TruePeak   float64 // Maximum true peak in dBTP, between -9 and 0, which is -2 by default
+	Range      float64 // Loudness range in LU, between 1 and 50, which is 7 by default
+}
+
+// PodcastLoudness is the loudness commonly recommended for podcasts and spoken word.
+var PodcastLoudness = LoudnessTarget{Integrated: -16, TruePeak: -1.5, Range: 11}
+
+// LoudnessMeasurement contains the loudness of an input as measured by the first pass of ffmpeg's loudnorm filter.
+type LoudnessMeasurement struct {
+	Integrated float64 // Integrated loudness in LUFS
+	TruePeak   float64 // True peak in dBTP
+	Range      float64 // Loudness range in LU
+	Threshold  float64 // Gating threshold in LUFS
+	Offset     float64 // Gain offset in LU applied by the second pass to hit the target
+}
+
+// LoudnessInfo contains the loudness of an input as analyzed by ffmpeg's ebur128 filter.
+type LoudnessInfo struct {
+	Integrated float64 // Integrated loudness in LUFS
+	Range      float64 // Loudness range in LU
+	TruePeak   float64 // True peak in dBFS
+	SamplePeak float64 // Sample peak in dBFS
+}
+
+// validate returns an error if the target is out of the range supported by loudnorm.
+func (target LoudnessTarget) validate() error {
+	if target.Integrated != 0 && (target.Integrated < -70 || target.Integrated > -5) {
+		return errors.New("ffgoconv: loudness: integrated loudness must be between -70 and -5 LUFS")
+	}
+	if target.TruePeak != 0 && (target.TruePeak < -9 || target.TruePeak > 0) {
+		return errors.New("ffgoconv: loudness: true peak must be between -9 and 0 dBTP")
+	}
+	if target.Range != 0 && (target.Range < 1 || target.Range > 50) {
+		return errors.New("ffgoconv: loudness: loudness range must be between 1 and 50 LU")
+	}
+	return nil
+}
+
+// filter returns the loudnorm filter for the target, followed by any extra options.
+func (target LoudnessTarget) filter(options ...string) string {
+	filter := []string{}
+	if target.Integrated != 0 {
+		filter = append(filter, "I="+formatFloat(target.Integrated))
+	}
+	if target.TruePeak != 0 {
+		filter = append(filter, "TP="+formatFloat(target.TruePeak))
+	}
+	if target.Range != 0 {
+		filter = append(filter, "LRA="+formatFloat(target.Range))
+	}
+	filter = append(filter, options...)
+	if len(filter) == 0 {
+		return "loudnorm"
+	}
+	return "loudnorm=" + strings.Join(filter, ":")
+}
+
+// Filter returns the second pass of ffmpeg's loudnorm filter normalizing the measured input to target, which may be
+// used as the StreamerOptions.AudioFilter of a streamer decoding the same input.
+func (measurement *LoudnessMeasurement) Filter(target LoudnessTarget) string {
+	return target.filter(
+		"measured_I="+formatFloat(measurement.Integrated),
+		"measured_TP="+formatFloat(measurement.TruePeak),
+		"measured_LRA="+formatFloat(measurement.Range),
+		"measured_thresh="+formatFloat(measurement.Threshold),
+		"offset="+formatFloat(measurement.Offset),
+		"linear=true",
+	)
+}
+
+// MeasureLoudness runs the first pass of ffmpeg's loudnorm filter over input, which may either be a file or a URL,
+// returning the loudness it measured for normalizing to target.
+func MeasureLoudness(input string, target LoudnessTarget) (*LoudnessMeasurement, error) {
+	if err := target.validate(); err != nil {
+		return nil, err
+	}
+
+	stderr, err := runAnalysis(context.Background(), input, target.filter("print_format=json"))
+	if err != nil {
+		return nil, err
+	}
+
+	// The measurement is printed as the last JSON object on stderr, with every value as a string.
+	start, end := bytes.LastIndexByte(stderr, '{'), bytes.LastIndexByte(stderr, '}')
+	if start < 0 || end < start {
+		return nil, errors.New("ffgoconv: loudness: loudnorm printed no measurement")
+	}
+
+	var output map[string]string
+	if err := json.Unmarshal(stderr[start:end+1], &output); err != nil {
+		return nil, fmt.Errorf("ffgoconv: loudness: error parsing loudnorm measurement: %w", err)
+	}
+
+	measurement := &LoudnessMeasurement{}
+	for key, value := range map[string]*float64{
+		"input_i":       &measurement.Integrated,
+		"input_tp":      &measurement.TruePeak,
+		"input_lra":     &measurement.Range,
+		"input_thresh":  &measurement.Threshold,
+		"target_offset": &measurement.Offset,
+	} {
+		if *value, err = strconv.ParseFloat(strings.TrimSpace(output[key]), 64); err != nil {
+			return nil, fmt.Errorf("ffgoconv: loudness: error parsing loudnorm %s: %w", key, err)
+		}
+	}
+	// Silence measures as -inf, which can't be normalized.
+	if math.IsInf(measurement.Integrated, 0) || math.IsInf(measurement.Threshold, 0) {
+		return nil, fmt.Errorf("ffgoconv: loudness: %s is silent", input)
+	}
+
+	return measurement, nil
+}
+
+// NormalizeLoudness measures the loudness of input and returns an initialized *Streamer decoding it normalized to
+// target with the second pass of ffmpeg's loudnorm filter, along with the measured loudness, or an error if one could
+// not be created. The options are used for the streamer as with NewStreamerWithOptions, with their input replaced by
+// input and the loudnorm filter applied ahead of their AudioFilter. If options is nil, the default options are used at
+// a volume of 1.0.
+func NormalizeLoudness(input string, target LoudnessTarget, options *StreamerOptions) (*Streamer, *LoudnessMeasurement, error) {
+	measurement, err := MeasureLoudness(input, target)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	normalized := StreamerOptions{Volume: 1.0}
+	if options != nil {
+		normalized = *options
+	}
+	normalized.Input = input
+	normalized.Reader = nil
+
+	filter := measurement.Filter(target)
+	if normalized.AudioFilter != "" {
+		filter += "," + normalized.AudioFilter
+	}
+	normalized.AudioFilter = filter
+
+	streamer, err := NewStreamerWithOptions(&normalized)
+	if err != nil {
+		return nil, nil, err
+	}
+	return streamer, measurement, nil
+}
+
+// AnalyzeLoudness analyzes the EBU R128 loudness of input, which may either be a file or a URL, without encoding it.
+func AnalyzeLoudness(input string) (*LoudnessInfo, error) {
+	return AnalyzeLoudnessContext(context.Background(), input)
+}
+
+// AnalyzeLoudnessContext is like AnalyzeLoudness, but kills ffmpeg if ctx is done before it finishes.
+func AnalyzeLoudnessContext(ctx context.Context, input string) (*LoudnessInfo, error) {
+	stderr, err := runAnalysis(ctx, input, "ebur128=peak=sample+true:framelog=verbose")
+	if err != nil {
+		return nil, err
+	}
+	return parseEBUR128Summary(string(stderr))
+}
+
+// parseEBUR128Summary parses the summary printed by ffmpeg's ebur128 filter once it is done, which is laid out as
+//
+//	Integrated loudness:
+//	  I:         -19.6 LUFS
+//	  Threshold: -30.0 LUFS
+//	Loudness range:
+//	  LRA:        10.4 LU
+//	  ...
+//	Sample peak:
+//	  Peak:       -0.3 dBFS
+//	True peak:
+//	  Peak:        0.1 dBFS
+//
+// Every value is looked up under its section, so that sections missing from or added by other versions of ffmpeg
+// don't matter. Values that weren't reported are left at 0.
+func parseEBUR128Summary(stderr string) (*LoudnessInfo, error) {
+	i := strings.LastIndex(stderr, "Summary:")
+	if i < 0 {
+		return nil, errors.New("ffgoconv: loudness: ebur128 printed no summary")
+	}
+
+	info := &LoudnessInfo{}
+	values := map[[2]string]*float64{
+		{"Integrated loudness", "I"}: &info.Integrated,
+		{"Loudness range", "LRA"}:    &info.Range,
+		{"Sample peak", "Peak"}:      &info.SamplePeak,
+		{"True peak", "Peak"}:        &info.TruePeak,
+	}
+
+	var section string
+	for _, line := range strings.Split(stderr[i+len("Summary:"):], "\n") {
+		line = strings.TrimSpace(line)
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if value == "" {
+			section = key
+			continue
+		}
+
+		target := values[[2]string{section, key}]
+		if target == nil {
+			continue
+		}
+		fields := strings.Fields(value)
+		if f, err := strconv.ParseFloat(fields[0], 64); err == nil {
+			*target = f
+		}
+	}
+
+	return info, nil
+}
+
+// runAnalysis decodes input through the given analysis filter without writing any output, returning the stderr of
+// ffmpeg, which the filter prints its results to.
+func runAnalysis(ctx context.Context, input, filter string) ([]byte, error) {
+	ffmpegPath, err := lookFFmpeg("")
+	if err != nil {
+		return nil, err
+	}
+
+	var stderr bytes.Buffer
+	ffmpeg := exec.CommandContext(ctx, ffmpegPath,
+		"-hide_banner",
+		"-nostats",
+		"-i", input,
+		"-map", "0:a:0",
+		"-af", filter,
+		"-f", "null",
+		"-",
+	)
+	ffmpeg.Stderr = &stderr
+
+	if err := ffmpeg.Run(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			err = ctxErr
+		}
+		return nil, fmt.Errorf("ffgoconv: loudness: error analyzing %s: %w; %s", input, err, lastLines(stderr.String(), 5))
+	}
+	return stderr.Bytes(), nil
+}
+
+// lastLines returns the last n lines of output, trimmed of surrounding whitespace.
+func lastLines(output string, n int) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formatFloat formats f as a number understood by ffmpeg filters.
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
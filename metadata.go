@@ -0,0 +1,88 @@
+package ffgoconv
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Metadata contains the common tags of an input, along with its duration and bitrate.
+type Metadata struct {
+	Title       string
+	Artist      string
+	Album       string
+	Genre       string
+	TrackNumber int // Number of the track on its album, or 0 if it is unknown
+	Date        string
+	Comments    string
+
+	Duration time.Duration // Duration of the input, or 0 if it is unknown
+	BitRate  int64         // Overall bitrate of the input in bits per second, or 0 if it is unknown
+
+	Tags map[string]string // Every tag of the input, keyed by its lowercased name
+}
+
+// GetMetadata probes input, which may either be a file or a URL, and returns its metadata. Tags are matched regardless
+// of case, as containers differ in whether they report "TITLE" or "title", and tags of the first audio stream fill in
+// for those missing from the container, which is where formats such as Ogg store them.
+func GetMetadata(input string) (*Metadata, error) {
+	info, err := Probe(input)
+	if err != nil {
+		return nil, err
+	}
+	return info.Metadata(), nil
+}
+
+// Metadata returns the metadata of the probed input.
+func (info *ProbeInfo) Metadata() *Metadata {
+	tags := make(map[string]string)
+	addTags := func(from map[string]string) {
+		for key, value := range from {
+			key = strings.ToLower(key)
+			if _, ok := tags[key]; !ok {
+				tags[key] = value
+			}
+		}
+	}
+
+	addTags(info.Format.Tags)
+	for _, stream := range info.Streams {
+		if stream.CodecType == "audio" {
+			addTags(stream.Tags)
+			break
+		}
+	}
+
+	metadata := &Metadata{
+		Title:    tags["title"],
+		Artist:   firstTag(tags, "artist", "album_artist"),
+		Album:    tags["album"],
+		Genre:    tags["genre"],
+		Date:     firstTag(tags, "date", "year"),
+		Comments: firstTag(tags, "comment", "description"),
+		Duration: info.Format.Duration,
+		BitRate:  info.Format.BitRate,
+		Tags:     tags,
+	}
+
+	// Tracks may be numbered out of the total, as in "3/12".
+	track, _, _ := strings.Cut(firstTag(tags, "track", "tracknumber"), "/")
+	metadata.TrackNumber, _ = strconv.Atoi(strings.TrimSpace(track))
+
+	return metadata
+}
+
+// Tag returns the value of the named tag regardless of its case, or an empty string if there is no such tag.
+func (metadata *Metadata) Tag(name string) string {
+	return metadata.Tags[strings.ToLower(name)]
+}
+
+// firstTag returns the value of the first of keys that is set in tags.
+func firstTag(tags map[string]string, keys ...string) string {
+	for _, key := range keys {
+		if value := tags[key]; value != "" {
+			return value
+		}
+	}
+	return ""
+}
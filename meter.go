@@ -0,0 +1,59 @@
+package ffgoconv
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// meter accumulates the peak and RMS level of a signal over a window of samples.
+//
+// Accumulation is done solely by the mix loop, while the last completed window is published as atomic float bits so
+// that readers never block the mix loop.
+type meter struct {
+	peak atomic.Uint64
+	rms  atomic.Uint64
+
+	windowPeak float64
+	windowSum  float64
+	windowLen  int64
+}
+
+// add accumulates a sample, publishing the levels of the window once it has reached size samples.
+func (meter *meter) add(sample float64, size int64) {
+	abs := math.Abs(sample)
+	if abs > meter.windowPeak {
+		meter.windowPeak = abs
+	}
+	meter.windowSum += sample * sample
+	meter.windowLen++
+
+	if meter.windowLen >= size {
+		meter.peak.Store(math.Float64bits(meter.windowPeak))
+		meter.rms.Store(math.Float64bits(math.Sqrt(meter.windowSum / float64(meter.windowLen))))
+
+		meter.windowPeak = 0
+		meter.windowSum = 0
+		meter.windowLen = 0
+	}
+}
+
+// levels returns the peak and RMS levels of the last completed window.
+func (meter *meter) levels() (peak, rms float64) {
+	return math.Float64frombits(meter.peak.Load()), math.Float64frombits(meter.rms.Load())
+}
+
+// reset drops the published levels back to zero.
+func (meter *meter) reset() {
+	meter.peak.Store(0)
+	meter.rms.Store(0)
+}
+
+// meterWindowSamples returns the number of interleaved samples that make up a metering window of duration d.
+func meterWindowSamples(d time.Duration) int64 {
+	samples := durationSamples(d)
+	if samples < 1 {
+		samples = 1
+	}
+	return samples
+}
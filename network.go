@@ -0,0 +1,81 @@
+package ffgoconv
+
+import (
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// httpInput returns whether or not input is an HTTP or HTTPS URL, and whether or not it is an HLS playlist.
+func httpInput(input string) (isHTTP, isHLS bool) {
+	parsed, err := url.Parse(input)
+	if err != nil {
+		return false, false
+	}
+
+	switch strings.ToLower(parsed.Scheme) {
+	case "http", "https":
+		return true, strings.HasSuffix(strings.ToLower(parsed.Path), ".m3u8")
+	}
+	return false, false
+}
+
+// validateNetworkOptions returns an error if any of the network options would break the requests made by ffmpeg.
+func (options *StreamerOptions) validateNetworkOptions() error {
+	if options.ReconnectDelayMax < 0 {
+		return errors.New("ffgoconv: streamer: reconnect delay must not be negative")
+	}
+	for key, value := range options.Headers {
+		if key == "" || strings.ContainsAny(key, ":\r\n") || strings.ContainsAny(value, "\r\n") {
+			return errors.New("ffgoconv: streamer: invalid header " + strconv.Quote(key))
+		}
+	}
+	if strings.ContainsAny(options.UserAgent, "\r\n") {
+		return errors.New("ffgoconv: streamer: user agent must not contain line breaks")
+	}
+	return nil
+}
+
+// networkArgs returns the ffmpeg input args that apply the network options to input, which are only supported by the
+// http protocol and are omitted for any other input. Each value is passed as a single arg, so no quoting is needed.
+func (options *StreamerOptions) networkArgs(input string) []string {
+	isHTTP, isHLS := httpInput(input)
+	if !isHTTP {
+		return nil
+	}
+
+	var args []string
+	// The HLS demuxer opens its segments itself, so reconnecting only applies to progressive inputs.
+	if options.Reconnect && !isHLS {
+		args = append(args, "-reconnect", "1", "-reconnect_streamed", "1")
+		if options.ReconnectDelayMax > 0 {
+			seconds := int64((options.ReconnectDelayMax + time.Second - 1) / time.Second)
+			args = append(args, "-reconnect_delay_max", strconv.FormatInt(seconds, 10))
+		}
+	}
+
+	if len(options.Headers) > 0 {
+		keys := make([]string, 0, len(options.Headers))
+		for key := range options.Headers {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		var headers strings.Builder
+		for _, key := range keys {
+			headers.WriteString(key + ": " + options.Headers[key] + "\r\n")
+		}
+		args = append(args, "-headers", headers.String())
+	}
+	if options.UserAgent != "" {
+		args = append(args, "-user_agent", options.UserAgent)
+	}
+	if options.Cookies != "" {
+		args = append(args, "-cookies", options.Cookies)
+	}
+
+	return args
+}
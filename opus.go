@@ -0,0 +1,119 @@
+package ffgoconv
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// oggPageHeaderSize is the size of the fixed part of an Ogg page header, up to and including the segment count.
+const oggPageHeaderSize = 27
+
+// OpusReader splits Ogg Opus, such as the output of a transmuxing session encoding with libopus into the ogg format,
+// into its individual Opus packets. This is the form Discord and other voice services expect audio in.
+//
+// For 20ms packets sent as soon as they are encoded, the session should be given ExtraOutputArgs of "-frame_duration",
+// "20", "-page_duration", "20000".
+type OpusReader struct {
+	r       *bufio.Reader
+	header  [oggPageHeaderSize]byte
+	lacing  []byte
+	payload []byte
+	packet  []byte
+	headers int
+	frame   []byte
+}
+
+// NewOpusReader returns an *OpusReader reading Ogg Opus from r.
+func NewOpusReader(r io.Reader) *OpusReader {
+	return &OpusReader{r: bufio.NewReader(r)}
+}
+
+// ReadPacket returns the next Opus packet, skipping the identification and comment headers at the start of the stream.
+// It returns io.EOF once the stream has ended. The returned packet is only valid until the next call.
+func (reader *OpusReader) ReadPacket() ([]byte, error) {
+	reader.packet = reader.packet[:0]
+
+	for {
+		if len(reader.lacing) == 0 {
+			if err := reader.readPage(); err != nil {
+				if err == io.EOF && len(reader.packet) > 0 {
+					err = io.ErrUnexpectedEOF
+				}
+				return nil, err
+			}
+			continue
+		}
+
+		// A packet is split into segments of 255 bytes, ending with the first segment shorter than that, which may
+		// cross over into the next page.
+		size := int(reader.lacing[0])
+		reader.lacing = reader.lacing[1:]
+		reader.packet = append(reader.packet, reader.payload[:size]...)
+		reader.payload = reader.payload[size:]
+		if size == 255 {
+			continue
+		}
+
+		if reader.headers < 2 {
+			if reader.headers == 0 && !bytes.HasPrefix(reader.packet, []byte("OpusHead")) {
+				return nil, errors.New("ffgoconv: opus: stream isn't Ogg Opus")
+			}
+			reader.headers++
+			reader.packet = reader.packet[:0]
+			continue
+		}
+		return reader.packet, nil
+	}
+}
+
+// Read implements io.Reader, reading the Opus packets in DCA framing, where every packet is prefixed with its size as a
+// little-endian int16.
+func (reader *OpusReader) Read(p []byte) (int, error) {
+	if len(reader.frame) == 0 {
+		packet, err := reader.ReadPacket()
+		if err != nil {
+			return 0, err
+		}
+
+		frame := binary.LittleEndian.AppendUint16(make([]byte, 0, 2+len(packet)), uint16(len(packet)))
+		reader.frame = append(frame, packet...)
+	}
+
+	n := copy(p, reader.frame)
+	reader.frame = reader.frame[n:]
+	return n, nil
+}
+
+// readPage reads the next Ogg page, returning io.EOF if the stream ended cleanly before it.
+func (reader *OpusReader) readPage() error {
+	if _, err := io.ReadFull(reader.r, reader.header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return errors.New("ffgoconv: opus: truncated Ogg page")
+		}
+		return err
+	}
+	if !bytes.Equal(reader.header[:4], []byte("OggS")) {
+		return errors.New("ffgoconv: opus: lost Ogg page sync")
+	}
+
+	lacing := make([]byte, reader.header[26])
+	if _, err := io.ReadFull(reader.r, lacing); err != nil {
+		return errors.New("ffgoconv: opus: truncated Ogg page")
+	}
+
+	size := 0
+	for _, segment := range lacing {
+		size += int(segment)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(reader.r, payload); err != nil {
+		return errors.New("ffgoconv: opus: truncated Ogg page")
+	}
+
+	reader.lacing = lacing
+	reader.payload = payload
+	return nil
+}
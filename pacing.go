@@ -0,0 +1,98 @@
+package ffgoconv
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// pacingInterval is the number of interleaved samples mixed between each check of the sample clock, 10ms at 48kHz stereo.
+	pacingInterval = 960
+	// pacingStep is the longest the mix loop sleeps at once while waiting for the sample clock.
+	pacingStep = 5 * time.Millisecond
+	// pacingMaxCatchUp is the furthest the mix loop may fall behind before the sample clock is re-anchored instead of
+	// bursting to catch up.
+	pacingMaxCatchUp = 200 * time.Millisecond
+)
+
+// pacer throttles the mix loop against a monotonic sample clock so it emits audio at exactly real time.
+type pacer struct {
+	start   time.Time
+	samples int64
+	pending int64
+
+	drift atomic.Int64
+}
+
+// samplesDuration returns the playback duration of the given amount of interleaved 48kHz stereo samples.
+func samplesDuration(samples int64) time.Duration {
+	return time.Duration(samples * int64(time.Second) / (48000 * 2))
+}
+
+// durationSamples returns the amount of interleaved 48kHz stereo samples that play for d.
+func durationSamples(d time.Duration) int64 {
+	return int64(d) * 48000 * 2 / int64(time.Second)
+}
+
+// tick accounts for the given amount of mixed samples, sleeping as needed to keep the mix loop in line with the sample
+// clock.
+func (pacer *pacer) tick(samples int64) {
+	pacer.pending += samples
+	if pacer.pending < pacingInterval {
+		return
+	}
+
+	now := time.Now()
+	if pacer.start.IsZero() {
+		pacer.start = now
+		pacer.samples = 0
+	}
+	pacer.samples += pacer.pending
+	pacer.pending = 0
+
+	expected := pacer.start.Add(samplesDuration(pacer.samples))
+	drift := now.Sub(expected)
+
+	// After a hiccup, treat the current position as the new origin rather than rushing to recover the lost time.
+	if drift > pacingMaxCatchUp {
+		pacer.start = now.Add(-samplesDuration(pacer.samples))
+		drift = 0
+	}
+
+	for drift < 0 {
+		wait := -drift
+		if wait > pacingStep {
+			wait = pacingStep
+		}
+		time.Sleep(wait)
+		drift = time.Since(expected)
+	}
+
+	pacer.drift.Store(int64(drift))
+}
+
+// reset stops the sample clock so it is re-anchored the next time pacing is enabled.
+func (pacer *pacer) reset() {
+	pacer.start = time.Time{}
+	pacer.samples = 0
+	pacer.pending = 0
+	pacer.drift.Store(0)
+}
+
+// SetRealtime sets whether or not the transmuxing session is paced to emit exactly 48000 frames per second of wall
+// time, which is what live outputs expect. By default, audio is mixed as fast as the pipes allow.
+func (transmuxer *Transmuxer) SetRealtime(realtime bool) error {
+	if transmuxer.closed {
+		return errors.New("ffgoconv: transmuxer: closed")
+	}
+
+	transmuxer.realtime.Store(realtime)
+	return nil
+}
+
+// Drift returns how far the transmuxing session is lagging behind real time, or a negative duration if it is ahead.
+// It is always 0 when real-time pacing is disabled.
+func (transmuxer *Transmuxer) Drift() time.Duration {
+	return time.Duration(transmuxer.pacer.drift.Load())
+}
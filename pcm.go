@@ -0,0 +1,108 @@
+package ffgoconv
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// The conversions below follow the convention of ffmpeg, where full scale is 32768 so that every int16 maps to a float
+// between -1.0 and 1.0 and back without loss. Floats are rounded to the nearest int16 and clamped to its range rather
+// than wrapped, with NaN converting to silence. None of them allocate, converting as many samples as fit into dst and
+// returning that count.
+
+// Float64ToS16LE converts samples from src to 16-bit little-endian PCM in dst, which holds 2 bytes per sample.
+func Float64ToS16LE(src []float64, dst []byte) int {
+	n := len(src)
+	if n > len(dst)/2 {
+		n = len(dst) / 2
+	}
+	for i, sample := range src[:n] {
+		binary.LittleEndian.PutUint16(dst[i*2:], uint16(floatToS16(sample)))
+	}
+	return n
+}
+
+// S16LEToFloat64 converts 16-bit little-endian PCM from src, which holds 2 bytes per sample, to samples in dst.
+func S16LEToFloat64(src []byte, dst []float64) int {
+	n := len(src) / 2
+	if n > len(dst) {
+		n = len(dst)
+	}
+	for i := range dst[:n] {
+		dst[i] = float64(int16(binary.LittleEndian.Uint16(src[i*2:]))) / 32768
+	}
+	return n
+}
+
+// Float32ToS16LE converts samples from src to 16-bit little-endian PCM in dst, which holds 2 bytes per sample.
+func Float32ToS16LE(src []float32, dst []byte) int {
+	n := len(src)
+	if n > len(dst)/2 {
+		n = len(dst) / 2
+	}
+	for i, sample := range src[:n] {
+		binary.LittleEndian.PutUint16(dst[i*2:], uint16(floatToS16(float64(sample))))
+	}
+	return n
+}
+
+// S16LEToFloat32 converts 16-bit little-endian PCM from src, which holds 2 bytes per sample, to samples in dst.
+func S16LEToFloat32(src []byte, dst []float32) int {
+	n := len(src) / 2
+	if n > len(dst) {
+		n = len(dst)
+	}
+	for i := range dst[:n] {
+		dst[i] = float32(int16(binary.LittleEndian.Uint16(src[i*2:]))) / 32768
+	}
+	return n
+}
+
+// Interleave interleaves the samples of the left and right channels into dst, which holds 2 samples per frame, and
+// returns the number of frames interleaved.
+func Interleave(left, right, dst []float64) int {
+	n := len(dst) / 2
+	if n > len(left) {
+		n = len(left)
+	}
+	if n > len(right) {
+		n = len(right)
+	}
+	for i := 0; i < n; i++ {
+		dst[i*2] = left[i]
+		dst[i*2+1] = right[i]
+	}
+	return n
+}
+
+// Deinterleave splits the interleaved stereo samples of src into the left and right channels, and returns the number of
+// frames split.
+func Deinterleave(src, left, right []float64) int {
+	n := len(src) / 2
+	if n > len(left) {
+		n = len(left)
+	}
+	if n > len(right) {
+		n = len(right)
+	}
+	for i := 0; i < n; i++ {
+		left[i] = src[i*2]
+		right[i] = src[i*2+1]
+	}
+	return n
+}
+
+// floatToS16 converts a sample to a 16-bit value, clamping it to the range of int16.
+func floatToS16(sample float64) int16 {
+	if math.IsNaN(sample) {
+		return 0
+	}
+	value := math.Round(sample * 32768)
+	if value > math.MaxInt16 {
+		return math.MaxInt16
+	}
+	if value < math.MinInt16 {
+		return math.MinInt16
+	}
+	return int16(value)
+}
@@ -0,0 +1,165 @@
+package ffgoconv
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func TestFloatToS16(t *testing.T) {
+	tests := []struct {
+		sample float64
+		want   int16
+	}{
+		{0, 0},
+		{0.5, 16384},
+		{-0.5, -16384},
+		{1.0, math.MaxInt16},
+		{-1.0, math.MinInt16},
+		{32767.0 / 32768, math.MaxInt16},
+		{1.0001, math.MaxInt16},
+		{-1.0001, math.MinInt16},
+		{1e9, math.MaxInt16},
+		{-1e9, math.MinInt16},
+		{0.5 / 32768, 1},
+		{-0.5 / 32768, -1},
+		{0.49 / 32768, 0},
+		{math.NaN(), 0},
+		{math.Inf(1), math.MaxInt16},
+		{math.Inf(-1), math.MinInt16},
+	}
+
+	for _, test := range tests {
+		dst := make([]byte, 2)
+		if n := Float64ToS16LE([]float64{test.sample}, dst); n != 1 {
+			t.Fatalf("Float64ToS16LE(%v) converted %d samples, want 1", test.sample, n)
+		}
+		if got := int16(binary.LittleEndian.Uint16(dst)); got != test.want {
+			t.Errorf("Float64ToS16LE(%v) is %d, want %d", test.sample, got, test.want)
+		}
+
+		Float32ToS16LE([]float32{float32(test.sample)}, dst)
+		if got := int16(binary.LittleEndian.Uint16(dst)); got != test.want {
+			t.Errorf("Float32ToS16LE(%v) is %d, want %d", float32(test.sample), got, test.want)
+		}
+	}
+}
+
+func TestS16LERoundTrip(t *testing.T) {
+	src := make([]byte, 65536*2)
+	for i := 0; i < 65536; i++ {
+		binary.LittleEndian.PutUint16(src[i*2:], uint16(i))
+	}
+
+	samples64 := make([]float64, 65536)
+	samples32 := make([]float32, 65536)
+	if n := S16LEToFloat64(src, samples64); n != 65536 {
+		t.Fatalf("S16LEToFloat64 converted %d samples, want 65536", n)
+	}
+	if n := S16LEToFloat32(src, samples32); n != 65536 {
+		t.Fatalf("S16LEToFloat32 converted %d samples, want 65536", n)
+	}
+
+	for i, sample := range samples64 {
+		if sample < -1 || sample >= 1 {
+			t.Fatalf("%#04x converted to %v, outside of [-1.0, 1.0)", i, sample)
+		}
+	}
+	if samples64[0x8000] != -1 || samples64[0x7FFF] != 32767.0/32768 {
+		t.Errorf("0x8000 and 0x7FFF converted to %v and %v, want -1 and %v", samples64[0x8000], samples64[0x7FFF], 32767.0/32768)
+	}
+
+	dst := make([]byte, len(src))
+	if n := Float64ToS16LE(samples64, dst); n != 65536 {
+		t.Fatalf("Float64ToS16LE converted %d samples, want 65536", n)
+	}
+	if i := firstDifference(src, dst); i >= 0 {
+		t.Errorf("float64 round trip changed %#04x to %#04x", i/2, binary.LittleEndian.Uint16(dst[i/2*2:]))
+	}
+
+	dst = make([]byte, len(src))
+	if n := Float32ToS16LE(samples32, dst); n != 65536 {
+		t.Fatalf("Float32ToS16LE converted %d samples, want 65536", n)
+	}
+	if i := firstDifference(src, dst); i >= 0 {
+		t.Errorf("float32 round trip changed %#04x to %#04x", i/2, binary.LittleEndian.Uint16(dst[i/2*2:]))
+	}
+}
+
+// firstDifference returns the index of the first byte that differs between a and b, which must be of equal length, or
+// -1 if they're equal.
+func firstDifference(a, b []byte) int {
+	for i := range a {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestPCMCounts(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int
+		want int
+	}{
+		{"float64 to s16 with a short dst", Float64ToS16LE(make([]float64, 10), make([]byte, 9)), 4},
+		{"float64 to s16 with a short src", Float64ToS16LE(make([]float64, 3), make([]byte, 20)), 3},
+		{"s16 to float64 with an odd src", S16LEToFloat64(make([]byte, 7), make([]float64, 10)), 3},
+		{"s16 to float64 with a short dst", S16LEToFloat64(make([]byte, 20), make([]float64, 2)), 2},
+		{"float32 to s16 with a short dst", Float32ToS16LE(make([]float32, 10), make([]byte, 1)), 0},
+		{"s16 to float32 with an odd src", S16LEToFloat32(make([]byte, 5), make([]float32, 10)), 2},
+		{"interleave with a short right channel", Interleave(make([]float64, 5), make([]float64, 3), make([]float64, 20)), 3},
+		{"interleave with an odd dst", Interleave(make([]float64, 5), make([]float64, 5), make([]float64, 7)), 3},
+		{"deinterleave with an odd src", Deinterleave(make([]float64, 9), make([]float64, 10), make([]float64, 10)), 4},
+		{"deinterleave with a short left channel", Deinterleave(make([]float64, 10), make([]float64, 2), make([]float64, 10)), 2},
+		{"empty", Float64ToS16LE(nil, nil), 0},
+	}
+
+	for _, test := range tests {
+		if test.n != test.want {
+			t.Errorf("%s: converted %d, want %d", test.name, test.n, test.want)
+		}
+	}
+}
+
+func TestInterleaveRoundTrip(t *testing.T) {
+	src := []float64{0.1, -0.1, 0.2, -0.2, 0.3, -0.3}
+	left := make([]float64, 3)
+	right := make([]float64, 3)
+	Deinterleave(src, left, right)
+
+	for i := range left {
+		if left[i] != src[i*2] || right[i] != src[i*2+1] {
+			t.Fatalf("frame %d split into %v and %v, want %v and %v", i, left[i], right[i], src[i*2], src[i*2+1])
+		}
+	}
+
+	dst := make([]float64, len(src))
+	Interleave(left, right, dst)
+	for i := range dst {
+		if dst[i] != src[i] {
+			t.Fatalf("sample %d interleaved to %v, want %v", i, dst[i], src[i])
+		}
+	}
+}
+
+func TestPCMAllocs(t *testing.T) {
+	samples := make([]float64, 960)
+	samples32 := make([]float32, 960)
+	data := make([]byte, 960*2)
+	left := make([]float64, 480)
+	right := make([]float64, 480)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		Float64ToS16LE(samples, data)
+		S16LEToFloat64(data, samples)
+		Float32ToS16LE(samples32, data)
+		S16LEToFloat32(data, samples32)
+		Deinterleave(samples, left, right)
+		Interleave(left, right, samples)
+	})
+	if allocs != 0 {
+		t.Errorf("converting allocated %v times, want 0", allocs)
+	}
+}
@@ -0,0 +1,105 @@
+package ffgoconv
+
+import (
+	"errors"
+	"io"
+	"math"
+	"time"
+)
+
+// peaksSampleRate is the sample rate audio is decoded at to generate peaks.
+const peaksSampleRate = 48000
+
+// PeakPair is the lowest and highest sample within a bucket of a waveform, as 16-bit values.
+type PeakPair struct {
+	Min int16
+	Max int16
+}
+
+// GeneratePeaks decodes input, which may either be a file or a URL, and returns the peaks of its waveform in buckets
+// of samplesPerPixel mono samples at 48kHz, for rendering waveform previews, along with the duration it decoded. The
+// audio is streamed through in chunks, so memory use only grows with the number of peaks.
+func GeneratePeaks(input string, samplesPerPixel int) ([]PeakPair, time.Duration, error) {
+	return generatePeaks(&StreamerOptions{Input: input}, samplesPerPixel)
+}
+
+// GeneratePeaksFromReader is like GeneratePeaks, but decodes the audio read from r.
+func GeneratePeaksFromReader(r io.Reader, samplesPerPixel int) ([]PeakPair, time.Duration, error) {
+	if r == nil {
+		return nil, 0, errors.New("ffgoconv: peaks: reader must not be nil")
+	}
+	return generatePeaks(&StreamerOptions{Reader: r}, samplesPerPixel)
+}
+
+// generatePeaks decodes the input of options as mono and returns the peaks of every bucket of samplesPerPixel samples.
+func generatePeaks(options *StreamerOptions, samplesPerPixel int) ([]PeakPair, time.Duration, error) {
+	if samplesPerPixel <= 0 {
+		return nil, 0, errors.New("ffgoconv: peaks: samples per pixel must be positive")
+	}
+
+	options.Volume = 1.0
+	options.Precision = PrecisionFloat32
+	options.SampleRate = peaksSampleRate
+	options.Channels = 1
+
+	streamer, err := NewStreamerWithOptions(options)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer streamer.Close()
+
+	var peaks []PeakPair
+	var decoded int64
+	var bucket PeakPair
+	var bucketLen int
+
+	chunk := make([]float64, 4096)
+	for {
+		n, err := streamer.ReadSamples(chunk)
+		for _, sample := range chunk[:n] {
+			value := peakValue(sample)
+			if bucketLen == 0 || value < bucket.Min {
+				bucket.Min = value
+			}
+			if bucketLen == 0 || value > bucket.Max {
+				bucket.Max = value
+			}
+
+			bucketLen++
+			if bucketLen == samplesPerPixel {
+				peaks = append(peaks, bucket)
+				bucketLen = 0
+			}
+		}
+		decoded += int64(n)
+
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				return nil, 0, err
+			}
+			break
+		}
+	}
+
+	// The last bucket is kept even if the audio ended partway through it.
+	if bucketLen > 0 {
+		peaks = append(peaks, bucket)
+	}
+
+	return peaks, time.Duration(decoded) * time.Second / peaksSampleRate, nil
+}
+
+// peakValue converts a sample to a 16-bit value, clipping it to the range of int16.
+func peakValue(sample float64) int16 {
+	if math.IsNaN(sample) {
+		return 0
+	}
+	value := math.Round(sample * math.MaxInt16)
+	if value > math.MaxInt16 {
+		return math.MaxInt16
+	}
+	if value < math.MinInt16 {
+		return math.MinInt16
+	}
+	return int16(value)
+}
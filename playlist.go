@@ -0,0 +1,638 @@
+package ffgoconv
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+)
+
+// RepeatMode defines what a playlist plays once a track has ended.
+type RepeatMode int
+
+const (
+	// RepeatOff plays every track once, stopping after the last one. This is the default.
+	RepeatOff RepeatMode = iota
+	// RepeatOne plays the current track over and over until another one is picked with Next or Previous.
+	RepeatOne
+	// RepeatAll starts over from the first track after the last one, reshuffling the tracks if shuffle is enabled.
+	RepeatAll
+)
+
+// preparedTrack is the next track of a playlist, started and buffered ahead of time so that it can take over from the
+// current track without a gap.
+type preparedTrack struct {
+	position int
+	path     string
+	streamer *Streamer
+}
+
+// Playlist plays a list of tracks one after another through a transmuxing session, starting each track before the
+// previous one has ended so that transitions are gapless. Tracks that fail to play are skipped. A playlist is safe to
+// manipulate from multiple goroutines while it is playing.
+type Playlist struct {
+	sync.Mutex
+
+	transmuxer *Transmuxer
+	openTrack  func(path string) (*Streamer, error) // Starts the track at path without adding it to the session
+
+	items    []string
+	order    []int // Indices into items in the order they are played in
+	position int   // Position of the current track in order, or -1 before the first one
+	shuffle  bool
+	repeat   RepeatMode
+
+	current     *Streamer
+	currentPath string
+	next        *preparedTrack
+	generation  uint64 // Incremented whenever an upcoming track being prepared is no longer wanted
+	reshuffled  []int  // Order picked ahead of time for when the playlist starts over with shuffle enabled
+	playing     bool
+	closed      bool
+
+	onTrackStart func(path string)
+	onTrackEnd   func(path string, err error)
+	callbacks    []func()
+	notifying    bool
+}
+
+// NewPlaylist returns an empty *Playlist playing through transmuxer. It is closed along with the transmuxing session.
+func NewPlaylist(transmuxer *Transmuxer) (*Playlist, error) {
+	if transmuxer == nil {
+		return nil, errors.New("ffgoconv: playlist: transmuxer must not be nil")
+	}
+
+	playlist := &Playlist{
+		transmuxer: transmuxer,
+		position:   -1,
+	}
+	playlist.openTrack = func(path string) (*Streamer, error) {
+		return transmuxer.newStreamer(playlist.trackOptions(path))
+	}
+
+	go func() {
+		<-transmuxer.Done()
+		playlist.Close()
+	}()
+	return playlist, nil
+}
+
+// SetOnTrackStart sets a function to be called whenever a track starts playing. Callbacks are called from a separate
+// goroutine, one at a time and in the order their events happened in.
+func (playlist *Playlist) SetOnTrackStart(onTrackStart func(path string)) {
+	playlist.Lock()
+	defer playlist.Unlock()
+
+	playlist.onTrackStart = onTrackStart
+}
+
+// SetOnTrackEnd sets a function to be called whenever a track stops playing, receiving the error that made it stop if it
+// failed. Tracks that fail to start at all are reported as well. See SetOnTrackStart for how callbacks are called.
+func (playlist *Playlist) SetOnTrackEnd(onTrackEnd func(path string, err error)) {
+	playlist.Lock()
+	defer playlist.Unlock()
+
+	playlist.onTrackEnd = onTrackEnd
+}
+
+// Add appends the track at path, which may either be a file or a URL, to the playlist. With shuffle enabled, it is
+// inserted at a random position among the tracks that haven't been played yet.
+func (playlist *Playlist) Add(path string) error {
+	if path == "" {
+		return errors.New("ffgoconv: playlist: path must not be empty string")
+	}
+
+	playlist.Lock()
+	defer playlist.Unlock()
+
+	if playlist.closed {
+		return errors.New("ffgoconv: playlist: closed")
+	}
+
+	playlist.items = append(playlist.items, path)
+	item := len(playlist.items) - 1
+
+	at := len(playlist.order)
+	if playlist.shuffle {
+		at = playlist.position + 1 + rand.Intn(len(playlist.order)-playlist.position)
+	}
+	playlist.order = append(playlist.order, 0)
+	copy(playlist.order[at+1:], playlist.order[at:])
+	playlist.order[at] = item
+
+	playlist.refreshNext()
+	return nil
+}
+
+// Play starts playing the playlist from its current track, or from its first track if it hasn't played any yet. It does
+// nothing if the playlist is already playing.
+func (playlist *Playlist) Play() error {
+	playlist.Lock()
+	defer playlist.Unlock()
+
+	if playlist.closed {
+		return errors.New("ffgoconv: playlist: closed")
+	}
+	if playlist.playing {
+		return nil
+	}
+	if len(playlist.order) == 0 {
+		return errors.New("ffgoconv: playlist: playlist is empty")
+	}
+
+	position := playlist.position
+	if position < 0 || position >= len(playlist.order) {
+		position = 0
+	}
+	playlist.playing = true
+	playlist.play(position)
+	return nil
+}
+
+// Next skips to the next track, or starts over from the first track if repeating all tracks. Unlike a track ending on
+// its own, it skips the current track even when repeating it.
+func (playlist *Playlist) Next() error {
+	playlist.Lock()
+	defer playlist.Unlock()
+
+	if playlist.closed {
+		return errors.New("ffgoconv: playlist: closed")
+	}
+
+	position := playlist.position + 1
+	if position >= len(playlist.order) {
+		if playlist.repeat != RepeatAll || len(playlist.order) == 0 {
+			return errors.New("ffgoconv: playlist: no next track")
+		}
+		playlist.reshuffle()
+		position = 0
+	}
+
+	playlist.jump(position)
+	return nil
+}
+
+// Previous goes back to the previous track, or to the last track from the first one if repeating all tracks. The first
+// track is restarted when not repeating all tracks.
+func (playlist *Playlist) Previous() error {
+	playlist.Lock()
+	defer playlist.Unlock()
+
+	if playlist.closed {
+		return errors.New("ffgoconv: playlist: closed")
+	}
+	if len(playlist.order) == 0 {
+		return errors.New("ffgoconv: playlist: playlist is empty")
+	}
+
+	position := playlist.position - 1
+	if position < 0 {
+		position = 0
+		if playlist.repeat == RepeatAll {
+			position = len(playlist.order) - 1
+		}
+	}
+
+	playlist.jump(position)
+	return nil
+}
+
+// Shuffle sets whether or not the tracks are played in a random order. Enabling it shuffles every track except for the
+// current one, which keeps playing, while disabling it continues from the current track in the order they were added.
+func (playlist *Playlist) Shuffle(shuffle bool) error {
+	playlist.Lock()
+	defer playlist.Unlock()
+
+	if playlist.closed {
+		return errors.New("ffgoconv: playlist: closed")
+	}
+	if shuffle == playlist.shuffle {
+		return nil
+	}
+	playlist.shuffle = shuffle
+
+	current := -1
+	if playlist.position >= 0 && playlist.position < len(playlist.order) {
+		current = playlist.order[playlist.position]
+	}
+
+	playlist.order = make([]int, 0, len(playlist.items))
+	if shuffle {
+		if current >= 0 {
+			playlist.order = append(playlist.order, current)
+			playlist.position = 0
+		}
+		for _, item := range rand.Perm(len(playlist.items)) {
+			if item != current {
+				playlist.order = append(playlist.order, item)
+			}
+		}
+	} else {
+		for item := range playlist.items {
+			playlist.order = append(playlist.order, item)
+		}
+		if current >= 0 {
+			playlist.position = current
+		}
+	}
+
+	playlist.refreshNext()
+	return nil
+}
+
+// Repeat sets what is played once a track has ended.
+func (playlist *Playlist) Repeat(mode RepeatMode) error {
+	if mode < RepeatOff || mode > RepeatAll {
+		return errors.New("ffgoconv: playlist: unknown repeat mode")
+	}
+
+	playlist.Lock()
+	defer playlist.Unlock()
+
+	if playlist.closed {
+		return errors.New("ffgoconv: playlist: closed")
+	}
+
+	playlist.repeat = mode
+	playlist.refreshNext()
+	return nil
+}
+
+// Index returns the position of the current track in the order the tracks are played in, or -1 if the playlist hasn't
+// played any yet.
+func (playlist *Playlist) Index() int {
+	playlist.Lock()
+	defer playlist.Unlock()
+
+	return playlist.position
+}
+
+// Current returns the path of the track being played, or an empty string if none is.
+func (playlist *Playlist) Current() string {
+	playlist.Lock()
+	defer playlist.Unlock()
+
+	return playlist.currentPath
+}
+
+// Remaining returns a snapshot of the tracks left to play after the current one, in the order they are played in.
+func (playlist *Playlist) Remaining() []string {
+	playlist.Lock()
+	defer playlist.Unlock()
+
+	var remaining []string
+	for _, item := range playlist.order[playlist.position+1:] {
+		remaining = append(remaining, playlist.items[item])
+	}
+	return remaining
+}
+
+// Len returns the number of tracks in the playlist.
+func (playlist *Playlist) Len() int {
+	playlist.Lock()
+	defer playlist.Unlock()
+
+	return len(playlist.items)
+}
+
+// Close stops playing the playlist, closing the current track and the upcoming one.
+func (playlist *Playlist) Close() {
+	playlist.Lock()
+	if playlist.closed {
+		playlist.Unlock()
+		return
+	}
+	playlist.closed = true
+	playlist.playing = false
+
+	playlist.discardNext()
+	current := playlist.current
+	playlist.current = nil
+	playlist.currentPath = ""
+	playlist.Unlock()
+
+	// The current track is closed without holding the lock, as closing it waits for ffmpeg to exit.
+	if current != nil {
+		current.Close()
+	}
+}
+
+// jump stops the current track and plays the track at position in its place. The caller must hold the lock.
+func (playlist *Playlist) jump(position int) {
+	playlist.discardNext()
+
+	current := playlist.current
+	playlist.current = nil
+	playlist.currentPath = ""
+	if current != nil {
+		current.closeAsync()
+	}
+
+	playlist.playing = true
+	playlist.play(position)
+}
+
+// play starts the track at position, skipping ahead past any track that fails to start. The caller must hold the lock.
+func (playlist *Playlist) play(position int) {
+	for attempts := 0; attempts < len(playlist.order); attempts++ {
+		playlist.position = position
+		path := playlist.items[playlist.order[position]]
+
+		streamer, err := playlist.addTrack(path)
+		if err == nil {
+			playlist.setCurrent(streamer, path)
+			playlist.refreshNext()
+			return
+		}
+
+		playlist.trackEnded(path, err)
+		if playlist.transmuxer.isClosed() {
+			break
+		}
+		if position = playlist.following(position); position < 0 {
+			break
+		}
+	}
+
+	playlist.playing = false
+}
+
+// addTrack starts the track at path and adds it to the transmuxing session.
+func (playlist *Playlist) addTrack(path string) (*Streamer, error) {
+	transmuxer := playlist.transmuxer
+	if transmuxer.closed {
+		return nil, errors.New("ffgoconv: transmuxer: closed")
+	}
+	if !transmuxer.reserveSlot() {
+		return nil, ErrTooManyStreamers
+	}
+
+	streamer, err := playlist.openTrack(path)
+	if err != nil {
+		transmuxer.releaseSlot()
+		return nil, err
+	}
+
+	transmuxer.addStreamer(streamer)
+	return streamer, nil
+}
+
+// setCurrent makes streamer the current track. The caller must hold the lock.
+func (playlist *Playlist) setCurrent(streamer *Streamer, path string) {
+	playlist.current = streamer
+	playlist.currentPath = path
+	if err := streamer.SetCallback(func(err error) {
+		playlist.ended(streamer, path, err)
+	}); err != nil {
+		// The track already ended before it became the current one.
+		go playlist.ended(streamer, path, streamer.Err())
+	}
+
+	if onTrackStart := playlist.onTrackStart; onTrackStart != nil {
+		playlist.notify(func() { onTrackStart(path) })
+	}
+}
+
+// notify queues a callback to be called in a separate goroutine, after every callback queued before it has returned.
+// The caller must hold the lock.
+func (playlist *Playlist) notify(callback func()) {
+	playlist.callbacks = append(playlist.callbacks, callback)
+	if playlist.notifying {
+		return
+	}
+	playlist.notifying = true
+
+	go func() {
+		for {
+			playlist.Lock()
+			if len(playlist.callbacks) == 0 {
+				playlist.notifying = false
+				playlist.Unlock()
+				return
+			}
+			callback := playlist.callbacks[0]
+			playlist.callbacks = playlist.callbacks[1:]
+			playlist.Unlock()
+
+			callback()
+		}
+	}()
+}
+
+// trackEnded reports that the track at path has stopped playing, with a nil error if it played until the end. The caller
+// must hold the lock.
+func (playlist *Playlist) trackEnded(path string, err error) {
+	if errors.Is(err, ErrStreamEnded) || errors.Is(err, ErrStreamerClosed) {
+		err = nil
+	}
+	if onTrackEnd := playlist.onTrackEnd; onTrackEnd != nil {
+		playlist.notify(func() { onTrackEnd(path, err) })
+	}
+}
+
+// ended advances the playlist once streamer, playing the track at path, has stopped playing.
+func (playlist *Playlist) ended(streamer *Streamer, path string, err error) {
+	playlist.Lock()
+	defer playlist.Unlock()
+
+	playlist.trackEnded(path, err)
+	if streamer != playlist.current || playlist.closed || playlist.transmuxer.isClosed() {
+		return
+	}
+	playlist.current = nil
+	playlist.currentPath = ""
+
+	position := playlist.following(playlist.position)
+	if position < 0 {
+		playlist.playing = false
+		return
+	}
+
+	next := playlist.next
+	playlist.next = nil
+	if next == nil || next.position != position {
+		if next != nil {
+			next.streamer.closeAsync()
+		}
+		playlist.generation++
+		playlist.play(position)
+		return
+	}
+
+	// Unless the mix has already handed off to the upcoming track, it still has to be added to the session.
+	if successor := streamer.successor.Swap(nil); successor != nil {
+		if err := playlist.transmuxer.addPrepared(successor); err != nil {
+			successor.closeAsync()
+			playlist.trackEnded(next.path, err)
+			playlist.play(position)
+			return
+		}
+	}
+
+	playlist.position = position
+	playlist.setCurrent(next.streamer, next.path)
+	playlist.refreshNext()
+}
+
+// following returns the position of the track to play once the track at position has ended on its own, or -1 if the
+// playlist is over. The caller must hold the lock.
+func (playlist *Playlist) following(position int) int {
+	if len(playlist.order) == 0 {
+		return -1
+	}
+	if playlist.repeat == RepeatOne && position >= 0 {
+		return position
+	}
+
+	position++
+	if position >= len(playlist.order) {
+		if playlist.repeat != RepeatAll {
+			return -1
+		}
+		playlist.reshuffle()
+		position = 0
+	}
+	return position
+}
+
+// reshuffle shuffles the order of the tracks again before starting over, if shuffle is enabled. The caller must hold the
+// lock.
+func (playlist *Playlist) reshuffle() {
+	if playlist.shuffle {
+		playlist.order = playlist.nextOrder()
+		playlist.reshuffled = nil
+	}
+}
+
+// nextOrder returns the order the tracks are played in once the playlist starts over with shuffle enabled, picking it
+// ahead of time so that the first track can be prepared. The caller must hold the lock.
+func (playlist *Playlist) nextOrder() []int {
+	if len(playlist.reshuffled) != len(playlist.items) {
+		playlist.reshuffled = rand.Perm(len(playlist.items))
+	}
+	return playlist.reshuffled
+}
+
+// refreshNext prepares the track following the current one, unless it already has been. The caller must hold the lock.
+func (playlist *Playlist) refreshNext() {
+	if !playlist.playing || playlist.current == nil {
+		return
+	}
+
+	// Peek at the following track without reshuffling, as the order is only reshuffled once it is actually reached.
+	order := playlist.order
+	position := playlist.position + 1
+	if playlist.repeat == RepeatOne {
+		position = playlist.position
+	} else if position >= len(order) {
+		if playlist.repeat != RepeatAll {
+			playlist.discardNext()
+			return
+		}
+		if playlist.shuffle {
+			order = playlist.nextOrder()
+		}
+		position = 0
+	}
+	path := playlist.items[order[position]]
+
+	if next := playlist.next; next != nil && next.position == position && next.path == path {
+		return
+	}
+	playlist.discardNext()
+
+	playlist.generation++
+	go playlist.prepare(playlist.generation, playlist.current, position, path)
+}
+
+// discardNext closes the upcoming track and stops any in-flight preparation of one. The caller must hold the lock.
+func (playlist *Playlist) discardNext() {
+	playlist.generation++
+
+	next := playlist.next
+	playlist.next = nil
+	if next == nil {
+		return
+	}
+
+	if playlist.current != nil {
+		playlist.current.successor.CompareAndSwap(next.streamer, nil)
+	}
+	next.streamer.closeAsync()
+}
+
+// prepare starts and buffers the track at position to take over from current once it ends.
+func (playlist *Playlist) prepare(generation uint64, current *Streamer, position int, path string) {
+	streamer, err := playlist.openTrack(path)
+	if err == nil {
+		if err = streamer.prebuffer(); err != nil {
+			streamer.Close()
+		}
+	}
+
+	playlist.Lock()
+	defer playlist.Unlock()
+
+	if err != nil {
+		return
+	}
+	if generation != playlist.generation || playlist.closed || playlist.current != current {
+		streamer.closeAsync()
+		return
+	}
+
+	if err := streamer.SetCallback(func(error) {
+		playlist.preparedEnded(streamer)
+	}); err != nil {
+		return
+	}
+
+	playlist.next = &preparedTrack{
+		position: position,
+		path:     path,
+		streamer: streamer,
+	}
+	current.successor.Store(streamer)
+}
+
+// preparedEnded forgets about the upcoming track if it failed while it was being buffered, so that it is started over
+// once it is needed. A track the mix has already handed off to has been played instead, and is kept for ended to pick up.
+func (playlist *Playlist) preparedEnded(streamer *Streamer) {
+	playlist.Lock()
+	defer playlist.Unlock()
+
+	if next := playlist.next; next != nil && next.streamer == streamer {
+		if playlist.current != nil && !playlist.current.successor.CompareAndSwap(streamer, nil) {
+			return
+		}
+		playlist.next = nil
+	}
+}
+
+// trackOptions returns the options used to play the track at path.
+func (playlist *Playlist) trackOptions(path string) *StreamerOptions {
+	return &StreamerOptions{
+		Input:  path,
+		Volume: 1.0,
+	}
+}
+
+// isClosed returns whether or not the transmuxing session has been closed.
+func (transmuxer *Transmuxer) isClosed() bool {
+	transmuxer.Lock()
+	defer transmuxer.Unlock()
+
+	return transmuxer.closed
+}
+
+// addPrepared adds a streamer that was started ahead of time to the transmuxing session.
+func (transmuxer *Transmuxer) addPrepared(streamer *Streamer) error {
+	if transmuxer.closed {
+		return errors.New("ffgoconv: transmuxer: closed")
+	}
+	if !transmuxer.reserveSlot() {
+		return ErrTooManyStreamers
+	}
+
+	transmuxer.addStreamer(streamer)
+	return nil
+}
@@ -0,0 +1,491 @@
+package ffgoconv
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// trackSource is a SampleSource standing in for a track, producing a value unique to the track until it has produced
+// length samples, or until it is closed if length is negative.
+type trackSource struct {
+	value     float64
+	remaining int64
+}
+
+// ReadSamples implements SampleSource.
+func (source *trackSource) ReadSamples(dst []float64) (int, error) {
+	if source.remaining == 0 {
+		return 0, io.EOF
+	}
+
+	n := len(dst)
+	if source.remaining > 0 && int64(n) > source.remaining {
+		n = int(source.remaining)
+		source.remaining = 0
+	} else if source.remaining > 0 {
+		source.remaining -= int64(n)
+	}
+	for i := range dst[:n] {
+		dst[i] = source.value
+	}
+	return n, nil
+}
+
+// Close implements SampleSource.
+func (source *trackSource) Close() error {
+	return nil
+}
+
+// testTrack is a track of a playlist under test.
+type testTrack struct {
+	value  float64
+	length int64 // Length in samples, or negative to play until closed
+}
+
+// testPlaylist is a *Playlist playing its tracks from trackSources rather than through ffmpeg, recording the streamer of
+// every track it starts and the callbacks it calls.
+type testPlaylist struct {
+	*Playlist
+
+	mu     sync.Mutex
+	opened map[string][]*Streamer
+	events []string
+}
+
+// newTestPlaylist returns a *testPlaylist through a new transmuxing session. Tracks missing from tracks fail to start,
+// except for those added by the test itself, which play until closed.
+func newTestPlaylist(t *testing.T, tracks map[string]testTrack, paths ...string) (*testPlaylist, *Transmuxer) {
+	t.Helper()
+
+	transmuxer, err := NewTransmuxerWithOptions(nil, &TransmuxerOptions{MasterVolume: 1.0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(transmuxer.Close)
+
+	playlist, err := NewPlaylist(transmuxer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	test := &testPlaylist{Playlist: playlist, opened: make(map[string][]*Streamer)}
+
+	playlist.openTrack = func(path string) (*Streamer, error) {
+		track, ok := tracks[path]
+		if !ok && path[0] != 't' {
+			return nil, fmt.Errorf("ffgoconv: test: no track %s", path)
+		}
+		if !ok {
+			track = testTrack{value: 1, length: -1}
+		}
+
+		streamer, err := NewSourceStreamer(&trackSource{value: track.value, remaining: track.length}, 1.0)
+		if err != nil {
+			return nil, err
+		}
+		test.mu.Lock()
+		test.opened[path] = append(test.opened[path], streamer)
+		test.mu.Unlock()
+		return streamer, nil
+	}
+	playlist.SetOnTrackStart(func(path string) {
+		test.mu.Lock()
+		defer test.mu.Unlock()
+		test.events = append(test.events, "start "+path)
+	})
+	playlist.SetOnTrackEnd(func(path string, err error) {
+		test.mu.Lock()
+		defer test.mu.Unlock()
+		if err != nil {
+			test.events = append(test.events, "fail "+path)
+		} else {
+			test.events = append(test.events, "end "+path)
+		}
+	})
+
+	for _, path := range paths {
+		if err := playlist.Add(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return test, transmuxer
+}
+
+// timesOpened returns the number of times the track at path has been started.
+func (test *testPlaylist) timesOpened(path string) int {
+	test.mu.Lock()
+	defer test.mu.Unlock()
+	return len(test.opened[path])
+}
+
+// state returns the current and upcoming tracks of the playlist.
+func (test *testPlaylist) state() (current *Streamer, next *preparedTrack) {
+	test.Lock()
+	defer test.Unlock()
+	return test.current, test.next
+}
+
+// waitForNext waits for the track at position to be prepared as the upcoming one.
+func (test *testPlaylist) waitForNext(t *testing.T, position int) *preparedTrack {
+	t.Helper()
+
+	var next *preparedTrack
+	waitFor(t, fmt.Sprintf("track %d to be prepared", position), func() bool {
+		_, next = test.state()
+		return next != nil && next.position == position
+	})
+	return next
+}
+
+// endCurrent ends the current track as if it had played until the end, and waits for the playlist to move on from it.
+func (test *testPlaylist) endCurrent(t *testing.T) {
+	t.Helper()
+
+	current, _ := test.state()
+	if current == nil {
+		t.Fatal("no track is playing")
+	}
+	current.Close()
+	waitFor(t, "the playlist to move on", func() bool {
+		test.Lock()
+		defer test.Unlock()
+		return test.current != current && (test.current != nil || !test.playing)
+	})
+}
+
+// waitFor polls condition until it is true, failing the test if it takes longer than 5 seconds.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPlaylistOrderAndCallbacks(t *testing.T) {
+	tracks := map[string]testTrack{
+		"a": {value: 0.125, length: 4800},
+		"b": {value: 0.25, length: 4800},
+	}
+	playlist, transmuxer := newTestPlaylist(t, tracks, "a", "missing", "b")
+
+	if err := playlist.Play(); err != nil {
+		t.Fatal(err)
+	}
+	go transmuxer.Run()
+	go io.Copy(ioutil.Discard, transmuxer)
+
+	want := []string{"start a", "end a", "fail missing", "start b", "end b"}
+	waitFor(t, "every track to be played", func() bool {
+		playlist.mu.Lock()
+		defer playlist.mu.Unlock()
+		return len(playlist.events) >= len(want)
+	})
+
+	playlist.mu.Lock()
+	defer playlist.mu.Unlock()
+	if fmt.Sprint(playlist.events) != fmt.Sprint(want) {
+		t.Errorf("got events %v, want %v", playlist.events, want)
+	}
+	if playlist.Current() != "" || playlist.Index() != 2 {
+		t.Errorf("the playlist ended on %q at %d, want nothing playing at 2", playlist.Current(), playlist.Index())
+	}
+}
+
+func TestPlaylistHandsOffWithoutAGap(t *testing.T) {
+	tracks := map[string]testTrack{
+		"a": {value: 0.125, length: 9600},
+		"b": {value: 0.25, length: 9600},
+	}
+	playlist, transmuxer := newTestPlaylist(t, tracks, "a", "b")
+
+	if err := playlist.Play(); err != nil {
+		t.Fatal(err)
+	}
+	next := playlist.waitForNext(t, 1)
+	if current, _ := playlist.state(); current.successor.Load() != next.streamer {
+		t.Fatal("the upcoming track isn't the successor of the current one")
+	}
+
+	if err := transmuxer.SetDurationLimit(400 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	go transmuxer.Run()
+	data, err := ioutil.ReadAll(transmuxer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+8 <= len(data); i += 8 {
+		sample := math.Float64frombits(binary.LittleEndian.Uint64(data[i:]))
+		want := 0.0
+		if i < 9600*8 {
+			want = 0.125
+		} else if i < 19200*8 {
+			want = 0.25
+		}
+		if sample != want {
+			t.Fatalf("sample %d is %v, want %v", i/8, sample, want)
+		}
+	}
+
+	waitFor(t, "the upcoming track to become the current one", func() bool {
+		current, _ := playlist.state()
+		return current == next.streamer || playlist.Current() == ""
+	})
+	if n := playlist.timesOpened("b"); n != 1 {
+		t.Errorf("b was started %d times, want it to only have been prepared", n)
+	}
+}
+
+func TestPlaylistAddsPreparedTrack(t *testing.T) {
+	tracks := map[string]testTrack{
+		"a": {value: 0.125, length: -1},
+		"b": {value: 0.25, length: -1},
+	}
+	playlist, transmuxer := newTestPlaylist(t, tracks, "a", "b")
+
+	if err := playlist.Play(); err != nil {
+		t.Fatal(err)
+	}
+	next := playlist.waitForNext(t, 1)
+
+	// The session isn't running, so the mix never hands off to the upcoming track and it has to be added instead.
+	playlist.endCurrent(t)
+	if current, _ := playlist.state(); current != next.streamer {
+		t.Fatal("the prepared track didn't become the current one")
+	}
+	if n := playlist.timesOpened("b"); n != 1 {
+		t.Errorf("b was started %d times, want it to only have been prepared", n)
+	}
+
+	found := false
+	for _, streamer := range transmuxer.GetStreamers() {
+		found = found || streamer == next.streamer
+	}
+	if !found {
+		t.Error("the prepared track wasn't added to the session")
+	}
+}
+
+func TestPlaylistPrepareGenerations(t *testing.T) {
+	playlist, _ := newTestPlaylist(t, nil, "ta", "tb", "tc")
+
+	if err := playlist.Play(); err != nil {
+		t.Fatal(err)
+	}
+	first := playlist.waitForNext(t, 1)
+
+	// Repeating the current track makes it the upcoming one in place of the next track.
+	if err := playlist.Repeat(RepeatOne); err != nil {
+		t.Fatal(err)
+	}
+	repeated := playlist.waitForNext(t, 0)
+	if repeated.path != "ta" {
+		t.Errorf("prepared %s, want ta", repeated.path)
+	}
+	if !first.streamer.closed.Load() {
+		t.Error("the discarded upcoming track wasn't closed")
+	}
+	if current, _ := playlist.state(); current.successor.Load() != repeated.streamer {
+		t.Error("the repeated track isn't the successor of the current one")
+	}
+
+	// Leaving the options as they are doesn't prepare the upcoming track again.
+	if err := playlist.Repeat(RepeatOne); err != nil {
+		t.Fatal(err)
+	}
+	if _, next := playlist.state(); next != repeated {
+		t.Error("the upcoming track was prepared again")
+	}
+
+	// A preparation that has been superseded by another while it was in flight is thrown away.
+	playlist.Lock()
+	stale := playlist.generation
+	playlist.generation++
+	current := playlist.current
+	playlist.Unlock()
+
+	playlist.prepare(stale, current, 2, "tc")
+	if _, next := playlist.state(); next != repeated {
+		t.Error("a stale preparation replaced the upcoming track")
+	}
+	playlist.mu.Lock()
+	opened := playlist.opened["tc"]
+	playlist.mu.Unlock()
+	if len(opened) != 1 || !opened[0].closed.Load() {
+		t.Error("the stale preparation wasn't closed")
+	}
+}
+
+func TestPlaylistShuffleAdd(t *testing.T) {
+	playlist, _ := newTestPlaylist(t, nil, "t0", "t1", "t2", "t3", "t4")
+
+	if err := playlist.Shuffle(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := playlist.Play(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := playlist.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	playlist.Lock()
+	played := append([]int(nil), playlist.order[:3]...)
+	playlist.Unlock()
+	current := playlist.Current()
+
+	for i := 5; i < 25; i++ {
+		if err := playlist.Add(fmt.Sprintf("t%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	playlist.Lock()
+	defer playlist.Unlock()
+
+	// Added tracks are only ever inserted among the tracks that haven't been played yet.
+	if fmt.Sprint(playlist.order[:3]) != fmt.Sprint(played) {
+		t.Errorf("the played tracks changed from %v to %v", played, playlist.order[:3])
+	}
+	if playlist.position != 2 || playlist.currentPath != current {
+		t.Errorf("the playlist moved to %s at %d, want %s at 2", playlist.currentPath, playlist.position, current)
+	}
+
+	order := append([]int(nil), playlist.order...)
+	sort.Ints(order)
+	for i, item := range order {
+		if item != i {
+			t.Fatalf("the order %v isn't a permutation of every track", playlist.order)
+		}
+	}
+}
+
+func TestPlaylistRepeatAllReshuffles(t *testing.T) {
+	paths := []string{"t0", "t1", "t2", "t3", "t4"}
+	playlist, _ := newTestPlaylist(t, nil, paths...)
+
+	if err := playlist.Shuffle(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := playlist.Repeat(RepeatAll); err != nil {
+		t.Fatal(err)
+	}
+	if err := playlist.Play(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Going back from the first track at the last one, the first track of the next round is prepared ahead of time.
+	if err := playlist.Previous(); err != nil {
+		t.Fatal(err)
+	}
+	if index := playlist.Index(); index != 4 {
+		t.Fatalf("went back to %d, want 4", index)
+	}
+	next := playlist.waitForNext(t, 0)
+
+	playlist.Lock()
+	reshuffled := append([]int(nil), playlist.reshuffled...)
+	playlist.Unlock()
+	if len(reshuffled) != len(paths) || next.path != paths[reshuffled[0]] {
+		t.Fatalf("prepared %s, want the first track of the reshuffled order %v", next.path, reshuffled)
+	}
+
+	playlist.endCurrent(t)
+	if current, _ := playlist.state(); current != next.streamer {
+		t.Error("the prepared track didn't become the current one")
+	}
+
+	playlist.Lock()
+	defer playlist.Unlock()
+	if fmt.Sprint(playlist.order) != fmt.Sprint(reshuffled) || playlist.position != 0 {
+		t.Errorf("started over at %d of %v, want 0 of %v", playlist.position, playlist.order, reshuffled)
+	}
+	if playlist.reshuffled != nil {
+		t.Error("the next reshuffled order wasn't picked anew")
+	}
+}
+
+func TestPlaylistRepeatAndSkip(t *testing.T) {
+	playlist, _ := newTestPlaylist(t, nil, "t0", "t1", "t2")
+
+	check := func(step string, path string, index int) {
+		t.Helper()
+		if current, got := playlist.Current(), playlist.Index(); current != path || got != index {
+			t.Fatalf("%s: playing %q at %d, want %q at %d", step, current, got, path, index)
+		}
+	}
+
+	if err := playlist.Play(); err != nil {
+		t.Fatal(err)
+	}
+	check("play", "t0", 0)
+
+	if err := playlist.Repeat(RepeatOne); err != nil {
+		t.Fatal(err)
+	}
+	repeated := playlist.waitForNext(t, 0)
+	playlist.endCurrent(t)
+	check("end with repeat one", "t0", 0)
+	if current, _ := playlist.state(); current != repeated.streamer {
+		t.Error("the repeated track wasn't played from where it was prepared")
+	}
+
+	if err := playlist.Next(); err != nil {
+		t.Fatal(err)
+	}
+	check("next with repeat one", "t1", 1)
+
+	if err := playlist.Repeat(RepeatAll); err != nil {
+		t.Fatal(err)
+	}
+	playlist.endCurrent(t)
+	check("end with repeat all", "t2", 2)
+	playlist.endCurrent(t)
+	check("end of the last track with repeat all", "t0", 0)
+	if err := playlist.Previous(); err != nil {
+		t.Fatal(err)
+	}
+	check("previous from the first track with repeat all", "t2", 2)
+	if err := playlist.Next(); err != nil {
+		t.Fatal(err)
+	}
+	check("next from the last track with repeat all", "t0", 0)
+
+	if err := playlist.Repeat(RepeatOff); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := playlist.state()
+	if err := playlist.Previous(); err != nil {
+		t.Fatal(err)
+	}
+	check("previous from the first track", "t0", 0)
+	if current, _ := playlist.state(); current == first || !first.closed.Load() {
+		t.Error("going back from the first track didn't restart it")
+	}
+
+	if err := playlist.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if err := playlist.Next(); err != nil {
+		t.Fatal(err)
+	}
+	check("next", "t2", 2)
+	if err := playlist.Next(); err == nil {
+		t.Error("skipped past the last track")
+	}
+	playlist.endCurrent(t)
+	check("end of the last track", "", 2)
+}
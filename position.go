@@ -0,0 +1,87 @@
+package ffgoconv
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrUnknownDuration is returned when the duration of a streamer's input can't be determined, such as for live or
+// otherwise unbounded sources.
+var ErrUnknownDuration = errors.New("ffgoconv: streamer: duration of input is unknown")
+
+// outputFormat returns the sample rate, channel count and precision of the raw PCM that ffmpeg outputs with the given
+// args, defaulting to 48kHz stereo float64 when any of them isn't specified.
+func outputFormat(args []string) (sampleRate, channels int, precision Precision) {
+	sampleRate, channels, precision = 48000, 2, PrecisionFloat64
+
+	for i := 0; i < len(args)-1; i++ {
+		switch args[i] {
+		case "-ar":
+			if value, err := strconv.Atoi(args[i+1]); err == nil && value > 0 {
+				sampleRate = value
+			}
+		case "-ac":
+			if value, err := strconv.Atoi(args[i+1]); err == nil && value > 0 {
+				channels = value
+			}
+		case "-f":
+			switch args[i+1] {
+			case "f32le":
+				precision = PrecisionFloat32
+			case "f64le":
+				precision = PrecisionFloat64
+			}
+		}
+	}
+
+	return sampleRate, channels, precision
+}
+
+// Position returns how far into its input the streamer has been played by the transmuxing session, counting from the
+// offset it was seeked to.
+//
+// The position is in the time of the input, so that it accounts for the tempo the input is played at.
+func (streamer *Streamer) Position() time.Duration {
+	streamer.Lock()
+	offset, tempo := streamer.offset, streamer.tempo
+	streamer.Unlock()
+
+	frames := streamer.consumed.Load() / int64(streamer.channels)
+	played := time.Duration(frames * int64(time.Second) / int64(streamer.sampleRate))
+	return offset + time.Duration(float64(played)*tempo)
+}
+
+// Duration returns the duration of the streamer's input as reported by ffprobe, or ErrUnknownDuration if it can't be
+// determined. The input is only probed once, with the result being cached for subsequent calls. Probing doesn't hold up
+// the transmuxing session mixing the streamer, however long it takes.
+func (streamer *Streamer) Duration() (time.Duration, error) {
+	streamer.durationMu.Lock()
+	defer streamer.durationMu.Unlock()
+
+	if !streamer.probed {
+		streamer.duration, streamer.durationErr = probeDuration(streamer.input)
+		streamer.probed = true
+	}
+
+	return streamer.duration, streamer.durationErr
+}
+
+// probeDuration uses ffprobe to determine the duration of input.
+func probeDuration(input string) (time.Duration, error) {
+	if input == "" || strings.HasPrefix(input, "pipe:") || input == "-" {
+		return 0, ErrUnknownDuration
+	}
+
+	info, err := ProbeContext(context.Background(), input)
+	if err != nil {
+		return 0, err
+	}
+	if info.Format.Duration <= 0 {
+		return 0, ErrUnknownDuration
+	}
+
+	return info.Format.Duration, nil
+}
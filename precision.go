@@ -0,0 +1,36 @@
+package ffgoconv
+
+// Precision is the sample format of the raw PCM piped between ffmpeg and a transmuxing session.
+type Precision int
+
+const (
+	// PrecisionFloat64 pipes 8-byte pcm_f64le samples. This is the default.
+	PrecisionFloat64 Precision = iota
+	// PrecisionFloat32 pipes 4-byte pcm_f32le samples, halving the bandwidth and memory used by the pipes at no audible
+	// cost.
+	PrecisionFloat32
+)
+
+// codec returns the ffmpeg codec of the precision.
+func (precision Precision) codec() string {
+	if precision == PrecisionFloat32 {
+		return "pcm_f32le"
+	}
+	return "pcm_f64le"
+}
+
+// format returns the ffmpeg format of the precision.
+func (precision Precision) format() string {
+	if precision == PrecisionFloat32 {
+		return "f32le"
+	}
+	return "f64le"
+}
+
+// size returns the size of a single sample of the precision, in bytes.
+func (precision Precision) size() int {
+	if precision == PrecisionFloat32 {
+		return 4 // sizeof(float32) == 4
+	}
+	return 8 // sizeof(float64) == 8
+}
@@ -1 +1,77 @@
 package ffgoconv
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// The presets below return options for common output profiles, writing to "pipe:1" at full master volume. The output,
+// volume and any other options may be changed before the options are passed to NewTransmuxerWithOptions.
+
+// PresetMP3 returns options encoding constant bitrate MP3 at bitrateKbps, which must be between 32 and 320.
+func PresetMP3(bitrateKbps int) (*TransmuxerOptions, error) {
+	if err := checkPresetBitrate("mp3", bitrateKbps, 32, 320); err != nil {
+		return nil, err
+	}
+
+	return newPreset("libmp3lame", "mp3", bitrateKbps), nil
+}
+
+// PresetOpusOgg returns options encoding Opus in an Ogg container at bitrateKbps, which must be between 6 and 510.
+func PresetOpusOgg(bitrateKbps int) (*TransmuxerOptions, error) {
+	if err := checkPresetBitrate("opus", bitrateKbps, 6, 510); err != nil {
+		return nil, err
+	}
+
+	options := newPreset("libopus", "ogg", bitrateKbps)
+	options.ExtraOutputArgs = []string{"-vbr", "on", "-application", "audio"}
+	return options, nil
+}
+
+// PresetAAC returns options encoding AAC in an ADTS stream at bitrateKbps, which must be between 32 and 512.
+func PresetAAC(bitrateKbps int) (*TransmuxerOptions, error) {
+	if err := checkPresetBitrate("aac", bitrateKbps, 32, 512); err != nil {
+		return nil, err
+	}
+
+	return newPreset("aac", "adts", bitrateKbps), nil
+}
+
+// PresetWAV16 returns options writing 16-bit PCM in a WAV container.
+func PresetWAV16() *TransmuxerOptions {
+	options := newPreset("pcm_s16le", "wav", 0)
+	options.Bitrate = ""
+	return options
+}
+
+// PresetDiscordOpus returns options encoding Opus in an Ogg container the way Discord voice connections expect it: at
+// 64 kbps with 20ms frames, and one frame per Ogg page so that packets can be read without delay using an OpusReader.
+func PresetDiscordOpus() *TransmuxerOptions {
+	options := newPreset("libopus", "ogg", 64)
+	options.ExtraOutputArgs = []string{
+		"-vbr", "on",
+		"-application", "audio",
+		"-frame_duration", "20",
+		"-page_duration", "20000",
+	}
+	return options
+}
+
+// newPreset returns options encoding codec in format at bitrateKbps.
+func newPreset(codec, format string, bitrateKbps int) *TransmuxerOptions {
+	return &TransmuxerOptions{
+		OutputFilepath: "pipe:1",
+		Codec:          codec,
+		Format:         format,
+		Bitrate:        strconv.Itoa(bitrateKbps) + "k",
+		MasterVolume:   1.0,
+	}
+}
+
+// checkPresetBitrate returns an error if bitrateKbps is outside of the range supported by the encoder of a preset.
+func checkPresetBitrate(name string, bitrateKbps, low, high int) error {
+	if bitrateKbps < low || bitrateKbps > high {
+		return fmt.Errorf("ffgoconv: preset: %s bitrate must be between %d and %d kbps, got %d", name, low, high, bitrateKbps)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package ffgoconv
+
+import (
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// presets are the presets under test along with the file extension of their output.
+var presets = []struct {
+	name      string
+	extension string
+	preset    func() (*TransmuxerOptions, error)
+}{
+	{"mp3", "mp3", func() (*TransmuxerOptions, error) { return PresetMP3(192) }},
+	{"opus", "ogg", func() (*TransmuxerOptions, error) { return PresetOpusOgg(96) }},
+	{"aac", "aac", func() (*TransmuxerOptions, error) { return PresetAAC(128) }},
+	{"wav", "wav", func() (*TransmuxerOptions, error) { return PresetWAV16(), nil }},
+	{"discord", "ogg", func() (*TransmuxerOptions, error) { return PresetDiscordOpus(), nil }},
+}
+
+func TestPresetsValidate(t *testing.T) {
+	for _, preset := range presets {
+		options, err := preset.preset()
+		if err != nil {
+			t.Fatalf("%s: %v", preset.name, err)
+		}
+		if err := options.Validate(); err != nil {
+			t.Errorf("%s: %v", preset.name, err)
+		}
+		if options.OutputFilepath != "pipe:1" || options.MasterVolume != 1.0 {
+			t.Errorf("%s: output is %q at volume %v, want pipe:1 at 1.0", preset.name, options.OutputFilepath, options.MasterVolume)
+		}
+	}
+}
+
+func TestPresetBitrateRanges(t *testing.T) {
+	tests := []struct {
+		name      string
+		preset    func(bitrateKbps int) (*TransmuxerOptions, error)
+		low, high int
+	}{
+		{"mp3", PresetMP3, 32, 320},
+		{"opus", PresetOpusOgg, 6, 510},
+		{"aac", PresetAAC, 32, 512},
+	}
+
+	for _, test := range tests {
+		for _, bitrate := range []int{test.low, test.high} {
+			if _, err := test.preset(bitrate); err != nil {
+				t.Errorf("%s at %d kbps: %v", test.name, bitrate, err)
+			}
+		}
+		for _, bitrate := range []int{0, -1, test.low - 1, test.high + 1} {
+			if _, err := test.preset(bitrate); err == nil {
+				t.Errorf("%s at %d kbps was accepted", test.name, bitrate)
+			}
+		}
+	}
+}
+
+// TestPresetsEncode encodes a second of a tone with every preset, then decodes the file to check that it is playable.
+// It is skipped when ffmpeg isn't on the PATH.
+func TestPresetsEncode(t *testing.T) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg isn't on the PATH")
+	}
+
+	for _, preset := range presets {
+		t.Run(preset.name, func(t *testing.T) {
+			options, err := preset.preset()
+			if err != nil {
+				t.Fatal(err)
+			}
+			options.OutputFilepath = filepath.Join(t.TempDir(), "preset."+preset.extension)
+
+			tone, err := NewToneStreamer(440, 0.5)
+			if err != nil {
+				t.Fatal(err)
+			}
+			transmuxer, err := NewTransmuxerWithOptions([]*Streamer{tone}, options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer transmuxer.Close()
+			if err := transmuxer.SetDurationLimit(time.Second); err != nil {
+				t.Fatal(err)
+			}
+
+			go transmuxer.Run()
+			select {
+			case <-transmuxer.Done():
+			case <-time.After(30 * time.Second):
+				t.Fatal("timed out encoding")
+			}
+			if err := transmuxer.Err(); err != nil {
+				t.Fatalf("encoding failed: %v; ffmpeg output: %s", err, transmuxer.EncodeOutput())
+			}
+
+			streamer, err := NewStreamer(options.OutputFilepath, nil, 1.0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer streamer.Close()
+
+			decoded := 0
+			samples := make([]float64, 4096)
+			for {
+				n, err := streamer.ReadSamples(samples)
+				decoded += n
+				if errors.Is(err, ErrStreamEnded) {
+					break
+				}
+				if err != nil {
+					t.Fatalf("decoding failed: %v", err)
+				}
+			}
+
+			// Encoders pad the start and end of the audio to whole packets, which decoders don't always trim.
+			if duration := samplesDuration(int64(decoded)); duration < 900*time.Millisecond || duration > 1100*time.Millisecond {
+				t.Errorf("decoded %v of audio, want about 1s", duration)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package ffgoconv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNoAudioStream is returned when an input doesn't contain any audio to decode.
+var ErrNoAudioStream = errors.New("ffgoconv: probe: input has no audio stream")
+
+// ProbeInfo contains the container and stream metadata of an input, as reported by ffprobe.
+type ProbeInfo struct {
+	Format  ProbeFormat
+	Streams []ProbeStream
+}
+
+// ProbeFormat contains the container metadata of an input.
+type ProbeFormat struct {
+	Filename   string
+	FormatName string            // Short name of the container format, such as "mp3" or "mov,mp4,m4a,3gp,3g2,mj2"
+	Duration   time.Duration     // Duration of the input, or 0 if it is unknown
+	BitRate    int64             // Overall bitrate of the input in bits per second, or 0 if it is unknown
+	Tags       map[string]string // Metadata tags of the container, such as "title" or "artist"
+}
+
+// ProbeStream contains the metadata of a single stream of an input.
+type ProbeStream struct {
+	Index         int
+	CodecType     string // Type of the stream, such as "audio" or "video"
+	CodecName     string
+	SampleRate    int // Sample rate of an audio stream in Hz
+	Channels      int // Channel count of an audio stream
+	ChannelLayout string
+	BitRate       int64         // Bitrate of the stream in bits per second, or 0 if it is unknown
+	Duration      time.Duration // Duration of the stream, or 0 if it is unknown
+	Tags          map[string]string
+}
+
+// probeOutput mirrors the JSON written by ffprobe, which reports most numbers as strings.
+type probeOutput struct {
+	Format struct {
+		Filename   string            `json:"filename"`
+		FormatName string            `json:"format_name"`
+		Duration   string            `json:"duration"`
+		BitRate    string            `json:"bit_rate"`
+		Tags       map[string]string `json:"tags"`
+	} `json:"format"`
+	Streams []struct {
+		Index         int               `json:"index"`
+		CodecType     string            `json:"codec_type"`
+		CodecName     string            `json:"codec_name"`
+		SampleRate    string            `json:"sample_rate"`
+		Channels      int               `json:"channels"`
+		ChannelLayout string            `json:"channel_layout"`
+		BitRate       string            `json:"bit_rate"`
+		Duration      string            `json:"duration"`
+		Tags          map[string]string `json:"tags"`
+	} `json:"streams"`
+}
+
+// Probe returns the container and stream metadata of input, which may either be a file or a URL.
+func Probe(input string) (*ProbeInfo, error) {
+	return ProbeContext(context.Background(), input)
+}
+
+// ProbeContext is like Probe, but kills ffprobe if ctx is done before it finishes, which guards against it hanging on
+// unresponsive URLs.
+func ProbeContext(ctx context.Context, input string) (*ProbeInfo, error) {
+	ffprobePath, err := lookFFprobe()
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	ffprobe := exec.CommandContext(ctx, ffprobePath,
+		"-v", "error",
+		"-print_format", "json",
+		"-show_format",
+		"-show_streams",
+		input,
+	)
+	ffprobe.Stdout = &stdout
+	ffprobe.Stderr = &stderr
+
+	if err := ffprobe.Run(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			err = ctxErr
+		}
+		return nil, fmt.Errorf("ffgoconv: probe: error probing %s: %w; %s", input, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var output probeOutput
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+		return nil, fmt.Errorf("ffgoconv: probe: error parsing ffprobe output: %w", err)
+	}
+
+	info := &ProbeInfo{
+		Format: ProbeFormat{
+			Filename:   output.Format.Filename,
+			FormatName: output.Format.FormatName,
+			Duration:   parseProbeSeconds(output.Format.Duration),
+			BitRate:    parseProbeInt(output.Format.BitRate),
+			Tags:       output.Format.Tags,
+		},
+		Streams: make([]ProbeStream, len(output.Streams)),
+	}
+	for i, stream := range output.Streams {
+		info.Streams[i] = ProbeStream{
+			Index:         stream.Index,
+			CodecType:     stream.CodecType,
+			CodecName:     stream.CodecName,
+			SampleRate:    int(parseProbeInt(stream.SampleRate)),
+			Channels:      stream.Channels,
+			ChannelLayout: stream.ChannelLayout,
+			BitRate:       parseProbeInt(stream.BitRate),
+			Duration:      parseProbeSeconds(stream.Duration),
+			Tags:          stream.Tags,
+		}
+	}
+
+	return info, nil
+}
+
+// HasAudio returns whether or not the probed input contains at least one audio stream.
+func (info *ProbeInfo) HasAudio() bool {
+	for _, stream := range info.Streams {
+		if stream.CodecType == "audio" {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAudio probes input and returns whether or not it contains at least one audio stream.
+func HasAudio(input string) (bool, error) {
+	info, err := Probe(input)
+	if err != nil {
+		return false, err
+	}
+	return info.HasAudio(), nil
+}
+
+// Duration probes input and returns its duration, or ErrUnknownDuration if it can't be determined.
+func Duration(input string) (time.Duration, error) {
+	info, err := Probe(input)
+	if err != nil {
+		return 0, err
+	}
+	if info.Format.Duration <= 0 {
+		return 0, ErrUnknownDuration
+	}
+	return info.Format.Duration, nil
+}
+
+// SetValidateInputs sets whether or not the input of every streamer added to the transmuxing session is probed first,
+// so that inputs without any audio are rejected with ErrNoAudioStream instead of silently contributing nothing to the
+// mix. Inputs read from an io.Reader, as well as those with StreamerOptions.SkipValidation set, are never probed.
+// Validation is disabled by default.
+func (transmuxer *Transmuxer) SetValidateInputs(validate bool) error {
+	if transmuxer.closed {
+		return errors.New("ffgoconv: transmuxer: closed")
+	}
+
+	transmuxer.validate.Store(validate)
+	return nil
+}
+
+// validateInput probes the input of options if input validation is enabled, returning ErrNoAudioStream if it has no
+// audio stream.
+func (transmuxer *Transmuxer) validateInput(options *StreamerOptions) error {
+	if !transmuxer.validate.Load() || options == nil || options.Reader != nil || options.SkipValidation {
+		return nil
+	}
+
+	info, err := Probe(options.Input)
+	if err != nil {
+		return err
+	}
+	if !info.HasAudio() {
+		return fmt.Errorf("%w: %s", ErrNoAudioStream, options.Input)
+	}
+	return nil
+}
+
+// parseProbeSeconds parses a duration in seconds as reported by ffprobe, returning 0 if it is unknown.
+func parseProbeSeconds(value string) time.Duration {
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// parseProbeInt parses an integer as reported by ffprobe, returning 0 if it is unknown.
+func parseProbeInt(value string) int64 {
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return i
+}
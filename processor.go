@@ -0,0 +1,86 @@
+package ffgoconv
+
+import (
+	"errors"
+)
+
+// SampleProcessor processes blocks of audio inside the mix loop of a transmuxing session, either for the finalized audio
+// or for an individual streamer.
+//
+// Blocks consist of interleaved 48kHz stereo float64 samples. Process may modify the block in place and return it, or
+// return a new slice of the same length. Processors run on the hot path of the mix loop, so they must never block.
+type SampleProcessor interface {
+	Process(samples []float64) []float64
+}
+
+// SampleProcessorFunc is an adapter to allow the use of ordinary functions as a SampleProcessor.
+type SampleProcessorFunc func(samples []float64) []float64
+
+// Process calls processor(samples).
+func (processor SampleProcessorFunc) Process(samples []float64) []float64 {
+	return processor(samples)
+}
+
+// process runs samples through every processor in order.
+func process(processors []SampleProcessor, samples []float64) []float64 {
+	for _, processor := range processors {
+		samples = processor.Process(samples)
+	}
+	return samples
+}
+
+// AddProcessor appends a SampleProcessor to the chain run on the finalized audio, after the streamers have been mixed
+// and the master volume has been applied but before it is written to the final stream.
+func (transmuxer *Transmuxer) AddProcessor(processor SampleProcessor) error {
+	if transmuxer.closed {
+		return errors.New("ffgoconv: transmuxer: closed")
+	}
+
+	if processor == nil {
+		return errors.New("ffgoconv: processor: processor must not be nil")
+	}
+
+	transmuxer.Lock()
+	defer transmuxer.Unlock()
+
+	processors := make([]SampleProcessor, 0, len(transmuxer.processors)+1)
+	processors = append(processors, transmuxer.processors...)
+	transmuxer.processors = append(processors, processor)
+	return nil
+}
+
+// ClearProcessors removes every SampleProcessor from the chain run on the finalized audio.
+func (transmuxer *Transmuxer) ClearProcessors() {
+	transmuxer.Lock()
+	defer transmuxer.Unlock()
+
+	transmuxer.processors = nil
+}
+
+// AddProcessor appends a SampleProcessor to the chain run on the streamer's audio, after its volume has been applied but
+// before it is mixed with the other streamers.
+func (streamer *Streamer) AddProcessor(processor SampleProcessor) error {
+	if streamer.closed.Load() {
+		return ErrStreamerClosed
+	}
+
+	if processor == nil {
+		return errors.New("ffgoconv: processor: processor must not be nil")
+	}
+
+	streamer.Lock()
+	defer streamer.Unlock()
+
+	processors := make([]SampleProcessor, 0, len(streamer.processors)+1)
+	processors = append(processors, streamer.processors...)
+	streamer.processors = append(processors, processor)
+	return nil
+}
+
+// ClearProcessors removes every SampleProcessor from the chain run on the streamer's audio.
+func (streamer *Streamer) ClearProcessors() {
+	streamer.Lock()
+	defer streamer.Unlock()
+
+	streamer.processors = nil
+}
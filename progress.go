@@ -0,0 +1,98 @@
+package ffgoconv
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// handleProgressLine parses a key=value line of the machine-readable progress ffmpeg writes with -progress, returning
+// whether or not line was one. Every block of progress ends with a progress key, at which point the block is published,
+// and the last block ends with progress=end. It must be called with stderrLog locked.
+func (stderrLog *stderrLog) handleProgressLine(line string) (published, ok bool) {
+	key, value, ok := strings.Cut(line, "=")
+	// Some values are padded, like "bitrate= 128.0kbits/s", while a -stats line holds several pairs separated by
+	// spaces.
+	value = strings.TrimSpace(value)
+	if !ok || key == "" || strings.ContainsAny(key, " \t") || strings.ContainsAny(value, " \t=") {
+		return false, false
+	}
+
+	switch key {
+	case "total_size":
+		if size, err := strconv.ParseInt(value, 10, 64); err == nil {
+			stderrLog.progress.Size = int(size / 1024)
+		}
+	case "out_time_us", "out_time_ms": // out_time_ms is misnamed by ffmpeg and holds microseconds as well
+		if us, err := strconv.ParseInt(value, 10, 64); err == nil && us >= 0 {
+			stderrLog.progress.Duration = time.Duration(us) * time.Microsecond
+		}
+	case "bitrate":
+		stderrLog.progress.Bitrate = parseStatsFloat(value, "kbits/s")
+	case "speed":
+		stderrLog.progress.Speed = parseStatsFloat(value, "x")
+	case "progress":
+		stderrLog.stats = stderrLog.progress
+		if value == "end" {
+			stderrLog.ended = true
+		}
+		return true, true
+	}
+	return false, true
+}
+
+// Progress returns a channel receiving the latest progress reported by the streamer's ffmpeg every time it reports
+// progress, which is closed once ffmpeg has reported the end of its output or the streaming session has ended. Updates that aren't received before the next one
+// are dropped, so the channel always holds the most recent progress.
+func (streamer *Streamer) Progress() <-chan TranscodeStats {
+	return streamer.progress
+}
+
+// notifyProgress delivers stats to the progress callback and channel of the streamer.
+func (streamer *Streamer) notifyProgress(stats TranscodeStats) {
+	if onProgress := streamer.options.OnProgress; onProgress != nil {
+		onProgress(stats)
+	}
+
+	streamer.progressMu.Lock()
+	defer streamer.progressMu.Unlock()
+
+	if streamer.progressClosed {
+		return
+	}
+	for {
+		select {
+		case streamer.progress <- stats:
+			return
+		default:
+		}
+		// Drop the stale update nobody received yet in favor of the new one.
+		select {
+		case <-streamer.progress:
+		default:
+		}
+	}
+}
+
+// endProgress closes the progress channel of the streamer once ffmpeg has reported the end of its output, unless ffmpeg
+// is to be restarted to loop the input again.
+func (streamer *Streamer) endProgress() {
+	streamer.Lock()
+	looping := streamer.canLoop()
+	streamer.Unlock()
+
+	if !looping {
+		streamer.closeProgress()
+	}
+}
+
+// closeProgress closes the progress channel of the streamer once the streaming session has ended.
+func (streamer *Streamer) closeProgress() {
+	streamer.progressMu.Lock()
+	defer streamer.progressMu.Unlock()
+
+	if !streamer.progressClosed {
+		streamer.progressClosed = true
+		close(streamer.progress)
+	}
+}
@@ -0,0 +1,74 @@
+package ffgoconv
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseProgressLines(t *testing.T) {
+	stderrLog := &stderrLog{}
+	lines := []string{
+		"bitrate= 128.0kbits/s",
+		"total_size=1048576",
+		"out_time_us=62450000",
+		"out_time_ms=62450000",
+		"out_time=00:01:02.450000",
+		"dup_frames=0",
+		"drop_frames=0",
+		"speed= 31.2x",
+		"progress=continue",
+	}
+
+	for i, line := range lines {
+		published, ok := stderrLog.handleProgressLine(line)
+		if !ok {
+			t.Fatalf("%q wasn't parsed as progress", line)
+		}
+		if published != (i == len(lines)-1) {
+			t.Fatalf("%q published progress: %v", line, published)
+		}
+	}
+
+	want := TranscodeStats{Size: 1024, Duration: 62450 * time.Millisecond, Bitrate: 128, Speed: 31.2}
+	if stderrLog.stats != want {
+		t.Errorf("published %+v, want %+v", stderrLog.stats, want)
+	}
+
+	if _, ok := stderrLog.handleProgressLine("size=    1024kB time=00:01:02.45 bitrate= 134.3kbits/s speed=31.2x"); ok {
+		t.Error("a -stats line was parsed as progress")
+	}
+}
+
+func TestProgressEnd(t *testing.T) {
+	output := strings.Join([]string{
+		"out_time_us=1000000", "speed=2.0x", "progress=continue",
+		"out_time_us=2000000", "speed=2.0x", "progress=end",
+	}, "\n") + "\n"
+
+	streamer := newPCMStreamer(strings.NewReader(""))
+	defer streamer.Close()
+	// stderr is left open, like that of an ffmpeg process that has yet to exit after reporting the end of its output.
+	reader, writer := io.Pipe()
+	defer writer.Close()
+	streamer.stderrLog = newStderrLog(reader, streamer.notifyProgress, streamer.endProgress)
+	go writer.Write([]byte(output))
+
+	var last TranscodeStats
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case stats, ok := <-streamer.Progress():
+			if !ok {
+				if last.Duration != 2*time.Second {
+					t.Errorf("last progress was at %v, want 2s", last.Duration)
+				}
+				return
+			}
+			last = stats
+		case <-timeout:
+			t.Fatal("the progress channel wasn't closed at progress=end")
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package ffgoconv
+
+import (
+	"errors"
+)
+
+// ErrTooManyStreamers is returned when a streamer is added to a transmuxing session that is already mixing its maximum
+// number of streamers.
+var ErrTooManyStreamers = errors.New("ffgoconv: transmuxer: too many streamers")
+
+// QueuedStreamer is a request to add a streamer to a transmuxing session, which is started once the session has a free
+// slot for it.
+type QueuedStreamer struct {
+	ID      uint64
+	Options StreamerOptions
+
+	ready    chan struct{}
+	streamer *Streamer
+	err      error
+}
+
+// Ready returns a channel that is closed once the queued streamer has been started, has failed to start, or has been
+// cancelled.
+func (queued *QueuedStreamer) Ready() <-chan struct{} {
+	return queued.ready
+}
+
+// Wait blocks until the queued streamer has been started and added to the transmuxing session, returning it or the
+// error that prevented it from being added.
+func (queued *QueuedStreamer) Wait() (*Streamer, error) {
+	<-queued.ready
+	return queued.streamer, queued.err
+}
+
+// SetMaxStreamers sets the maximum number of streamers that may be mixed by the transmuxing session at once. Once it is
+// reached, AddStreamer and its variants return ErrTooManyStreamers, while QueueStreamer waits for a free slot. A
+// maximum of 0 means unlimited, which is the default.
+func (transmuxer *Transmuxer) SetMaxStreamers(max int) error {
+	if transmuxer.closed {
+		return errors.New("ffgoconv: transmuxer: closed")
+	}
+
+	if max < 0 {
+		return errors.New("ffgoconv: transmuxer: maximum streamers must not be negative")
+	}
+
+	transmuxer.Lock()
+	transmuxer.maxStreamers = max
+	transmuxer.Unlock()
+
+	go transmuxer.startQueued()
+	return nil
+}
+
+// QueueStreamer initializes and adds a *Streamer using the given options to the transmuxing session as soon as it has a
+// free slot for it, starting it right away if it already does. See AddStreamerWithOptions for info on supported options.
+//
+// The returned request can be inspected with Queue and cancelled with CancelQueued until it has been started.
+func (transmuxer *Transmuxer) QueueStreamer(options *StreamerOptions) (*QueuedStreamer, error) {
+	if transmuxer.closed {
+		return nil, errors.New("ffgoconv: transmuxer: closed")
+	}
+
+	if options == nil {
+		return nil, errors.New("ffgoconv: streamer: options must not be nil")
+	}
+
+	transmuxer.Lock()
+	transmuxer.queueID++
+	queued := &QueuedStreamer{
+		ID:      transmuxer.queueID,
+		Options: *options,
+		ready:   make(chan struct{}),
+	}
+	transmuxer.queue = append(transmuxer.queue, queued)
+	transmuxer.Unlock()
+
+	transmuxer.startQueued()
+	return queued, nil
+}
+
+// Queue returns a snapshot of the streamers waiting for a free slot in the transmuxing session, in the order they will
+// be started.
+func (transmuxer *Transmuxer) Queue() []*QueuedStreamer {
+	transmuxer.Lock()
+	defer transmuxer.Unlock()
+
+	queue := make([]*QueuedStreamer, len(transmuxer.queue))
+	copy(queue, transmuxer.queue)
+	return queue
+}
+
+// CancelQueued removes the queued streamer with the given ID from the queue, or returns an error if it isn't queued.
+func (transmuxer *Transmuxer) CancelQueued(id uint64) error {
+	transmuxer.Lock()
+	defer transmuxer.Unlock()
+
+	for i, queued := range transmuxer.queue {
+		if queued.ID != id {
+			continue
+		}
+
+		transmuxer.queue = append(transmuxer.queue[:i:i], transmuxer.queue[i+1:]...)
+		queued.err = errors.New("ffgoconv: transmuxer: queued streamer cancelled")
+		close(queued.ready)
+		return nil
+	}
+
+	return errors.New("ffgoconv: transmuxer: queued streamer not found")
+}
+
+// reserveSlot reserves a slot for a streamer that is about to be started, returning false if the transmuxing session
+// has no free slot. The caller must follow up with addStreamer or releaseSlot.
+func (transmuxer *Transmuxer) reserveSlot() bool {
+	transmuxer.Lock()
+	defer transmuxer.Unlock()
+
+	return transmuxer.reserveSlotLocked()
+}
+
+// reserveSlotLocked is reserveSlot for callers already holding the lock.
+func (transmuxer *Transmuxer) reserveSlotLocked() bool {
+	if transmuxer.maxStreamers > 0 {
+		active := transmuxer.starting
+		for _, streamer := range transmuxer.streamers {
+			if !streamer.closed.Load() {
+				active++
+			}
+		}
+
+		if active >= transmuxer.maxStreamers {
+			return false
+		}
+	}
+
+	transmuxer.starting++
+	return true
+}
+
+// releaseSlot releases a slot reserved for a streamer that failed to start.
+func (transmuxer *Transmuxer) releaseSlot() {
+	transmuxer.Lock()
+	transmuxer.starting--
+	transmuxer.Unlock()
+}
+
+// setReleased sets the function called once the streamer has been closed, freeing its slot in the transmuxing session.
+func (streamer *Streamer) setReleased(released func()) {
+	streamer.Lock()
+	streamer.released = released
+	streamer.Unlock()
+}
+
+// startQueued starts as many queued streamers as the transmuxing session has free slots for.
+func (transmuxer *Transmuxer) startQueued() {
+	for {
+		transmuxer.Lock()
+		if transmuxer.closed || len(transmuxer.queue) == 0 || !transmuxer.reserveSlotLocked() {
+			transmuxer.Unlock()
+			return
+		}
+
+		queued := transmuxer.queue[0]
+		transmuxer.queue = transmuxer.queue[1:]
+		transmuxer.Unlock()
+
+		queued.streamer, queued.err = transmuxer.newStreamer(&queued.Options)
+		if queued.err != nil {
+			transmuxer.releaseSlot()
+		} else {
+			transmuxer.addStreamer(queued.streamer)
+		}
+		close(queued.ready)
+	}
+}
@@ -0,0 +1,60 @@
+package ffgoconv
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQueuePromotesOnClose(t *testing.T) {
+	ffmpegPath := writeFakeFFmpeg(t, fakeDecoder)
+
+	transmuxer, err := NewTransmuxerWithOptions(nil, &TransmuxerOptions{MasterVolume: 1.0, FFmpegPath: ffmpegPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transmuxer.Close()
+
+	if err := transmuxer.SetMaxStreamers(1); err != nil {
+		t.Fatal(err)
+	}
+	tone, err := NewToneStreamer(440, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := transmuxer.AddSource(tone, 1.0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := transmuxer.AddSource(tone, 1.0); err != ErrTooManyStreamers {
+		t.Fatalf("got error %v adding a second streamer, want ErrTooManyStreamers", err)
+	}
+
+	queued, err := transmuxer.QueueStreamer(&StreamerOptions{Input: "song.mp3", Volume: 1.0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-queued.Ready():
+		t.Fatal("the queued streamer was started without a free slot")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The session isn't running, so only closing the streamer itself can promote the queued one.
+	first.Close()
+	select {
+	case <-queued.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the queued streamer wasn't started once a slot was freed")
+	}
+
+	streamer, err := queued.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !transmuxer.hasStreamer(streamer) {
+		t.Error("the queued streamer wasn't added to the session")
+	}
+	if len(transmuxer.Queue()) != 0 {
+		t.Error("the queued streamer is still queued")
+	}
+}
@@ -0,0 +1,190 @@
+package ffgoconv
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// recorder encodes the finalized audio into a rotating series of independently playable files.
+type recorder struct {
+	sync.Mutex
+
+	ffmpegPath string
+
+	dir     string
+	format  string
+	segment int64
+
+	sink  *fileSink
+	index int // Number of segments started so far, which tells apart the files started within the same second
+
+	finishing sync.WaitGroup // Previous segments still being finalized in the background
+	err       error
+}
+
+// newRecorder returns an initialized *recorder with its first segment already started.
+func newRecorder(ffmpegPath, dir string, segment time.Duration, format string) (*recorder, error) {
+	recorder := &recorder{
+		ffmpegPath: ffmpegPath,
+		dir:        dir,
+		format:     format,
+		segment:    durationSamples(segment),
+	}
+
+	if err := recorder.start(); err != nil {
+		return nil, err
+	}
+	return recorder, nil
+}
+
+// start spawns an ffmpeg process encoding to a new segment file named after the current time and its index, which never
+// overwrites an existing file.
+func (recorder *recorder) start() error {
+	name := fmt.Sprintf("%s_%04d.%s", time.Now().Format("2006-01-02T15-04-05"), recorder.index, recorder.format)
+	path := filepath.Join(recorder.dir, name)
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("ffgoconv: recorder: %s already exists", path)
+	}
+
+	sink, err := startFileSink(recorder.ffmpegPath, path, []string{"-f", recorder.format}, false)
+	if err != nil {
+		return fmt.Errorf("ffgoconv: recorder: error starting ffmpeg: %v", err)
+	}
+
+	recorder.sink = sink
+	recorder.index++
+	return nil
+}
+
+// rotate starts a new segment and finalizes the current one in the background, so that the mix loop doesn't wait for
+// ffmpeg to finish writing the file. The caller must hold the lock.
+func (recorder *recorder) rotate() {
+	sink := recorder.sink
+	recorder.sink = nil
+	if err := recorder.start(); err != nil {
+		recorder.err = err
+	}
+
+	recorder.finishing.Add(1)
+	go func() {
+		defer recorder.finishing.Done()
+
+		err := sink.finish()
+
+		recorder.Lock()
+		if recorder.err == nil {
+			recorder.err = err
+		}
+		recorder.Unlock()
+	}()
+}
+
+// write records samples, starting a new segment on the first frame boundary past the segment duration.
+func (recorder *recorder) write(samples []float64) {
+	recorder.Lock()
+	defer recorder.Unlock()
+
+	// Samples are written in runs up to the frame boundary the segment ends at.
+	end := recorder.segment + recorder.segment%2
+	for len(samples) > 0 {
+		if recorder.err != nil || recorder.sink == nil {
+			return
+		}
+
+		if recorder.sink.written >= end {
+			recorder.rotate()
+			continue
+		}
+
+		n := end - recorder.sink.written
+		if n > int64(len(samples)) {
+			n = int64(len(samples))
+		}
+		if err := recorder.sink.write(samples[:n]); err != nil {
+			recorder.err = err
+			return
+		}
+		samples = samples[n:]
+	}
+}
+
+// close finalizes the current segment, waits for every previous segment to be finalized and returns the first error
+// encountered while recording.
+func (recorder *recorder) close() error {
+	recorder.Lock()
+	sink := recorder.sink
+	recorder.sink = nil
+	recorder.Unlock()
+
+	var err error
+	if sink != nil {
+		err = sink.finish()
+	}
+	recorder.finishing.Wait()
+
+	recorder.Lock()
+	defer recorder.Unlock()
+
+	if recorder.err == nil {
+		recorder.err = err
+	}
+	return recorder.err
+}
+
+// StartRecording starts recording the finalized audio into dir as a series of files in the given ffmpeg format, each
+// named after the time it was started at and its index within the recording (such as "2024-06-01T12-00-00_0000.mp3"),
+// and spanning up to segment of audio. Existing files are never overwritten, failing the recording instead.
+//
+// Every segment is encoded by its own ffmpeg process, so that each file is independently playable.
+func (transmuxer *Transmuxer) StartRecording(dir string, segment time.Duration, format string) error {
+	if transmuxer.closed {
+		return errors.New("ffgoconv: transmuxer: closed")
+	}
+
+	if segment <= 0 {
+		return errors.New("ffgoconv: recorder: segment must be greater than 0")
+	}
+	if format == "" {
+		return errors.New("ffgoconv: recorder: format must not be empty string")
+	}
+	if info, err := os.Stat(dir); err != nil {
+		return err
+	} else if !info.IsDir() {
+		return fmt.Errorf("ffgoconv: recorder: %s is not a directory", dir)
+	}
+
+	transmuxer.Lock()
+	defer transmuxer.Unlock()
+
+	if transmuxer.recorder.Load() != nil {
+		return errors.New("ffgoconv: recorder: already recording")
+	}
+
+	ffmpegPath, err := lookFFmpeg(transmuxer.ffmpegPath)
+	if err != nil {
+		return err
+	}
+
+	recorder, err := newRecorder(ffmpegPath, dir, segment, format)
+	if err != nil {
+		return err
+	}
+
+	transmuxer.recorder.Store(recorder)
+	return nil
+}
+
+// StopRecording stops recording the finalized audio, finalizing the current file. It returns the first error
+// encountered while recording, if any.
+func (transmuxer *Transmuxer) StopRecording() error {
+	recorder := transmuxer.recorder.Swap(nil)
+	if recorder == nil {
+		return errors.New("ffgoconv: recorder: not recording")
+	}
+
+	return recorder.close()
+}
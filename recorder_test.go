@@ -0,0 +1,85 @@
+package ffgoconv
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordingRotatesWithinASecond(t *testing.T) {
+	ffmpegPath := writeFakeFFmpeg(t, fakeEncoder)
+	dir := t.TempDir()
+
+	streamer, err := NewToneStreamer(440, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transmuxer, err := NewTransmuxerWithOptions([]*Streamer{streamer}, &TransmuxerOptions{MasterVolume: 1.0, FFmpegPath: ffmpegPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transmuxer.Close()
+
+	if err := transmuxer.StartRecording(dir, 10*time.Millisecond, "pcm"); err != nil {
+		t.Fatal(err)
+	}
+	if err := transmuxer.SetDurationLimit(100 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	go transmuxer.Run()
+	if _, err := io.Copy(ioutil.Discard, transmuxer); err != nil {
+		t.Fatal(err)
+	}
+	<-transmuxer.done
+	if err := transmuxer.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every segment is started within the same second, so only their index tells their files apart.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 10 {
+		t.Fatalf("recorded %d files, want 10", len(entries))
+	}
+
+	segmentSize := durationSamples(10*time.Millisecond) * 8
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != segmentSize {
+			t.Errorf("%s holds %d bytes, want %d", entry.Name(), info.Size(), segmentSize)
+		}
+	}
+	if name := entries[9].Name(); !strings.HasSuffix(name, "_0009.pcm") {
+		t.Errorf("the last file is named %s, want it to end in _0009.pcm", name)
+	}
+}
+
+func TestRecordingDoesNotOverwrite(t *testing.T) {
+	ffmpegPath := writeFakeFFmpeg(t, fakeEncoder)
+	dir := t.TempDir()
+
+	// The file is occupied for the next second too, in case the recorder starts just past a second boundary.
+	now := time.Now()
+	path := filepath.Join(dir, now.Format("2006-01-02T15-04-05")+"_0000.pcm")
+	for _, at := range []time.Time{now, now.Add(time.Second)} {
+		if err := ioutil.WriteFile(filepath.Join(dir, at.Format("2006-01-02T15-04-05")+"_0000.pcm"), []byte("kept"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := newRecorder(ffmpegPath, dir, time.Second, "pcm"); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("got error %v, want the file to already exist", err)
+	}
+	if data, err := ioutil.ReadFile(path); err != nil || string(data) != "kept" {
+		t.Errorf("the existing file holds %q (%v), want it untouched", data, err)
+	}
+}
@@ -0,0 +1,209 @@
+package ffgoconv
+
+import (
+	"errors"
+	"math"
+)
+
+const (
+	// resampleZeroCrossings is the number of zero crossings of the sinc kernel on either side of each output sample.
+	resampleZeroCrossings = 16
+	// resampleRolloff is the fraction of the lower Nyquist frequency that is passed through, leaving the rest for the
+	// transition band of the filter.
+	resampleRolloff = 0.95
+	// resampleMaxPhases is the largest number of distinct kernel phases that are precomputed.
+	resampleMaxPhases = 1024
+)
+
+// Resampler converts interleaved audio from one sample rate to another in pure Go, without ffmpeg.
+//
+// It uses a windowed sinc filter with 16 zero crossings on either side and a Blackman window, passing through 95% of
+// the lower of the two Nyquist frequencies with more than 70dB of stopband attenuation. This is comparable to the
+// default quality of ffmpeg's swresample. Audio that is already at the output rate is passed through untouched.
+//
+// The resampler is stateful, so a stream may be converted in chunks of any size with the same result as converting it
+// at once, with Flush returning the tail once the input has ended.
+type Resampler struct {
+	inRate   int64
+	outRate  int64
+	channels int
+
+	halfWidth int       // Number of input frames on either side of each output frame
+	scale     float64   // Kernel scale, below 1 when downsampling so that the cutoff follows the output rate
+	phases    int64     // Number of distinct kernel phases, or 0 if they are computed for every output frame
+	table     []float64 // Normalized kernels of each phase, halfWidth*2 taps each
+
+	buffer  []float64 // Interleaved input that is still needed, preceded by halfWidth frames of silence at first
+	dropped int64     // Number of frames dropped from the start of buffer, including the leading silence
+	input   int64     // Number of input frames received
+	output  int64     // Number of output frames produced
+	flushed bool
+}
+
+// NewResampler returns an initialized *Resampler converting interleaved audio with the given number of channels from
+// inRate to outRate, or an error if one could not be created.
+func NewResampler(inRate, outRate, channels int) (*Resampler, error) {
+	if inRate <= 0 || outRate <= 0 {
+		return nil, errors.New("ffgoconv: resampler: sample rates must be greater than 0")
+	}
+	if channels <= 0 {
+		return nil, errors.New("ffgoconv: resampler: channels must be greater than 0")
+	}
+
+	resampler := &Resampler{
+		inRate:   int64(inRate),
+		outRate:  int64(outRate),
+		channels: channels,
+		scale:    1.0,
+	}
+	if inRate == outRate {
+		return resampler, nil
+	}
+	if outRate < inRate {
+		resampler.scale = float64(outRate) / float64(inRate)
+	}
+	resampler.halfWidth = int(math.Ceil(resampleZeroCrossings / resampler.scale))
+
+	// Output frames only ever fall on outRate/gcd distinct positions between two input frames.
+	if phases := resampler.outRate / gcd(resampler.inRate, resampler.outRate); phases <= resampleMaxPhases {
+		resampler.phases = phases
+		resampler.table = make([]float64, 0, int(phases)*resampler.halfWidth*2)
+		for phase := int64(0); phase < phases; phase++ {
+			resampler.table = append(resampler.table, resampler.kernel(float64(phase)/float64(phases))...)
+		}
+	}
+
+	// The input is preceded by silence, so that the first output frame lines up with the first input frame.
+	resampler.buffer = make([]float64, resampler.halfWidth*channels)
+	return resampler, nil
+}
+
+// Process resamples the interleaved samples in, returning as many output samples as can be computed so far. Samples
+// that can't be resampled yet are kept for the next call, as are incomplete frames.
+func (resampler *Resampler) Process(in []float64) []float64 {
+	if resampler.flushed {
+		return nil
+	}
+
+	resampler.buffer = append(resampler.buffer, in...)
+
+	// Audio that is already at the output rate is passed through untouched.
+	if resampler.inRate == resampler.outRate {
+		size := len(resampler.buffer) / resampler.channels * resampler.channels
+		out := append([]float64(nil), resampler.buffer[:size]...)
+		resampler.buffer = append(resampler.buffer[:0], resampler.buffer[size:]...)
+		return out
+	}
+
+	resampler.input = resampler.dropped + int64(len(resampler.buffer)/resampler.channels-resampler.halfWidth)
+	return resampler.resample(-1)
+}
+
+// Flush resamples whatever input is left, returning the final output samples. The resampler can't be used anymore once
+// it has been flushed.
+func (resampler *Resampler) Flush() []float64 {
+	if resampler.flushed {
+		return nil
+	}
+	resampler.flushed = true
+	if resampler.inRate == resampler.outRate {
+		return nil
+	}
+
+	// Drop an incomplete frame, then pad the input with silence so that the last frames can be resampled.
+	frames := len(resampler.buffer) / resampler.channels
+	resampler.buffer = resampler.buffer[:frames*resampler.channels]
+	resampler.buffer = append(resampler.buffer, make([]float64, (resampler.halfWidth+1)*resampler.channels)...)
+
+	total := (resampler.input*resampler.outRate + resampler.inRate - 1) / resampler.inRate
+	return resampler.resample(total)
+}
+
+// resample computes output frames until either not enough input is buffered or limit frames have been produced, if
+// limit isn't negative.
+func (resampler *Resampler) resample(limit int64) []float64 {
+	channels := resampler.channels
+	frames := int64(len(resampler.buffer) / channels)
+	taps := resampler.halfWidth * 2
+
+	var out []float64
+	for limit < 0 || resampler.output < limit {
+		// Position of the output frame in input frames, split into the input frame before it and the phase past it.
+		position := resampler.output * resampler.inRate
+		index := position/resampler.outRate - resampler.dropped
+		remainder := position % resampler.outRate
+
+		// The kernel reaches from halfWidth-1 frames before index to halfWidth frames after it, offset by the leading
+		// silence of halfWidth frames.
+		first := index + 1
+		if first+int64(taps) > frames {
+			break
+		}
+
+		var kernel []float64
+		if resampler.phases > 0 {
+			phase := remainder * resampler.phases / resampler.outRate
+			kernel = resampler.table[int(phase)*taps : int(phase+1)*taps]
+		} else {
+			kernel = resampler.kernel(float64(remainder) / float64(resampler.outRate))
+		}
+
+		for channel := 0; channel < channels; channel++ {
+			var sample float64
+			for tap, weight := range kernel {
+				sample += resampler.buffer[(int(first)+tap)*channels+channel] * weight
+			}
+			out = append(out, sample)
+		}
+		resampler.output++
+	}
+
+	// Drop the input that no future output frame reaches back to anymore.
+	next := (resampler.output*resampler.inRate)/resampler.outRate - resampler.dropped
+	if drop := int(next) + 1; drop > 0 && !resampler.flushed {
+		copy(resampler.buffer, resampler.buffer[drop*channels:])
+		resampler.buffer = resampler.buffer[:len(resampler.buffer)-drop*channels]
+		resampler.dropped += int64(drop)
+	}
+
+	return out
+}
+
+// kernel returns the normalized filter taps for an output frame falling frac of the way between two input frames.
+func (resampler *Resampler) kernel(frac float64) []float64 {
+	taps := make([]float64, resampler.halfWidth*2)
+	halfWidth := float64(resampler.halfWidth)
+
+	var sum float64
+	for tap := range taps {
+		// Distance from the output frame to the input frame of the tap, the first of which is halfWidth-1 frames
+		// before the input frame preceding the output frame.
+		x := frac + halfWidth - 1 - float64(tap)
+		if math.Abs(x) >= halfWidth {
+			continue
+		}
+
+		sinc := 1.0
+		if t := math.Pi * x * resampler.scale * resampleRolloff; t != 0 {
+			sinc = math.Sin(t) / t
+		}
+		window := 0.42 + 0.5*math.Cos(math.Pi*x/halfWidth) + 0.08*math.Cos(2*math.Pi*x/halfWidth)
+
+		taps[tap] = sinc * window
+		sum += taps[tap]
+	}
+
+	// Normalize the taps so that the filter has unity gain at DC.
+	for tap := range taps {
+		taps[tap] /= sum
+	}
+	return taps
+}
+
+// gcd returns the greatest common divisor of a and b.
+func gcd(a, b int64) int64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
@@ -0,0 +1,142 @@
+package ffgoconv
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// resampleAll resamples in at once with a new *Resampler, returning every output sample including the flushed tail.
+func resampleAll(t *testing.T, inRate, outRate, channels int, in []float64) []float64 {
+	t.Helper()
+
+	resampler, err := NewResampler(inRate, outRate, channels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(resampler.Process(in), resampler.Flush()...)
+}
+
+// noise returns n samples of white noise from a fixed seed.
+func noise(n int) []float64 {
+	random := rand.New(rand.NewSource(1))
+	samples := make([]float64, n)
+	for i := range samples {
+		samples[i] = 2*random.Float64() - 1
+	}
+	return samples
+}
+
+func TestResamplerLength(t *testing.T) {
+	tests := []struct {
+		inRate, outRate int
+		channels        int
+		frames          int
+	}{
+		{44100, 48000, 2, 44100},
+		{48000, 44100, 2, 1000},
+		{8000, 48000, 1, 333},
+		{48000, 8000, 2, 1001},
+		{22050, 44100, 2, 1},
+		{44100, 48001, 2, 4410}, // Too many phases to precompute
+		{48000, 44100, 2, 0},
+	}
+
+	for _, test := range tests {
+		out := resampleAll(t, test.inRate, test.outRate, test.channels, noise(test.frames*test.channels))
+
+		want := (test.frames*test.outRate + test.inRate - 1) / test.inRate * test.channels
+		if len(out) != want {
+			t.Errorf("%d frames from %dHz to %dHz resampled to %d samples, want %d", test.frames, test.inRate, test.outRate,
+				len(out), want)
+		}
+	}
+}
+
+func TestResamplerChunks(t *testing.T) {
+	for _, rates := range [][2]int{{44100, 48000}, {48000, 44100}, {44100, 48001}} {
+		in := noise(20000)
+		want := resampleAll(t, rates[0], rates[1], 2, in)
+
+		// Chunks of every size, including ones splitting frames, give the same output as a single call.
+		resampler, err := NewResampler(rates[0], rates[1], 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []float64
+		for size, rest := 1, in; len(rest) > 0; size = size*3 + 1 {
+			if size > len(rest) {
+				size = len(rest)
+			}
+			got = append(got, resampler.Process(rest[:size])...)
+			rest = rest[size:]
+		}
+		got = append(got, resampler.Flush()...)
+
+		if len(got) != len(want) {
+			t.Fatalf("%dHz to %dHz in chunks resampled to %d samples, want %d", rates[0], rates[1], len(got), len(want))
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("%dHz to %dHz in chunks: sample %d is %v, want %v", rates[0], rates[1], i, got[i], want[i])
+			}
+		}
+	}
+}
+
+func TestResamplerPassthrough(t *testing.T) {
+	in := noise(9601)
+	resampler, err := NewResampler(48000, 48000, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out []float64
+	for rest, size := in, 1; len(rest) > 0; size = size*5 + 2 {
+		if size > len(rest) {
+			size = len(rest)
+		}
+		out = append(out, resampler.Process(rest[:size])...)
+		rest = rest[size:]
+	}
+	out = append(out, resampler.Flush()...)
+
+	// The incomplete frame at the end is dropped.
+	if len(out) != 9600 {
+		t.Fatalf("passed through %d samples, want 9600", len(out))
+	}
+	for i := range out {
+		if math.Float64bits(out[i]) != math.Float64bits(in[i]) {
+			t.Fatalf("sample %d passed through as %v, want %v", i, out[i], in[i])
+		}
+	}
+}
+
+func TestResamplerSine(t *testing.T) {
+	const freqHz, amplitude = 1000.0, 0.5
+
+	for _, rates := range [][2]int{{44100, 48000}, {48000, 44100}} {
+		inRate, outRate := rates[0], rates[1]
+		in := make([]float64, inRate*2)
+		for i := range in {
+			in[i] = amplitude * math.Sin(2*math.Pi*freqHz*float64(i/2)/float64(inRate))
+		}
+		out := resampleAll(t, inRate, outRate, 2, in)
+
+		// Away from the edges, where the input is cut off, every output frame lies on the same sine at the output rate.
+		var maxErr, peak float64
+		for frame := outRate / 10; frame < len(out)/2-outRate/10; frame++ {
+			want := amplitude * math.Sin(2*math.Pi*freqHz*float64(frame)/float64(outRate))
+			for channel := 0; channel < 2; channel++ {
+				maxErr = math.Max(maxErr, math.Abs(out[frame*2+channel]-want))
+				peak = math.Max(peak, math.Abs(out[frame*2+channel]))
+			}
+		}
+		if maxErr > 1e-3 {
+			t.Errorf("%dHz to %dHz strays up to %v from the sine", inRate, outRate, maxErr)
+		}
+		if math.Abs(peak-amplitude) > 1e-3 {
+			t.Errorf("%dHz to %dHz peaks at %v, want %v", inRate, outRate, peak, amplitude)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package ffgoconv
+
+import (
+	"bufio"
+	"errors"
+	"time"
+)
+
+// maxRestartBackoff caps the exponential backoff between restarts of a failing streamer.
+const maxRestartBackoff = time.Minute
+
+// errRestarting is returned by reads that don't wait for a failed ffmpeg process to be restarted.
+var errRestarting = errors.New("ffgoconv: streamer: restarting")
+
+// Restarts returns how many times ffmpeg has been restarted after failing.
+func (streamer *Streamer) Restarts() int {
+	return int(streamer.restarts.Load())
+}
+
+// restartBackoff returns how long to wait before the given restart attempt, counting from 1.
+func (streamer *Streamer) restartBackoff(attempt int64) time.Duration {
+	backoff := streamer.options.RestartBackoff
+	if !streamer.options.ExponentialBackoff {
+		return backoff
+	}
+
+	for i := int64(1); i < attempt && backoff < maxRestartBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRestartBackoff {
+		backoff = maxRestartBackoff
+	}
+	return backoff
+}
+
+// scheduleRestart prepares to restart ffmpeg after it failed with err, returning how long to wait before doing so, or
+// false if it isn't to be restarted.
+func (streamer *Streamer) scheduleRestart(err error) (time.Duration, bool) {
+	streamer.Lock()
+	defer streamer.Unlock()
+
+	if streamer.closed.Load() || streamer.restartsLeft == 0 || streamer.startArgs == nil {
+		return 0, false
+	}
+	if streamer.restartsLeft > 0 {
+		streamer.restartsLeft--
+	}
+
+	streamer.exitErr = err
+	streamer.restarting = make(chan struct{})
+	return streamer.restartBackoff(streamer.restarts.Add(1)), true
+}
+
+// restartAfter waits out the backoff and restarts ffmpeg with the args it was first started with, after it failed with
+// err. If it can't be restarted, the streaming session ends with err.
+func (streamer *Streamer) restartAfter(err error, backoff time.Duration) {
+	failure := streamer.exitError(err)
+
+	streamer.Lock()
+	onRestart := streamer.options.OnRestart
+	restarting := streamer.restarting
+	args := streamer.startArgs
+	streamer.Unlock()
+
+	streamer.log().Infof("ffgoconv: streamer: restarting ffmpeg in %v after failure: %v", backoff, failure)
+	if onRestart != nil {
+		go onRestart(failure)
+	}
+
+	select {
+	case <-time.After(backoff):
+	case <-streamer.ended:
+	}
+
+	if restartErr := streamer.respawn(args); restartErr != nil {
+		if !streamer.closed.Load() {
+			streamer.setError(failure)
+		}
+		streamer.end()
+	}
+
+	streamer.Lock()
+	streamer.restarting = nil
+	streamer.Unlock()
+	close(restarting)
+}
+
+// awaitRestart waits for ffmpeg to exit once reading stdout has failed, and then for it to be restarted if it failed
+// and has restarts left, returning whether or not stdout has since been replaced. Unless wait is set, errRestarting is
+// returned instead of waiting for a restart.
+func (streamer *Streamer) awaitRestart(stdout *bufio.Reader, wait bool) (bool, error) {
+	streamer.Lock()
+	if streamer.stdout.Load() != stdout {
+		streamer.Unlock()
+		return true, nil
+	}
+	exited := streamer.exited
+	streamer.Unlock()
+
+	if exited == nil {
+		return false, nil
+	}
+	select {
+	case <-exited:
+	case <-time.After(endWaitTimeout):
+		return false, nil
+	}
+
+	streamer.Lock()
+	restarting := streamer.restarting
+	streamer.Unlock()
+
+	if restarting == nil {
+		return streamer.stdout.Load() != stdout, nil
+	}
+	if !wait {
+		return false, errRestarting
+	}
+
+	<-restarting
+	return streamer.stdout.Load() != stdout, nil
+}
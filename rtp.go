@@ -0,0 +1,72 @@
+package ffgoconv
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// validateRTP returns an error if the RTP options can't be used, for sessions outputting the rtp format.
+func (options *TransmuxerOptions) validateRTP() error {
+	if options.Format != "rtp" {
+		return nil
+	}
+	if !strings.HasPrefix(options.OutputFilepath, "rtp://") {
+		return errors.New("ffgoconv: rtp: output must be an rtp:// address, such as \"rtp://127.0.0.1:5004\"")
+	}
+	if options.RTPPayloadType < 0 || options.RTPPayloadType > 127 {
+		return errors.New("ffgoconv: rtp: payload type must be between 0 and 127")
+	}
+	return nil
+}
+
+// rtpArgs returns the ffmpeg output args setting the payload type and SSRC of the RTP stream, for sessions outputting
+// the rtp format.
+func (options *TransmuxerOptions) rtpArgs() []string {
+	if options.Format != "rtp" {
+		return nil
+	}
+
+	var args []string
+	if options.RTPPayloadType != 0 {
+		args = append(args, "-payload_type", strconv.Itoa(options.RTPPayloadType))
+	}
+	if options.RTPSSRC != 0 {
+		args = append(args, "-ssrc", strconv.FormatUint(uint64(options.RTPSSRC), 10))
+	}
+	return args
+}
+
+// captureSDP reads the session description that ffmpeg prints to stdout once it starts streaming RTP, which is the only
+// output it writes there.
+func (transmuxer *Transmuxer) captureSDP() {
+	var output []byte
+	chunk := make([]byte, 1024)
+	for {
+		n, err := transmuxer.FinalStream.Read(chunk)
+		if n > 0 {
+			output = append(output, chunk[:n]...)
+
+			sdp := string(output)
+			if i := strings.Index(sdp, "SDP:"); i >= 0 {
+				sdp = sdp[i+len("SDP:"):]
+			}
+
+			transmuxer.Lock()
+			transmuxer.sdp = strings.TrimSpace(sdp) + "\n"
+			transmuxer.Unlock()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// SDP returns the session description of the RTP stream of a session outputting the rtp format, for handing to the
+// receiving end. It is empty until ffmpeg has started streaming, which it does once audio is first mixed.
+func (transmuxer *Transmuxer) SDP() string {
+	transmuxer.Lock()
+	defer transmuxer.Unlock()
+
+	return transmuxer.sdp
+}
@@ -0,0 +1,387 @@
+package ffgoconv
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SegmentOptions configures how the finalized audio is split into segment files by Transmuxer.SegmentOutput.
+type SegmentOptions struct {
+	// NamePattern is the path of each segment file, in which %Y, %m, %d, %H, %M and %S are replaced with the year,
+	// month, day, hour, minute and second the segment was started at, %n with the zero-padded index of the segment and
+	// %% with a literal %, such as "recordings/%Y-%m-%d/%H-%M-%S_%n.mp3". Missing directories are created.
+	NamePattern string
+
+	// Format is the ffmpeg format of each segment, such as "mp3". Codec and Bitrate are optional, defaulting to
+	// whatever ffmpeg picks for the format.
+	Format  string
+	Codec   string
+	Bitrate string
+
+	// MaxDuration and MaxBytes are the duration of audio and the file size after which a new segment is started. At
+	// least one of them must be set. As the encoder lags slightly behind the mix, segments may exceed MaxBytes by up to
+	// a fraction of a second of encoded audio.
+	MaxDuration time.Duration
+	MaxBytes    int64
+
+	// OnSegment is called with every finalized segment, in order and from a separate goroutine, including the final
+	// partial segment once segmenting is stopped or the session is closed. It may take as long as it needs, such as to
+	// upload the segment, as every segment is finalized as soon as it is finished and merely queued up to be reported,
+	// without holding up the mix. Neither does stopping segmenting wait for it, so it may still be called afterwards.
+	OnSegment func(SegmentInfo)
+}
+
+// SegmentInfo describes a finalized segment file.
+type SegmentInfo struct {
+	Path     string
+	Index    int
+	Start    time.Time
+	Duration time.Duration
+	Size     int64
+	Err      error // Error finalizing the segment, if any
+}
+
+// codecFrameSizes lists the number of frames per packet of codecs with a fixed packet size, so that segments can end
+// on packet boundaries without any padding in between.
+var codecFrameSizes = map[string]int64{
+	"libmp3lame": 1152,
+	"mp2":        1152,
+	"aac":        1024,
+	"libfdk_aac": 1024,
+	"libopus":    960,
+	"opus":       960,
+	"ac3":        1536,
+	"eac3":       1536,
+}
+
+// formatDefaultCodecs lists the codecs ffmpeg picks for formats when no codec is given.
+var formatDefaultCodecs = map[string]string{
+	"mp3":  "libmp3lame",
+	"adts": "aac",
+	"ipod": "aac",
+	"mp4":  "aac",
+	"opus": "libopus",
+	"ac3":  "ac3",
+}
+
+// segmentFrameSize returns the number of frames segments of codec in format must be a multiple of.
+func segmentFrameSize(codec, format string) int64 {
+	if codec == "" {
+		codec = formatDefaultCodecs[format]
+	}
+	if size, ok := codecFrameSizes[codec]; ok {
+		return size
+	}
+	return 1
+}
+
+// segmentSizeInterval is the number of interleaved samples between checks of the size of the current segment, 100ms at
+// 48kHz stereo.
+const segmentSizeInterval = 9600
+
+// segment is a single segment file being encoded.
+type segment struct {
+	info SegmentInfo
+	sink *fileSink
+	done chan struct{} // Closed once the segment has been finalized
+}
+
+// segmenter encodes the finalized audio into a series of segment files, finalizing each one in the background.
+type segmenter struct {
+	sync.Mutex
+
+	ffmpegPath string
+	options    SegmentOptions
+	frame      int64
+	maxSamples int64
+
+	current   *segment
+	size      int64
+	lastCheck int64
+	index     int
+
+	finalizing sync.WaitGroup // Finished segments whose ffmpeg process has yet to finalize the file
+	finished   []*segment     // Finished segments waiting to be reported, so that OnSegment may take long
+	pending    *sync.Cond
+	closing    bool
+	err        error
+}
+
+// newSegmenter returns an initialized *segmenter with its first segment already started.
+func newSegmenter(ffmpegPath string, options SegmentOptions) (*segmenter, error) {
+	frame := segmentFrameSize(options.Codec, options.Format) * 2
+
+	segmenter := &segmenter{
+		ffmpegPath: ffmpegPath,
+		options:    options,
+		frame:      frame,
+	}
+	segmenter.pending = sync.NewCond(&segmenter.Mutex)
+	if options.MaxDuration > 0 {
+		// Round up to the next packet boundary, so that each segment only ends with padding once.
+		samples := durationSamples(options.MaxDuration)
+		segmenter.maxSamples = (samples + frame - 1) / frame * frame
+	}
+
+	if err := segmenter.start(); err != nil {
+		return nil, err
+	}
+
+	go segmenter.report()
+	return segmenter, nil
+}
+
+// start spawns an ffmpeg process encoding to a new segment file.
+func (segmenter *segmenter) start() error {
+	now := time.Now()
+	path := expandSegmentName(segmenter.options.NamePattern, now, segmenter.index)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("ffgoconv: segment: error creating directory for %s: %v", path, err)
+	}
+
+	var args []string
+	if segmenter.options.Codec != "" {
+		args = append(args, "-acodec", segmenter.options.Codec)
+	}
+	if segmenter.options.Bitrate != "" {
+		args = append(args, "-b:a", segmenter.options.Bitrate)
+	}
+	args = append(args, "-f", segmenter.options.Format)
+
+	sink, err := startFileSink(segmenter.ffmpegPath, path, args, true)
+	if err != nil {
+		return fmt.Errorf("ffgoconv: segment: error starting ffmpeg: %v", err)
+	}
+
+	segmenter.current = &segment{
+		info: SegmentInfo{
+			Path:  path,
+			Index: segmenter.index,
+			Start: now,
+		},
+		sink: sink,
+		done: make(chan struct{}),
+	}
+	segmenter.size = 0
+	segmenter.lastCheck = 0
+	segmenter.index++
+	return nil
+}
+
+// full returns whether or not the current segment has reached its maximum duration or size. It is only checked on
+// packet boundaries.
+func (segmenter *segmenter) full() bool {
+	current := segmenter.current
+	if segmenter.maxSamples > 0 && current.sink.written >= segmenter.maxSamples {
+		return true
+	}
+
+	if segmenter.options.MaxBytes > 0 && current.sink.written-segmenter.lastCheck >= segmentSizeInterval {
+		segmenter.lastCheck = current.sink.written
+		if info, err := os.Stat(current.info.Path); err == nil {
+			segmenter.size = info.Size()
+		}
+	}
+	return segmenter.options.MaxBytes > 0 && segmenter.size >= segmenter.options.MaxBytes
+}
+
+// write records samples, starting a new segment on the first packet boundary past the limits of the current one.
+func (segmenter *segmenter) write(samples []float64) {
+	segmenter.Lock()
+	defer segmenter.Unlock()
+
+	for len(samples) > 0 {
+		if segmenter.err != nil || segmenter.current == nil {
+			return
+		}
+
+		written := segmenter.current.sink.written
+		if written > 0 && written%segmenter.frame == 0 && segmenter.full() {
+			segmenter.finish()
+			if err := segmenter.start(); err != nil {
+				segmenter.err = err
+				return
+			}
+			written = 0
+		}
+
+		// Samples are written up to the next packet boundary, where the limits are checked again.
+		n := segmenter.frame - written%segmenter.frame
+		if n > int64(len(samples)) {
+			n = int64(len(samples))
+		}
+		if err := segmenter.current.sink.write(samples[:n]); err != nil {
+			segmenter.err = err
+			return
+		}
+		samples = samples[n:]
+	}
+}
+
+// finish finalizes the current segment in the background straight away, and queues it to be reported once it has been.
+// The caller must hold the lock.
+func (segmenter *segmenter) finish() {
+	segment := segmenter.current
+	segmenter.current = nil
+
+	segmenter.finalizing.Add(1)
+	go func() {
+		defer segmenter.finalizing.Done()
+		defer close(segment.done)
+
+		err := segment.sink.finish()
+		segment.info.Duration = samplesDuration(segment.sink.written)
+		if info, statErr := os.Stat(segment.info.Path); statErr == nil {
+			segment.info.Size = info.Size()
+		} else if err == nil {
+			err = statErr
+		}
+		if err != nil {
+			segment.info.Err = fmt.Errorf("ffgoconv: segment: error finalizing %s: %w", segment.info.Path, err)
+		}
+	}()
+
+	segmenter.finished = append(segmenter.finished, segment)
+	segmenter.pending.Signal()
+}
+
+// report reports every finished segment to OnSegment in order, once it has been finalized.
+func (segmenter *segmenter) report() {
+	for {
+		segmenter.Lock()
+		for len(segmenter.finished) == 0 && !segmenter.closing {
+			segmenter.pending.Wait()
+		}
+		if len(segmenter.finished) == 0 {
+			segmenter.Unlock()
+			return
+		}
+		segment := segmenter.finished[0]
+		segmenter.finished[0] = nil
+		segmenter.finished = segmenter.finished[1:]
+		segmenter.Unlock()
+
+		<-segment.done
+		if segmenter.options.OnSegment != nil {
+			segmenter.options.OnSegment(segment.info)
+		}
+	}
+}
+
+// close finalizes the current segment, waits for every segment to be finalized and returns the first error encountered
+// while segmenting. Segments that have yet to be reported are still reported afterwards, without waiting for OnSegment.
+func (segmenter *segmenter) close() error {
+	segmenter.Lock()
+	if segmenter.current != nil {
+		segmenter.finish()
+	}
+	segmenter.closing = true
+	segmenter.pending.Signal()
+	segmenter.Unlock()
+
+	segmenter.finalizing.Wait()
+
+	segmenter.Lock()
+	defer segmenter.Unlock()
+	return segmenter.err
+}
+
+// expandSegmentName returns the path of the segment started at t with the given index, following pattern.
+func expandSegmentName(pattern string, t time.Time, index int) string {
+	var name strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '%' || i+1 == len(pattern) {
+			name.WriteByte(pattern[i])
+			continue
+		}
+
+		i++
+		switch pattern[i] {
+		case 'Y':
+			name.WriteString(t.Format("2006"))
+		case 'm':
+			name.WriteString(t.Format("01"))
+		case 'd':
+			name.WriteString(t.Format("02"))
+		case 'H':
+			name.WriteString(t.Format("15"))
+		case 'M':
+			name.WriteString(t.Format("04"))
+		case 'S':
+			name.WriteString(t.Format("05"))
+		case 'n':
+			name.WriteString(fmt.Sprintf("%04d", index))
+		case '%':
+			name.WriteByte('%')
+		default:
+			name.WriteByte('%')
+			name.WriteByte(pattern[i])
+		}
+	}
+	return name.String()
+}
+
+// SegmentOutput starts splitting the finalized audio into a series of segment files as configured by options. Every
+// segment is encoded by its own ffmpeg process from the exact samples following the previous one, ending on a packet
+// boundary of the codec, so that each file plays standalone and the segments play back to back without gaps.
+//
+// The final partial segment is finalized and reported once StopSegmentOutput is called or the session is closed.
+func (transmuxer *Transmuxer) SegmentOutput(options SegmentOptions) error {
+	if transmuxer.closed {
+		return errors.New("ffgoconv: transmuxer: closed")
+	}
+
+	if options.NamePattern == "" {
+		return errors.New("ffgoconv: segment: name pattern must not be empty string")
+	}
+	if options.Format == "" {
+		return errors.New("ffgoconv: segment: format must not be empty string")
+	}
+	if options.Bitrate != "" && !bitratePattern.MatchString(options.Bitrate) {
+		return fmt.Errorf("ffgoconv: segment: bitrate %q must be a number of bits per second with an optional k, K or M suffix, such as \"320k\"", options.Bitrate)
+	}
+	if options.MaxDuration < 0 || options.MaxBytes < 0 {
+		return errors.New("ffgoconv: segment: maximum duration and size must not be negative")
+	}
+	if options.MaxDuration == 0 && options.MaxBytes == 0 {
+		return errors.New("ffgoconv: segment: maximum duration or size must be set")
+	}
+
+	transmuxer.Lock()
+	defer transmuxer.Unlock()
+
+	if transmuxer.segmenter.Load() != nil {
+		return errors.New("ffgoconv: segment: already segmenting")
+	}
+
+	ffmpegPath, err := lookFFmpeg(transmuxer.ffmpegPath)
+	if err != nil {
+		return err
+	}
+
+	segmenter, err := newSegmenter(ffmpegPath, options)
+	if err != nil {
+		return err
+	}
+
+	transmuxer.segmenter.Store(segmenter)
+	return nil
+}
+
+// StopSegmentOutput stops splitting the finalized audio into segment files, finalizing the current segment and waiting
+// for every segment file to be complete. Segments that have yet to be reported to OnSegment are reported afterwards. It
+// returns the first error encountered while segmenting, if any.
+func (transmuxer *Transmuxer) StopSegmentOutput() error {
+	segmenter := transmuxer.segmenter.Swap(nil)
+	if segmenter == nil {
+		return errors.New("ffgoconv: segment: not segmenting")
+	}
+
+	return segmenter.close()
+}
@@ -0,0 +1,115 @@
+package ffgoconv
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeEncoder is a script standing in for ffmpeg, which copies its stdin to the file named by its last argument
+// unchanged.
+const fakeEncoder = `#!/bin/sh
+case "$*" in
+*-version*) exit 0 ;;
+esac
+for last; do :; done
+exec cat > "$last"
+`
+
+// writeFakeFFmpeg writes script to an executable in a temporary directory and returns its path.
+func writeFakeFFmpeg(t *testing.T, script string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake ffmpeg is a shell script")
+	}
+
+	path := filepath.Join(t.TempDir(), "ffmpeg")
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSegmentOutputSlowCallback(t *testing.T) {
+	ffmpegPath := writeFakeFFmpeg(t, fakeEncoder)
+	dir := t.TempDir()
+
+	streamer, err := NewToneStreamer(440, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transmuxer, err := NewTransmuxerWithOptions([]*Streamer{streamer}, &TransmuxerOptions{MasterVolume: 1.0, FFmpegPath: ffmpegPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transmuxer.Close()
+
+	var mu sync.Mutex
+	var reported []SegmentInfo
+	all := make(chan struct{})
+	err = transmuxer.SegmentOutput(SegmentOptions{
+		NamePattern: filepath.Join(dir, "%n.pcm"),
+		Format:      "f64le",
+		MaxDuration: 100 * time.Millisecond,
+		OnSegment: func(info SegmentInfo) {
+			time.Sleep(100 * time.Millisecond)
+
+			mu.Lock()
+			defer mu.Unlock()
+			reported = append(reported, info)
+			if len(reported) == 10 {
+				close(all)
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := transmuxer.SetDurationLimit(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	go transmuxer.Run()
+	if _, err := io.Copy(ioutil.Discard, transmuxer); err != nil {
+		t.Fatal(err)
+	}
+
+	// Closing the session at the end of the duration limit waits for every file to be complete, but not for the slow
+	// callback to be through with all of them.
+	<-transmuxer.done
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("the session took %v to close, it waited for OnSegment", elapsed)
+	}
+
+	segmentSize := durationSamples(100*time.Millisecond) * 8
+	for i := 0; i < 10; i++ {
+		path := expandSegmentName(filepath.Join(dir, "%n.pcm"), time.Time{}, i)
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != segmentSize {
+			t.Errorf("%s holds %d bytes, want %d", path, info.Size(), segmentSize)
+		}
+	}
+
+	select {
+	case <-all:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not every segment was reported")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i, info := range reported {
+		if info.Index != i || info.Err != nil || info.Size != segmentSize || info.Duration != 100*time.Millisecond {
+			t.Errorf("segment %d was reported as %+v", i, info)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package ffgoconv
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync/atomic"
+	"time"
+)
+
+// defaultGracePeriod is how long a streamer's ffmpeg is given to exit on its own when the streamer is closed, unless
+// the streamer sets its own grace period.
+var defaultGracePeriod atomic.Int64
+
+func init() {
+	defaultGracePeriod.Store(int64(time.Second))
+}
+
+// SetDefaultGracePeriod sets how long ffmpeg is given to exit on its own once a streamer created afterwards is closed,
+// before it is killed. The default is 1 second, while a grace period of 0 kills ffmpeg straight away.
+func SetDefaultGracePeriod(grace time.Duration) {
+	if grace < 0 {
+		grace = 0
+	}
+	defaultGracePeriod.Store(int64(grace))
+}
+
+// SetGracePeriod sets how long ffmpeg is given to exit on its own once the streamer is closed, before it is killed. A
+// grace period of 0 kills ffmpeg straight away.
+func (streamer *Streamer) SetGracePeriod(grace time.Duration) error {
+	if streamer.closed.Load() {
+		return ErrStreamerClosed
+	}
+	if grace < 0 {
+		return errors.New("ffgoconv: streamer: grace period must not be negative")
+	}
+
+	streamer.gracePeriod.Store(int64(grace))
+	return nil
+}
+
+// stop shuts ffmpeg down by closing its stdin and asking it to terminate, only killing it if it hasn't exited once the
+// grace period is up.
+func stop(ffmpeg *exec.Cmd, stdin io.Closer, exited <-chan struct{}, grace time.Duration) error {
+	if grace > 0 {
+		stdin.Close()
+		terminate(ffmpeg.Process)
+
+		select {
+		case <-exited:
+			return nil
+		case <-time.After(grace):
+		}
+	}
+
+	// Once ffmpeg has been reaped, its process ID may be reused by an unrelated process, which must not be killed.
+	select {
+	case <-exited:
+		return nil
+	default:
+	}
+
+	if err := kill(ffmpeg.Process); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return fmt.Errorf("ffgoconv: streamer: error killing ffmpeg: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+//go:build !unix && !windows
+
+package ffgoconv
+
+import (
+	"os"
+	"os/exec"
+)
+
+// configureProcess does nothing, as process groups aren't supported on this platform.
+func configureProcess(cmd *exec.Cmd) {}
+
+// terminate asks process to exit by interrupting it.
+func terminate(process *os.Process) error {
+	return process.Signal(os.Interrupt)
+}
+
+// kill kills process.
+func kill(process *os.Process) error {
+	return process.Kill()
+}
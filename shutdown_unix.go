@@ -0,0 +1,37 @@
+//go:build unix
+
+package ffgoconv
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// configureProcess starts cmd in its own process group, so that any helper processes it spawns can be stopped along
+// with it.
+func configureProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// terminate asks the process group of process to exit by sending it SIGTERM.
+func terminate(process *os.Process) error {
+	return signalGroup(process, syscall.SIGTERM)
+}
+
+// kill kills the process group of process.
+func kill(process *os.Process) error {
+	return signalGroup(process, syscall.SIGKILL)
+}
+
+// signalGroup sends signal to the process group led by process, falling back to only process itself if it isn't
+// leading one.
+func signalGroup(process *os.Process, signal syscall.Signal) error {
+	if pgid, err := syscall.Getpgid(process.Pid); err == nil && pgid == process.Pid {
+		if err := syscall.Kill(-pgid, signal); err != syscall.ESRCH {
+			return err
+		}
+		return os.ErrProcessDone
+	}
+	return process.Signal(signal)
+}
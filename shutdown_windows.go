@@ -0,0 +1,31 @@
+//go:build windows
+
+package ffgoconv
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// configureProcess starts cmd in its own process group, so that it doesn't receive the console signals meant for the
+// parent process.
+func configureProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// terminate does nothing, as processes can't be sent signals on Windows. ffmpeg is left to exit once its stdin has been
+// closed, or is killed once the grace period is up.
+func terminate(process *os.Process) error {
+	return nil
+}
+
+// kill kills process along with every process it spawned using taskkill, falling back to only killing process itself
+// if taskkill fails.
+func kill(process *os.Process) error {
+	if err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(process.Pid)).Run(); err == nil {
+		return nil
+	}
+	return process.Kill()
+}
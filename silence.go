@@ -0,0 +1,82 @@
+package ffgoconv
+
+import (
+	"errors"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// SilenceEventType is the kind of change reported by a SilenceDetector.
+type SilenceEventType int
+
+const (
+	// SilenceStart is reported once the audio has stayed below the threshold for the minimum duration.
+	SilenceStart SilenceEventType = iota
+	// SilenceEnd is reported as soon as the audio rises above the threshold again after a SilenceStart.
+	SilenceEnd
+)
+
+// SilenceEvent reports that the audio seen by a SilenceDetector has gone silent or stopped being silent.
+type SilenceEvent struct {
+	Type SilenceEventType
+	// Position is how far into the audio seen by the detector the silence started or ended, accurate to the sample.
+	Position time.Duration
+}
+
+// SilenceDetector is a SampleProcessor reporting when the audio passing through it goes silent, such as a dead mic or a
+// stream that keeps sending zeros, and when it recovers. It passes the audio through unchanged, so it may be added to
+// either a streamer or the finalized audio of a transmuxing session.
+type SilenceDetector struct {
+	threshold  float64
+	minSamples int64
+	onEvent    func(event SilenceEvent)
+
+	position    int64 // Interleaved samples seen so far
+	silentSince int64 // Position of the first sample of the current run of silence
+	silent      atomic.Bool
+}
+
+// NewSilenceDetector returns an initialized *SilenceDetector, treating audio as silent while every sample stays at or
+// below threshold dBFS for at least minDuration. The onEvent callback is called from the mix loop with every event, so
+// it must never block.
+func NewSilenceDetector(threshold float64, minDuration time.Duration, onEvent func(event SilenceEvent)) (*SilenceDetector, error) {
+	if threshold > 0 || math.IsNaN(threshold) {
+		return nil, errors.New("ffgoconv: silence: threshold must not be greater than 0 dBFS")
+	}
+	if minDuration < 0 {
+		return nil, errors.New("ffgoconv: silence: minimum duration must not be negative")
+	}
+	if onEvent == nil {
+		return nil, errors.New("ffgoconv: silence: callback must not be nil")
+	}
+
+	return &SilenceDetector{
+		threshold:  math.Pow(10, threshold/20),
+		minSamples: durationSamples(minDuration),
+		onEvent:    onEvent,
+	}, nil
+}
+
+// Process implements SampleProcessor.
+func (detector *SilenceDetector) Process(samples []float64) []float64 {
+	for _, sample := range samples {
+		if math.Abs(sample) > detector.threshold {
+			if detector.silent.Load() {
+				detector.silent.Store(false)
+				detector.onEvent(SilenceEvent{Type: SilenceEnd, Position: samplesDuration(detector.position)})
+			}
+			detector.silentSince = detector.position + 1
+		} else if !detector.silent.Load() && detector.position+1-detector.silentSince >= detector.minSamples {
+			detector.silent.Store(true)
+			detector.onEvent(SilenceEvent{Type: SilenceStart, Position: samplesDuration(detector.silentSince)})
+		}
+		detector.position++
+	}
+	return samples
+}
+
+// Silent returns whether or not the audio is currently considered silent.
+func (detector *SilenceDetector) Silent() bool {
+	return detector.silent.Load()
+}
@@ -0,0 +1,92 @@
+package ffgoconv
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// tone returns d of an interleaved 48kHz stereo cosine wave of the given frequency in Hz and amplitude.
+func tone(freqHz, amplitude float64, d time.Duration) []float64 {
+	samples := make([]float64, durationSamples(d))
+	for i := 0; i < len(samples); i += 2 {
+		sample := amplitude * math.Cos(2*math.Pi*freqHz*float64(i/2)/48000)
+		samples[i] = sample
+		samples[i+1] = sample
+	}
+	return samples
+}
+
+// concat returns the samples of every part one after another.
+func concat(parts ...[]float64) []float64 {
+	var samples []float64
+	for _, part := range parts {
+		samples = append(samples, part...)
+	}
+	return samples
+}
+
+func TestSilenceDetectorProcess(t *testing.T) {
+	tests := []struct {
+		name   string
+		audio  []float64
+		events []SilenceEvent
+	}{
+		{
+			name:  "tone then silence then tone",
+			audio: concat(tone(440, 0.5, 500*time.Millisecond), make([]float64, durationSamples(time.Second)), tone(440, 0.5, 250*time.Millisecond)),
+			events: []SilenceEvent{
+				{Type: SilenceStart, Position: 500 * time.Millisecond},
+				{Type: SilenceEnd, Position: 1500 * time.Millisecond},
+			},
+		},
+		{
+			name:  "gap shorter than the minimum duration",
+			audio: concat(tone(440, 0.5, 500*time.Millisecond), make([]float64, durationSamples(100*time.Millisecond)), tone(440, 0.5, 250*time.Millisecond)),
+		},
+		{
+			name:  "tone below the threshold",
+			audio: tone(440, 0.0001, time.Second),
+			events: []SilenceEvent{
+				{Type: SilenceStart, Position: 0},
+			},
+		},
+		{
+			name:  "silence until the end",
+			audio: concat(tone(440, 0.5, 250*time.Millisecond), make([]float64, durationSamples(time.Second))),
+			events: []SilenceEvent{
+				{Type: SilenceStart, Position: 250 * time.Millisecond},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var events []SilenceEvent
+			detector, err := NewSilenceDetector(-60, 200*time.Millisecond, func(event SilenceEvent) {
+				events = append(events, event)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The audio is processed in blocks like the mix loop does, which must not affect the positions reported.
+			for start := 0; start < len(test.audio); start += mixBlockSize {
+				end := start + mixBlockSize
+				if end > len(test.audio) {
+					end = len(test.audio)
+				}
+				detector.Process(test.audio[start:end])
+			}
+
+			if len(events) != len(test.events) {
+				t.Fatalf("got events %v, want %v", events, test.events)
+			}
+			for i, event := range events {
+				if event != test.events[i] {
+					t.Errorf("event %d is %v, want %v", i, event, test.events[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package ffgoconv
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+	"os/exec"
+)
+
+// fileSink is an ffmpeg process encoding the finalized audio, written to it as float64 PCM, into a single file.
+type fileSink struct {
+	path    string
+	process *exec.Cmd
+	stdin   io.WriteCloser
+	writer  *bufio.Writer
+	written int64 // Number of interleaved samples written
+}
+
+// startFileSink spawns an ffmpeg process encoding to path with the given output args, which apply to the file, such as
+// its format and codec. Unless overwrite is set, ffmpeg fails rather than overwriting an existing file. The error of
+// starting ffmpeg is returned as is, for the caller to report.
+func startFileSink(ffmpegPath, path string, outputArgs []string, overwrite bool) (*fileSink, error) {
+	args := []string{
+		"-nostats",
+		"-loglevel", "error",
+		"-acodec", "pcm_f64le",
+		"-f", "f64le",
+		"-ar", "48000",
+		"-ac", "2",
+		"-i", "-",
+	}
+	args = append(args, outputArgs...)
+	args = append(args, "-ar", "48000", "-ac", "2")
+	if overwrite {
+		args = append(args, "-y")
+	} else {
+		args = append(args, "-n")
+	}
+	args = append(args, path)
+
+	ffmpeg := exec.Command(ffmpegPath, args...)
+	configureProcess(ffmpeg)
+
+	stdin, err := ffmpeg.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ffmpeg.Start(); err != nil {
+		stdin.Close()
+		return nil, err
+	}
+
+	return &fileSink{
+		path:    path,
+		process: ffmpeg,
+		stdin:   stdin,
+		writer:  bufio.NewWriterSize(stdin, 65536),
+	}, nil
+}
+
+// write writes samples to ffmpeg.
+func (sink *fileSink) write(samples []float64) error {
+	var bs [8]byte
+	for _, sample := range samples {
+		binary.LittleEndian.PutUint64(bs[:], math.Float64bits(sample))
+		if _, err := sink.writer.Write(bs[:]); err != nil {
+			return err
+		}
+		sink.written++
+	}
+	return nil
+}
+
+// finish flushes whatever is left to ffmpeg, closes its stdin and waits for it to finalize the file.
+func (sink *fileSink) finish() error {
+	err := sink.writer.Flush()
+	if closeErr := sink.stdin.Close(); err == nil {
+		err = closeErr
+	}
+	if waitErr := sink.process.Wait(); err == nil {
+		err = waitErr
+	}
+	return err
+}
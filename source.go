@@ -0,0 +1,84 @@
+package ffgoconv
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// SampleSource is a source of interleaved 48kHz stereo float64 samples produced in Go rather than decoded by ffmpeg,
+// such as a synthesizer or audio received from another library. Sources at other sample rates can be converted with a
+// Resampler first. A *Streamer is itself a SampleSource.
+type SampleSource interface {
+	// ReadSamples reads up to len(dst) samples into dst, blocking until at least one is available, and returns how
+	// many were read. It returns io.EOF once the source has ended.
+	ReadSamples(dst []float64) (int, error)
+	// Close releases the source once the streamer reading it has been closed.
+	Close() error
+}
+
+// sourceReader is an io.Reader encoding the samples of a SampleSource as float64 PCM.
+type sourceReader struct {
+	source  SampleSource
+	samples []float64
+}
+
+// Read implements io.Reader, filling p with as many complete samples as the source has available.
+func (reader *sourceReader) Read(p []byte) (int, error) {
+	size := len(p) / 8
+	if size == 0 {
+		return 0, nil
+	}
+	if cap(reader.samples) < size {
+		reader.samples = make([]float64, size)
+	}
+
+	for {
+		n, err := reader.source.ReadSamples(reader.samples[:size])
+		for i, sample := range reader.samples[:n] {
+			binary.LittleEndian.PutUint64(p[i*8:], math.Float64bits(sample))
+		}
+		if n > 0 || err != nil {
+			return n * 8, err
+		}
+	}
+}
+
+// NewSourceStreamer returns an initialized *Streamer reading its samples from source, without an ffmpeg process, or an
+// error if one could not be created. The streamer can be mixed like any other, and closes source once it is closed.
+//
+// The variable volume must be a floating-point number between 0 and 2, representing a percentage value. For example, 20% volume would be 0.2.
+func NewSourceStreamer(source SampleSource, volume float64) (*Streamer, error) {
+	if source == nil {
+		return nil, errors.New("ffgoconv: streamer: sample source must not be nil")
+	}
+	if volume < 0.0 || volume > 2.0 {
+		return nil, errors.New("ffgoconv: streamer: volume must not be less than 0.0 (0%) or greater than 2.0 (200%)")
+	}
+
+	streamer := newPCMStreamer(&sourceReader{source: source})
+	streamer.source = source
+	streamer.volume.Store(math.Float64bits(volume))
+	return streamer, nil
+}
+
+// AddSource initializes and adds a *Streamer reading its samples from source to the transmuxing session, or returns an
+// error if one could not be initialized. See NewSourceStreamer for info on supported arguments.
+func (transmuxer *Transmuxer) AddSource(source SampleSource, volume float64) (*Streamer, error) {
+	if transmuxer.closed {
+		return nil, errors.New("ffgoconv: transmuxer: closed")
+	}
+
+	if !transmuxer.reserveSlot() {
+		return nil, ErrTooManyStreamers
+	}
+
+	streamer, err := NewSourceStreamer(source, volume)
+	if err != nil {
+		transmuxer.releaseSlot()
+		return nil, err
+	}
+
+	transmuxer.addStreamer(streamer)
+	return streamer, nil
+}
@@ -0,0 +1,212 @@
+package ffgoconv
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// StallPolicy defines how the transmuxing session treats a streamer that has no audio ready when it is mixed.
+type StallPolicy int32
+
+const (
+	// StallBlock waits for the streamer to produce audio, freezing the entire mix until it does. This is the default.
+	StallBlock StallPolicy = iota
+	// StallSilence mixes silence in place of the streamer while it is behind. Once it recovers, up to the configured
+	// limit of its backlog is skipped so that it catches up with the rest of the mix.
+	StallSilence
+	// StallDrop mixes silence in place of the streamer while it is behind, and closes it with ErrStreamStalled once it
+	// has been behind for longer than the configured limit.
+	StallDrop
+)
+
+const (
+	// stallBlockSize is the number of interleaved samples read ahead at once, 10ms at 48kHz stereo.
+	stallBlockSize = 960
+	// stallBlocks is the number of blocks that may be read ahead of the mix.
+	stallBlocks = 10
+)
+
+// stallReader reads a streamer ahead of the mix loop in a separate goroutine, so that the mix loop can tell when the
+// streamer has fallen behind instead of blocking on it.
+type stallReader struct {
+	policy atomic.Int32
+	limit  atomic.Int64
+
+	blocks  chan []float64
+	done    chan struct{}
+	err     error
+	waiting *atomic.Int64
+
+	ready     chan struct{} // Closed once the first block has been read ahead, or reading ahead has stopped
+	readyOnce sync.Once
+	started   atomic.Bool // Whether or not a block has been read ahead
+
+	block    []float64
+	pos      int
+	behind   int64
+	consumed *atomic.Int64
+}
+
+// readAhead reads blocks of samples from streamer until it fails, recording the error once the blocks are drained.
+func (stall *stallReader) readAhead(streamer *Streamer) {
+	defer close(stall.blocks)
+	defer stall.markReady()
+
+	for {
+		block := make([]float64, stallBlockSize)
+		for n := 0; n < stallBlockSize; {
+			read, err := streamer.ReadSamples(block[n:])
+			n += read
+			if err != nil {
+				stall.err = err
+				if n > 0 {
+					stall.send(block[:n])
+				}
+				return
+			}
+		}
+
+		if !stall.send(block) {
+			return
+		}
+	}
+}
+
+// send hands a block over to the mix loop, returning false if the streamer was closed in the meantime.
+func (stall *stallReader) send(block []float64) bool {
+	select {
+	case stall.blocks <- block:
+		stall.started.Store(true)
+		stall.markReady()
+		return true
+	case <-stall.done:
+		return false
+	}
+}
+
+// markReady marks the first block as read ahead, or reading ahead as stopped without one.
+func (stall *stallReader) markReady() {
+	stall.readyOnce.Do(func() {
+		close(stall.ready)
+	})
+}
+
+// prebuffer blocks until the first block has been read ahead, returning an error if the streamer failed before it
+// produced any audio.
+func (stall *stallReader) prebuffer() error {
+	<-stall.ready
+	if !stall.started.Load() {
+		return fmt.Errorf("ffgoconv: streamer: error buffering ffmpeg output: %v", stall.err)
+	}
+	return nil
+}
+
+// next returns the next sample to mix for the streamer according to the stall policy.
+func (stall *stallReader) next() (float64, error) {
+	for stall.pos >= len(stall.block) {
+		policy := StallPolicy(stall.policy.Load())
+
+		var block []float64
+		var ok bool
+		select {
+		case block, ok = <-stall.blocks:
+		default:
+			if policy != StallBlock {
+				return stall.underrun()
+			}
+			startWaiting(stall.waiting)
+			block, ok = <-stall.blocks
+		}
+		if !ok {
+			return 0, stall.err
+		}
+		stall.waiting.Store(0)
+		stall.block, stall.pos = block, 0
+		if policy == StallBlock {
+			continue
+		}
+
+		// Skip as much of the backlog as the streamer fell behind by, so that it lines up with the mix again.
+		for stall.behind > 0 && stall.pos < len(stall.block) {
+			stall.pos++
+			stall.behind--
+			stall.consumed.Add(1)
+		}
+	}
+
+	sample := stall.block[stall.pos]
+	stall.pos++
+	stall.consumed.Add(1)
+	return sample, nil
+}
+
+// underrun returns the sample to mix while the streamer is behind.
+func (stall *stallReader) underrun() (float64, error) {
+	limit := time.Duration(stall.limit.Load())
+	startWaiting(stall.waiting)
+
+	switch StallPolicy(stall.policy.Load()) {
+	case StallSilence:
+		if stall.behind < durationSamples(limit) {
+			stall.behind++
+		}
+	case StallDrop:
+		if time.Since(time.Unix(0, stall.waiting.Load())) > limit {
+			return 0, fmt.Errorf("%w: no audio produced for longer than %v", ErrStreamStalled, limit)
+		}
+	}
+
+	return 0, nil
+}
+
+// SetStallPolicy sets how the transmuxing session treats the streamer when it has no audio ready to be mixed. For
+// StallSilence, limit is the maximum amount of backlog skipped to catch up once the streamer recovers. For StallDrop,
+// limit is how long the streamer may be behind before it is closed.
+//
+// Once a policy other than StallBlock has been set, the streamer is read ahead of the mix in a separate goroutine and
+// ReadSample must no longer be called directly.
+func (streamer *Streamer) SetStallPolicy(policy StallPolicy, limit time.Duration) error {
+	if streamer.closed.Load() {
+		return ErrStreamerClosed
+	}
+	if policy < StallBlock || policy > StallDrop {
+		return errors.New("ffgoconv: stall: unknown stall policy")
+	}
+	if limit < 0 {
+		return errors.New("ffgoconv: stall: limit must not be negative")
+	}
+
+	streamer.Lock()
+	defer streamer.Unlock()
+
+	if streamer.closed.Load() {
+		return ErrStreamerClosed
+	}
+
+	if stall := streamer.stall.Load(); stall != nil {
+		stall.policy.Store(int32(policy))
+		stall.limit.Store(int64(limit))
+		return nil
+	}
+
+	if policy == StallBlock {
+		return nil
+	}
+
+	stall := &stallReader{
+		blocks:   make(chan []float64, stallBlocks),
+		done:     make(chan struct{}),
+		ready:    make(chan struct{}),
+		consumed: &streamer.consumed,
+		waiting:  &streamer.waiting,
+	}
+	stall.policy.Store(int32(policy))
+	stall.limit.Store(int64(limit))
+	streamer.stall.Store(stall)
+
+	go stall.readAhead(streamer)
+	return nil
+}
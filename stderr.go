@@ -0,0 +1,246 @@
+package ffgoconv
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// stderrTailSize is the amount of raw ffmpeg stderr output retained for diagnostics.
+	stderrTailSize = 8192
+	// stderrDrainTimeout is how long to wait for the last of the stderr output once ffmpeg has exited.
+	stderrDrainTimeout = 100 * time.Millisecond
+)
+
+// TranscodeStats contains the progress of an ffmpeg process, as reported on its stderr.
+type TranscodeStats struct {
+	Size     int           // Size of the output so far, in kilobytes
+	Duration time.Duration // Duration of the audio processed so far
+	Bitrate  float64       // Bitrate of the output, in kilobits per second
+	Speed    float64       // Speed of processing relative to real time
+}
+
+// stderrLog continuously drains the stderr of an ffmpeg process so that it never backs up, retaining a bounded tail of the
+// raw output and parsing progress lines into TranscodeStats.
+type stderrLog struct {
+	sync.Mutex
+
+	tail     []byte
+	stats    TranscodeStats
+	progress TranscodeStats // Block of -progress output being parsed
+	ended    bool           // Whether or not -progress output reported the end of the output
+	onStats  func(stats TranscodeStats)
+	onEnd    func()
+	done     chan struct{}
+}
+
+// newStderrLog returns an initialized *stderrLog reading from stderr until it is closed, calling onStats, if set, with
+// every progress update, and onEnd, if set, once -progress output reports the end of the output.
+func newStderrLog(stderr io.Reader, onStats func(stats TranscodeStats), onEnd func()) *stderrLog {
+	stderrLog := &stderrLog{onStats: onStats, onEnd: onEnd, done: make(chan struct{})}
+	go stderrLog.read(stderr)
+	return stderrLog
+}
+
+// read splits stderr into lines, which ffmpeg terminates with either a carriage return for progress or a newline.
+func (stderrLog *stderrLog) read(stderr io.Reader) {
+	defer close(stderrLog.done)
+
+	scanner := bufio.NewScanner(stderr)
+	scanner.Split(scanStderrLines)
+
+	for scanner.Scan() {
+		stderrLog.handleStderrLine(scanner.Text())
+	}
+}
+
+// wait blocks until stderr has been read to the end, or until timeout has passed.
+func (stderrLog *stderrLog) wait(timeout time.Duration) {
+	select {
+	case <-stderrLog.done:
+	case <-time.After(timeout):
+	}
+}
+
+// scanStderrLines is a bufio.SplitFunc splitting on either carriage returns or newlines.
+func scanStderrLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// handleStderrLine retains line in the tail and parses it if it reports progress.
+func (stderrLog *stderrLog) handleStderrLine(line string) {
+	stderrLog.Lock()
+	wasEnded := stderrLog.ended
+	published := stderrLog.parseStderrLine(line)
+	stats := stderrLog.stats
+	ended := stderrLog.ended && !wasEnded
+	stderrLog.Unlock()
+
+	if published && stderrLog.onStats != nil {
+		stderrLog.onStats(stats)
+	}
+	if ended && stderrLog.onEnd != nil {
+		stderrLog.onEnd()
+	}
+}
+
+// parseStderrLine retains line in the tail and parses it if it reports progress, returning whether or not new stats were
+// published. It must be called with stderrLog locked.
+func (stderrLog *stderrLog) parseStderrLine(line string) bool {
+	stderrLog.tail = append(stderrLog.tail, line...)
+	stderrLog.tail = append(stderrLog.tail, '\n')
+	if len(stderrLog.tail) > stderrTailSize {
+		stderrLog.tail = append(stderrLog.tail[:0], stderrLog.tail[len(stderrLog.tail)-stderrTailSize:]...)
+	}
+
+	if published, ok := stderrLog.handleProgressLine(line); ok {
+		return published
+	}
+	stats, ok := parseStatsLine(line)
+	if !ok {
+		return false
+	}
+	stderrLog.stats = stats
+	return true
+}
+
+// parseStatsLine parses a progress line printed by ffmpeg with -stats, such as
+//
+//	size=    1024KiB time=00:01:02.45 bitrate= 135.2kbits/s speed=31.2x
+//
+// Every key=value pair is parsed on its own, so that unknown keys are skipped and values of N/A are treated as unknown,
+// which is reported as 0. It returns false if line doesn't report progress.
+func parseStatsLine(line string) (TranscodeStats, bool) {
+	var stats TranscodeStats
+	var hasSize, hasTime bool
+
+	fields := strings.Fields(line)
+	for i := 0; i < len(fields); i++ {
+		key, value, ok := strings.Cut(fields[i], "=")
+		if !ok {
+			continue
+		}
+		// ffmpeg pads values to a fixed width, which leaves them in the field after their key.
+		if value == "" && i+1 < len(fields) && !strings.Contains(fields[i+1], "=") {
+			i++
+			value = fields[i]
+		}
+
+		switch key {
+		case "size", "Lsize":
+			stats.Size, hasSize = parseStatsSize(value), true
+		case "time":
+			stats.Duration, hasTime = parseStatsTime(value), true
+		case "bitrate":
+			stats.Bitrate = parseStatsFloat(value, "kbits/s")
+		case "speed":
+			stats.Speed = parseStatsFloat(value, "x")
+		}
+	}
+
+	return stats, hasSize && hasTime
+}
+
+// parseStatsSize parses a size printed by ffmpeg into kilobytes, accepting both the kB of older builds and the KiB of
+// newer ones, returning 0 if it is unknown.
+func parseStatsSize(value string) int {
+	units := []struct {
+		suffix string
+		scale  float64
+	}{
+		{"KiB", 1}, {"kB", 1}, {"MiB", 1024}, {"MB", 1024}, {"GiB", 1024 * 1024}, {"GB", 1024 * 1024}, {"B", 1.0 / 1024},
+	}
+	for _, unit := range units {
+		if strings.HasSuffix(value, unit.suffix) {
+			return int(parseStatsFloat(value, unit.suffix) * unit.scale)
+		}
+	}
+	return int(parseStatsFloat(value, ""))
+}
+
+// parseStatsTime parses a time printed by ffmpeg as [-]HH:MM:SS.ss, returning 0 if it is unknown or negative, which
+// ffmpeg reports before the first packet has been processed.
+func parseStatsTime(value string) time.Duration {
+	parts := strings.Split(value, ":")
+	if len(parts) != 3 || strings.HasPrefix(value, "-") {
+		return 0
+	}
+
+	hours, errH := strconv.Atoi(parts[0])
+	minutes, errM := strconv.Atoi(parts[1])
+	seconds, errS := strconv.ParseFloat(parts[2], 64)
+	if errH != nil || errM != nil || errS != nil {
+		return 0
+	}
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute + time.Duration(seconds*float64(time.Second))
+}
+
+// parseStatsFloat parses a number printed by ffmpeg with the given unit suffix, returning 0 if it is unknown.
+func parseStatsFloat(value, suffix string) float64 {
+	f, err := strconv.ParseFloat(strings.TrimSuffix(value, suffix), 64)
+	if err != nil {
+		return 0
+	}
+	return f
+}
+
+// Stats returns a copy of the latest progress reported by ffmpeg.
+func (stderrLog *stderrLog) Stats() *TranscodeStats {
+	stderrLog.Lock()
+	defer stderrLog.Unlock()
+
+	stats := stderrLog.stats
+	return &stats
+}
+
+// Tail returns the retained tail of the raw ffmpeg stderr output.
+func (stderrLog *stderrLog) Tail() string {
+	stderrLog.Lock()
+	defer stderrLog.Unlock()
+
+	return string(stderrLog.tail)
+}
+
+// Stats returns the latest decoding progress reported by the streamer's ffmpeg, including how far into the input it has
+// decoded and how fast. Progress is only reported when ffmpeg is run with -stats, which the default args include, or with
+// StreamerOptions.Progress set.
+func (streamer *Streamer) Stats() *TranscodeStats {
+	streamer.Lock()
+	stderrLog := streamer.stderrLog
+	streamer.Unlock()
+
+	return stderrLog.Stats()
+}
+
+// FFmpegOutput returns the tail of the streamer's ffmpeg stderr output, which is useful when diagnosing errors.
+func (streamer *Streamer) FFmpegOutput() string {
+	streamer.Lock()
+	stderrLog := streamer.stderrLog
+	streamer.Unlock()
+
+	return stderrLog.Tail()
+}
+
+// withOutput returns err annotated with the tail of the streamer's ffmpeg stderr output, if there is any.
+func (streamer *Streamer) withOutput(err error) error {
+	output := strings.TrimSpace(streamer.FFmpegOutput())
+	if output == "" {
+		return err
+	}
+	return fmt.Errorf("%w; ffmpeg output: %s", err, output)
+}
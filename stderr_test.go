@@ -0,0 +1,117 @@
+package ffgoconv
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseStatsLine(t *testing.T) {
+	tests := []struct {
+		name  string
+		line  string
+		stats TranscodeStats
+		ok    bool
+	}{
+		{
+			name:  "ffmpeg 4",
+			line:  "size=    1024kB time=00:01:02.45 bitrate= 134.3kbits/s speed=31.2x    ",
+			stats: TranscodeStats{Size: 1024, Duration: 62450 * time.Millisecond, Bitrate: 134.3, Speed: 31.2},
+			ok:    true,
+		},
+		{
+			name:  "ffmpeg 4 final line",
+			line:  "size=   37500kB time=00:03:20.00 bitrate=1536.0kbits/s speed= 412x    ",
+			stats: TranscodeStats{Size: 37500, Duration: 200 * time.Second, Bitrate: 1536, Speed: 412},
+			ok:    true,
+		},
+		{
+			name:  "ffmpeg 4 output file",
+			line:  "Lsize=    4821kB time=00:05:08.53 bitrate= 128.0kbits/s speed= 244x    ",
+			stats: TranscodeStats{Size: 4821, Duration: 308530 * time.Millisecond, Bitrate: 128, Speed: 244},
+			ok:    true,
+		},
+		{
+			name:  "ffmpeg 4 with video",
+			line:  "frame=  120 fps= 60 q=-1.0 size=     256kB time=00:00:04.00 bitrate= 524.3kbits/s speed=1.99x    ",
+			stats: TranscodeStats{Size: 256, Duration: 4 * time.Second, Bitrate: 524.3, Speed: 1.99},
+			ok:    true,
+		},
+		{
+			name:  "ffmpeg 5",
+			line:  "size=     512kB time=00:00:32.76 bitrate= 128.0kbits/s speed=65.5x    ",
+			stats: TranscodeStats{Size: 512, Duration: 32760 * time.Millisecond, Bitrate: 128, Speed: 65.5},
+			ok:    true,
+		},
+		{
+			name:  "ffmpeg 5 without output size",
+			line:  "size=N/A time=00:00:05.01 bitrate=N/A speed=10.1x    ",
+			stats: TranscodeStats{Duration: 5010 * time.Millisecond, Speed: 10.1},
+			ok:    true,
+		},
+		{
+			name:  "ffmpeg 5 before the first packet",
+			line:  "size=       0kB time=-577014:32:22.77 bitrate=  -0.0kbits/s speed=N/A    ",
+			stats: TranscodeStats{Bitrate: 0},
+			ok:    true,
+		},
+		{
+			name:  "ffmpeg 6",
+			line:  "size=    2048kB time=00:02:11.07 bitrate= 128.0kbits/s speed= 262x    ",
+			stats: TranscodeStats{Size: 2048, Duration: 131070 * time.Millisecond, Bitrate: 128, Speed: 262},
+			ok:    true,
+		},
+		{
+			name:  "ffmpeg 6 slow input",
+			line:  "size=      96kB time=00:00:06.12 bitrate= 128.5kbits/s speed=0.999x    ",
+			stats: TranscodeStats{Size: 96, Duration: 6120 * time.Millisecond, Bitrate: 128.5, Speed: 0.999},
+			ok:    true,
+		},
+		{
+			name:  "ffmpeg 7",
+			line:  "size=    1536KiB time=00:01:38.30 bitrate= 128.0kbits/s speed= 196x elapsed=0:00:00.50    ",
+			stats: TranscodeStats{Size: 1536, Duration: 98300 * time.Millisecond, Bitrate: 128, Speed: 196},
+			ok:    true,
+		},
+		{
+			name:  "ffmpeg 7 large output",
+			line:  "size=   12.5MiB time=01:02:03.04 bitrate=  28.2kbits/s speed=1.3e+03x elapsed=0:00:02.86    ",
+			stats: TranscodeStats{Size: 12800, Duration: time.Hour + 2*time.Minute + 3040*time.Millisecond, Bitrate: 28.2, Speed: 1300},
+			ok:    true,
+		},
+		{
+			name: "input info",
+			line: "  Duration: 00:03:25.00, start: 0.025056, bitrate: 320 kb/s",
+		},
+		{
+			name: "stream summary",
+			line: "video:0kB audio:37500kB subtitle:0kB other streams:0kB global headers:0kB muxing overhead: 0.000000%",
+		},
+		{
+			name: "warning",
+			line: "[mp3 @ 0x55d0c8a1b2c0] Estimating duration from bitrate, this may be inaccurate",
+		},
+		{
+			name: "empty line",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stats, ok := parseStatsLine(test.line)
+			if ok != test.ok {
+				t.Fatalf("parsing %q returned %v, want %v", test.line, ok, test.ok)
+			}
+			if !ok {
+				return
+			}
+
+			duration := stats.Duration - test.stats.Duration
+			if duration < 0 {
+				duration = -duration
+			}
+			if stats.Size != test.stats.Size || duration > time.Microsecond || stats.Bitrate != test.stats.Bitrate || stats.Speed != test.stats.Speed {
+				t.Errorf("parsing %q returned %+v, want %+v", test.line, stats, test.stats)
+			}
+		})
+	}
+}
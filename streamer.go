@@ -1,27 +1,209 @@
 package ffgoconv
 
 import (
+	"bufio"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"math"
+	"os"
 	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+const (
+	// streamerBufferSize is the amount of decoded audio buffered before a streamer is mixed, 20ms of 48kHz stereo float64
+	// samples.
+	streamerBufferSize = 960 * 2 * 8
+	// defaultPipeBufferSize is the default size of the buffers used to read from and write to the pipes of ffmpeg.
+	defaultPipeBufferSize = 64 * 1024
+)
+
+var _ io.ReadWriteCloser = (*Streamer)(nil)
+
 // Streamer contains all the data required to run a streaming session.
 type Streamer struct {
+	sync.Mutex
+
 	Process *exec.Cmd
 	running bool
-	closed  bool
+	closed  atomic.Bool
 	Error   error
 
-	Volume float64
+	volume atomic.Uint64 // Float64bits of the volume, as it is set by API callers while being read by the mix loop
+
+	meter    meter
+	ducking  float64
+	autoGain autoGain
+	stall    atomic.Pointer[stallReader]
+
+	successor atomic.Pointer[Streamer] // Streamer taking over the streamer's place in the mix once its output ends
+	source    SampleSource             // Source of the samples of a streamer without an ffmpeg process, if any
+
+	callback func(err error)
+	released func() // Called once the streamer has been closed, freeing its slot in the transmuxing session
+
+	processors []SampleProcessor
+	mixBuffer  []float64
+	readBuffer []byte
+
+	lastData atomic.Int64
+	waiting  atomic.Int64 // Time the mix loop has been waiting on the streamer for audio since, or 0 if it isn't
+	logger   atomic.Pointer[Logger]
 
-	Stderr io.ReadCloser
+	progress       chan TranscodeStats
+	progressMu     sync.Mutex
+	progressClosed bool
+
+	corrupt    atomic.Int64
+	corruptRun int64
+	maxCorrupt atomic.Int64
+
+	input      string
+	options    StreamerOptions
+	offset     time.Duration
+	tempo      float64
+	sampleRate int
+	channels   int
+	precision  Precision
+	consumed   atomic.Int64
+
+	durationMu  sync.Mutex // Guards the probed duration, so that probing the input never blocks the mix loop
+	probed      bool
+	duration    time.Duration
+	durationErr error
+
+	Stderr io.ReadCloser // Deprecated: The stderr of ffmpeg is drained internally, see FFmpegOutput.
 	Stdin  io.WriteCloser
 	Stdout io.ReadCloser
+
+	stderrLog *stderrLog
+
+	stdout     atomic.Pointer[bufio.Reader]
+	stdin      *bufio.Writer
+	writeMu    sync.Mutex
+	bufferSize int
+
+	exited  chan struct{}
+	exitErr error
+	ended   chan struct{}
+	endOnce sync.Once
+
+	ffmpegPath string
+	loopArgs   []string
+	loopsLeft  int
+
+	startArgs    []string
+	restartsLeft int
+	restarts     atomic.Int64
+	restarting   chan struct{}
+
+	gracePeriod atomic.Int64
+}
+
+// StreamerOptions contains the options used to create a streaming session.
+type StreamerOptions struct {
+	// Input is the ffmpeg-supported location to decode, such as a network URL or a local filepath.
+	Input string
+	// Reader, if set, is decoded instead of Input. See NewStreamerFromReader.
+	Reader io.Reader
+
+	// Args replaces the default ffmpeg args if it isn't empty. Do not specify your own args unless you understand how
+	// ffgoconv functions.
+	Args []string
+
+	// Seek makes ffmpeg start decoding the input at the given offset, using a fast input seek.
+	Seek time.Duration
+	// Duration limits how much of the input is decoded, or decodes all of it if it is 0.
+	Duration time.Duration
+
+	// Volume must be a floating-point number between 0 and 1, representing a percentage value.
+	Volume float64
+
+	// Precision is the sample format of the raw PCM output by the default args, defaulting to PrecisionFloat64.
+	Precision Precision
+	// SampleRate and Channels are the format of the raw PCM output by the default args, defaulting to 48kHz stereo. They
+	// must be left at their defaults for streamers mixed by a transmuxing session.
+	SampleRate int
+	Channels   int
+
+	// ExtraInputArgs are inserted before the input, so that they apply to it.
+	ExtraInputArgs []string
+	// ExtraOutputArgs are inserted before the output, after every other output arg.
+	ExtraOutputArgs []string
+
+	// FFmpegPath overrides the ffmpeg executable set with SetFFmpegPath.
+	FFmpegPath string
+
+	// BufferSize is the size in bytes of the buffers used to read from ffmpeg's stdout and write to its stdin, defaulting
+	// to 64KB. It must not be less than 20ms of output.
+	BufferSize int
+
+	// Reconnect makes ffmpeg reconnect to the input after transient network errors, including for streamed inputs that
+	// can't be seeked. It is only supported for progressive http and https URLs, and is ignored for any other input such
+	// as files, rtmp or HLS playlists.
+	Reconnect bool
+	// ReconnectDelayMax is the maximum delay between reconnection attempts, rounded up to a whole second. ffmpeg's own
+	// default of 120 seconds is used if it is 0.
+	ReconnectDelayMax time.Duration
+
+	// Headers are sent along with every request for http and https inputs, including HLS playlists and their segments.
+	Headers map[string]string
+	// UserAgent overrides the User-Agent header sent for http and https inputs.
+	UserAgent string
+	// Cookies are sent along with requests for http and https inputs, formatted as newline-delimited Set-Cookie values.
+	Cookies string
+
+	// Loop is the number of times the input is repeated after it has been played once, or -1 to repeat it forever. The
+	// streamer is only closed, and its callback only called, once the final repetition has been played. Position keeps
+	// counting across repetitions.
+	Loop int
+	// LoopByRestart loops the input by restarting ffmpeg once its output ends instead of using -stream_loop, which is
+	// unreliable for some network protocols. Each repetition starts from the beginning of the input, even if Seek is set.
+	LoopByRestart bool
+
+	// AudioFilter is an ffmpeg filter chain applied to the decoded audio, such as "highpass=f=200,volume=0.5". The sample
+	// format, sample rate and channel count of the output are still enforced after the filter chain, so filters changing
+	// them are converted back rather than breaking the framing of the samples. A filter chain ffmpeg rejects ends the
+	// streaming session with its error output attached.
+	AudioFilter string
+
+	// Tempo changes the playback speed of the input without changing its pitch, where 1.0 is the original speed. It must
+	// be between 0.25 and 4.0, with 0 meaning 1.0. See Streamer.RestartWithTempo for changing it during playback.
+	Tempo float64
+
+	// MaxRestarts is how many times ffmpeg is restarted with the same args after failing, or -1 to restart it forever.
+	// The streamer keeps serving samples across restarts, with silence being mixed in while it is restarting if the
+	// transmuxing session runs in real time. Only once it runs out of restarts does it fail with the last error. Inputs
+	// read from an io.Reader are never restarted.
+	MaxRestarts int
+	// RestartBackoff is how long to wait before restarting ffmpeg after it failed.
+	RestartBackoff time.Duration
+	// ExponentialBackoff doubles the backoff after every consecutive restart, up to a minute.
+	ExponentialBackoff bool
+	// OnRestart, if set, is called in a new goroutine with the *StreamerError that ffmpeg failed with every time it
+	// is about to be restarted.
+	OnRestart func(err error)
+
+	// Progress makes ffmpeg report its progress in the machine-readable form of -progress on top of the -stats line,
+	// which is parsed more reliably and keeps being reported with custom args that omit -stats.
+	Progress bool
+	// OnProgress, if set, is called with the latest progress every time ffmpeg reports it. It is called from the
+	// goroutine draining the stderr of ffmpeg, which it must not block for long.
+	OnProgress func(stats TranscodeStats)
+
+	// Logger, if set, is used by the streamer in place of the package-level logger set with SetLogger.
+	Logger Logger
+
+	// SkipValidation skips probing the input when it is added to a transmuxing session validating its inputs, which is
+	// useful for live URLs where probing is expensive. See Transmuxer.SetValidateInputs.
+	SkipValidation bool
 }
 
 // NewStreamer returns an initialized *Streamer or an error if one could not be created.
@@ -32,44 +214,345 @@ type Streamer struct {
 //
 // The variable volume must be a floating-point number between 0 and 1, representing a percentage value. For example, 20% volume would be 0.2.
 func NewStreamer(filepath string, args []string, volume float64) (*Streamer, error) {
-	if filepath == "" {
+	return NewStreamerWithOptions(&StreamerOptions{
+		Input:  filepath,
+		Args:   args,
+		Volume: volume,
+	})
+}
+
+// NewStreamerFromReader returns an initialized *Streamer that decodes the audio read from r, or an error if one could not
+// be created.
+//
+// The data read from r is copied into the stdin of the ffmpeg process, which is closed once r returns io.EOF so that
+// ffmpeg can finish decoding. Any other error returned by r closes the streamer and is reported by Err.
+//
+// If args is nil or empty, the default values will be used with an input of "pipe:0". Custom args must read their input
+// from "pipe:0" as well.
+//
+// The variable volume must be a floating-point number between 0 and 1, representing a percentage value. For example, 20% volume would be 0.2.
+func NewStreamerFromReader(r io.Reader, args []string, volume float64) (*Streamer, error) {
+	if r == nil {
+		return nil, errors.New("ffgoconv: streamer: reader must not be nil")
+	}
+
+	return NewStreamerWithOptions(&StreamerOptions{
+		Reader: r,
+		Args:   args,
+		Volume: volume,
+	})
+}
+
+// NewStreamerWithOptions returns an initialized *Streamer using the given options, or an error if one could not be
+// created. See NewStreamer and NewStreamerFromReader for info on supported options.
+func NewStreamerWithOptions(options *StreamerOptions) (*Streamer, error) {
+	if options == nil {
+		return nil, errors.New("ffgoconv: streamer: options must not be nil")
+	}
+
+	input := options.input()
+	if input == "" {
 		return nil, errors.New("ffgoconv: streamer: filepath must not be empty string")
 	}
+
+	if options.Seek < 0 {
+		return nil, errors.New("ffgoconv: streamer: seek must not be negative")
+	}
+	if options.Duration < 0 {
+		return nil, errors.New("ffgoconv: streamer: duration must not be negative")
+	}
+	if options.SampleRate < 0 || options.Channels < 0 {
+		return nil, errors.New("ffgoconv: streamer: sample rate and channels must not be negative")
+	}
+	if err := options.validateNetworkOptions(); err != nil {
+		return nil, err
+	}
+	if err := options.validateLoopOptions(); err != nil {
+		return nil, err
+	}
+	if err := validateTempo(options.Tempo); err != nil {
+		return nil, err
+	}
+	if options.MaxRestarts < -1 {
+		return nil, errors.New("ffgoconv: streamer: maximum restarts must not be less than -1")
+	}
+	if options.RestartBackoff < 0 {
+		return nil, errors.New("ffgoconv: streamer: restart backoff must not be negative")
+	}
+	if options.BufferSize != 0 && options.BufferSize < streamerBufferSize {
+		return nil, fmt.Errorf("ffgoconv: streamer: buffer size must not be less than %d bytes", streamerBufferSize)
+	}
+	if strings.Contains(options.AudioFilter, ";") {
+		return nil, errors.New("ffgoconv: streamer: audio filter must be a single filter chain")
+	}
+
+	// Repetitions looped by restarting ffmpeg start from the beginning of the input rather than the seek offset.
+	restartArgs := options.streamerArgs(input)
+	args := withSeek(restartArgs, options.Seek)
+
+	ffmpegPath, err := lookFFmpeg(options.FFmpegPath)
+	if err != nil {
+		return nil, err
+	}
+
+	streamer, err := newStreamer(ffmpegPath, input, args, options.Volume)
+	if err != nil {
+		return nil, err
+	}
+	if options.BufferSize > 0 {
+		streamer.bufferSize = options.BufferSize
+	}
+	streamer.offset = options.Seek
+	streamer.tempo = options.tempo()
+	streamer.options = *options
+	streamer.setLogger(options.Logger)
+	if options.Loop != 0 && options.LoopByRestart {
+		streamer.loopArgs = restartArgs
+		streamer.loopsLeft = options.Loop
+	}
+	if options.MaxRestarts != 0 && options.Reader == nil {
+		streamer.startArgs = args
+		streamer.restartsLeft = options.MaxRestarts
+	}
+	if err := streamer.start(args); err != nil {
+		return nil, err
+	}
+
+	if r := options.Reader; r != nil {
+		go streamer.copyInput(r)
+	}
+
+	return streamer, nil
+}
+
+// copyInput copies the data read from r into the stdin of ffmpeg, closing it once r ends. Only errors returned by r fail
+// the streamer, as ffmpeg may stop reading its input early on purpose, such as once Duration has been decoded, while its
+// output has yet to be read.
+func (streamer *Streamer) copyInput(r io.Reader) {
+	buffer := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buffer)
+		if n > 0 {
+			if _, writeErr := streamer.Stdin.Write(buffer[:n]); writeErr != nil {
+				break
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if !streamer.closed.Load() {
+				streamer.setError(err)
+				streamer.Close()
+				return
+			}
+			break
+		}
+	}
+	streamer.Stdin.Close()
+}
+
+// input returns the ffmpeg input that options decode.
+func (options *StreamerOptions) input() string {
+	if options.Reader != nil {
+		return "pipe:0"
+	}
+	return options.Input
+}
+
+// BuildArgs returns the ffmpeg args that a streamer created with options runs with. The default args output raw PCM at
+// the configured precision, sample rate and channel count to pipe:1, with every other option applied on top of them.
+func (options *StreamerOptions) BuildArgs() []string {
+	return withSeek(options.streamerArgs(options.input()), options.Seek)
+}
+
+// streamerArgs returns the ffmpeg args decoding input according to options, apart from the seek offset.
+func (options *StreamerOptions) streamerArgs(input string) []string {
+	// The default args depend on the version of ffmpeg, which custom args are never adapted to.
+	var version ffmpegVersion
+	if len(options.Args) == 0 {
+		version, _ = lookVersion(options.FFmpegPath)
+	}
+	return options.versionArgs(input, version)
+}
+
+// versionArgs is streamerArgs for the given version of ffmpeg, which is left at its zero value if it is unknown.
+func (options *StreamerOptions) versionArgs(input string, version ffmpegVersion) []string {
+	args := options.Args
 	if args == nil || len(args) == 0 {
-		args = []string{
-			"-stats",
-			"-i", filepath,
-			"-map", "0:a",
-			"-acodec", "pcm_f64le",
-			"-f", "f64le",
-			"-vol", "256",
-			"-ar", "48000",
-			"-ac", "2",
-			"-frame_duration", "20",
-			"-threads", "1",
-			"pipe:1",
+		args = adaptVolumeArgs(defaultStreamerArgs(input, options.Precision, options.SampleRate, options.Channels), version)
+	}
+	if len(options.ExtraInputArgs) > 0 {
+		args = injectInputArgs(args, options.ExtraInputArgs...)
+	}
+	if networkArgs := options.networkArgs(input); len(networkArgs) > 0 {
+		args = injectInputArgs(args, networkArgs...)
+	}
+	if loopArgs := options.loopArgs(); len(loopArgs) > 0 {
+		args = injectInputArgs(args, loopArgs...)
+	}
+	if options.Duration > 0 {
+		args = injectInputArgs(args, "-t", formatSeconds(options.Duration))
+	}
+	if options.Progress {
+		args = injectInputArgs(args, "-progress", "pipe:2")
+	}
+	if options.AudioFilter != "" {
+		args = appendAudioFilter(args, options.AudioFilter)
+	}
+	if tempo := options.tempo(); tempo != 1.0 {
+		args = appendAudioFilter(args, atempoFilter(tempo))
+	}
+	if len(options.ExtraOutputArgs) > 0 {
+		args = injectOutputArgs(args, options.ExtraOutputArgs...)
+	}
+	return args
+}
+
+// withSeek returns args with a fast input seek to the given offset injected, if there is one.
+func withSeek(args []string, seek time.Duration) []string {
+	if seek <= 0 {
+		return args
+	}
+	return injectInputArgs(args, "-ss", formatSeconds(seek))
+}
+
+// defaultStreamerArgs returns the default ffmpeg args used to decode input into the transmuxing pipeline at the given
+// precision, sample rate and channel count, where a sample rate or channel count of 0 means 48kHz stereo.
+func defaultStreamerArgs(input string, precision Precision, sampleRate, channels int) []string {
+	if sampleRate == 0 {
+		sampleRate = 48000
+	}
+	if channels == 0 {
+		channels = 2
+	}
+
+	return []string{
+		"-stats",
+		"-i", input,
+		"-map", "0:a",
+		"-acodec", precision.codec(),
+		"-f", precision.format(),
+		"-vol", "256",
+		"-ar", strconv.Itoa(sampleRate),
+		"-ac", strconv.Itoa(channels),
+		"-threads", "1",
+		"pipe:1",
+	}
+}
+
+// injectInputArgs returns a copy of args with inputArgs inserted before the first input, so that they apply to it.
+func injectInputArgs(args []string, inputArgs ...string) []string {
+	injected := make([]string, 0, len(args)+len(inputArgs))
+	for i, arg := range args {
+		if arg == "-i" {
+			injected = append(injected, inputArgs...)
+			return append(injected, args[i:]...)
+		}
+		injected = append(injected, arg)
+	}
+	return append(inputArgs, args...)
+}
+
+// appendAudioFilter returns a copy of args with filter appended to the audio filter chain of the output, adding a chain
+// before the output if there is none.
+func appendAudioFilter(args []string, filter string) []string {
+	for i := 0; i < len(args)-1; i++ {
+		if args[i] == "-af" || args[i] == "-filter:a" {
+			appended := append([]string(nil), args...)
+			appended[i+1] += "," + filter
+			return appended
 		}
 	}
+	return injectOutputArgs(args, "-af", filter)
+}
+
+// injectOutputArgs returns a copy of args with outputArgs inserted right before the output, which is the last arg.
+func injectOutputArgs(args []string, outputArgs ...string) []string {
+	if len(args) == 0 {
+		return append([]string(nil), outputArgs...)
+	}
+
+	output := len(args) - 1
+	injected := make([]string, 0, len(args)+len(outputArgs))
+	injected = append(injected, args[:output]...)
+	injected = append(injected, outputArgs...)
+	return append(injected, args[output])
+}
+
+// formatSeconds formats d as a number of seconds understood by ffmpeg.
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+}
+
+// newStreamer returns a *Streamer decoding input with the given args, which has yet to be started.
+func newStreamer(ffmpegPath, input string, args []string, volume float64) (*Streamer, error) {
 	if volume < 0.0 || volume > 2.0 {
 		return nil, errors.New("ffgoconv: streamer: volume must not be less than 0.0 (0%) or greater than 2.0 (200%)")
 	}
 
-	ffmpeg := exec.Command("ffmpeg", args...)
+	sampleRate, channels, precision := outputFormat(args)
 
-	stderrPipe, err := ffmpeg.StderrPipe()
+	streamer := &Streamer{
+		running:    true,
+		ducking:    1.0,
+		input:      input,
+		sampleRate: sampleRate,
+		channels:   channels,
+		precision:  precision,
+		tempo:      1.0,
+		ffmpegPath: ffmpegPath,
+		bufferSize: defaultPipeBufferSize,
+		ended:      make(chan struct{}),
+		progress:   make(chan TranscodeStats, 1),
+	}
+	streamer.volume.Store(math.Float64bits(volume))
+	streamer.lastData.Store(time.Now().UnixNano())
+	streamer.gracePeriod.Store(defaultGracePeriod.Load())
+	streamer.autoGain.init()
+
+	return streamer, nil
+}
+
+// start starts the ffmpeg process of the streamer with the given args.
+func (streamer *Streamer) start(args []string) error {
+	ffmpeg, stdinPipe, stdoutPipe, stderrPipe, err := startFFmpeg(streamer.ffmpegPath, args)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	streamer.log().Debugf("ffgoconv: streamer: started %s %s", streamer.ffmpegPath, strings.Join(args, " "))
+
+	streamer.attach(ffmpeg, stdinPipe, stdoutPipe, stderrPipe)
+	return nil
+}
+
+// startFFmpeg starts an ffmpeg process with the given args, returning it along with its stdin, stdout and stderr.
+func startFFmpeg(ffmpegPath string, args []string) (*exec.Cmd, io.WriteCloser, *os.File, *os.File, error) {
+	ffmpeg := exec.Command(ffmpegPath, args...)
+	configureProcess(ffmpeg)
+
 	stdinPipe, err := ffmpeg.StdinPipe()
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, nil, err
 	}
-	stdoutPipe, err := ffmpeg.StdoutPipe()
+	// The stdout and stderr pipes are created by hand rather than with ffmpeg.StdoutPipe and ffmpeg.StderrPipe, as Wait
+	// would otherwise close them as soon as ffmpeg exits and discard whatever output has yet to be read.
+	stdoutPipe, stdoutWriter, err := os.Pipe()
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, nil, err
 	}
+	ffmpeg.Stdout = stdoutWriter
+	stderrPipe, stderrWriter, err := os.Pipe()
+	if err != nil {
+		stdoutPipe.Close()
+		stdoutWriter.Close()
+		return nil, nil, nil, nil, err
+	}
+	ffmpeg.Stderr = stderrWriter
 
 	err = ffmpeg.Start()
+	stdoutWriter.Close()
+	stderrWriter.Close()
 	if err != nil {
 		stderrData, _ := ioutil.ReadAll(stderrPipe)
 		stdoutData, _ := ioutil.ReadAll(stdoutPipe)
@@ -79,119 +562,586 @@ func NewStreamer(filepath string, args []string, volume float64) (*Streamer, err
 		stdoutPipe.Close()
 
 		err = fmt.Errorf("ffgoconv: streamer: error starting ffmpeg: %v; %v; %v", err, stderrData, stdoutData)
-		return nil, err
+		return nil, nil, nil, nil, err
 	}
 
-	go func() {
-		if err := ffmpeg.Wait(); err != nil {
+	return ffmpeg, stdinPipe, stdoutPipe, stderrPipe, nil
+}
+
+// attach makes ffmpeg the process decoding for the streamer and watches for it to exit. The lock must be held unless
+// the streamer is still being created.
+func (streamer *Streamer) attach(ffmpeg *exec.Cmd, stdinPipe io.WriteCloser, stdoutPipe, stderrPipe *os.File) {
+	exited := make(chan struct{})
+
+	streamer.Process = ffmpeg
+	streamer.Stderr = stderrPipe
+	streamer.Stdin = stdinPipe
+	streamer.Stdout = stdoutPipe
+	streamer.stderrLog = newStderrLog(stderrPipe, streamer.notifyProgress, streamer.endProgress)
+	streamer.exited = exited
+	streamer.exitErr = nil
+	streamer.stdout.Store(bufio.NewReaderSize(&dataReader{r: stdoutPipe, lastData: &streamer.lastData}, streamer.bufferSize))
+	streamer.writeMu.Lock()
+	streamer.stdin = bufio.NewWriterSize(stdinPipe, streamer.bufferSize)
+	streamer.writeMu.Unlock()
+
+	go func(stderrLog *stderrLog) {
+		err := ffmpeg.Wait()
+
+		streamer.Lock()
+		replaced := streamer.Process != ffmpeg
+		streamer.Unlock()
+
+		// A process that was replaced by a restart was stopped on purpose, so how it exited is of no interest.
+		if replaced {
+			stderrPipe.Close()
+			stdinPipe.Close()
+			stdoutPipe.Close()
+			close(exited)
+			return
+		}
+
+		if err != nil {
+			// Give the stderr log a chance to catch up with the last of the output, which explains why ffmpeg failed.
+			stderrLog.wait(stderrDrainTimeout)
+
 			stderrPipe.Close()
 			stdinPipe.Close()
 			stdoutPipe.Close()
+
+			if backoff, ok := streamer.scheduleRestart(err); ok {
+				close(exited)
+				streamer.restartAfter(err, backoff)
+				return
+			}
+
+			if !streamer.closed.Load() {
+				streamer.setError(streamer.exitError(err))
+			}
+		}
+
+		streamer.Lock()
+		streamer.exitErr = err
+		// Once ffmpeg has finished cleanly with repetitions left to loop, it is restarted as its output is read to the
+		// end, so the streaming session only ends if it isn't.
+		restarting := err == nil && streamer.canLoop()
+		streamer.Unlock()
+
+		close(exited)
+		if !restarting {
+			streamer.end()
 		}
-	}()
+	}(streamer.stderrLog)
+}
 
-	return &Streamer{
-		Process: ffmpeg,
-		running: true,
-		Stderr:  stderrPipe,
-		Stdin:   stdinPipe,
-		Stdout:  stdoutPipe,
-		Volume:  volume,
-	}, nil
+// end marks the streaming session as ended, once ffmpeg has exited for good.
+func (streamer *Streamer) end() {
+	streamer.endOnce.Do(func() {
+		close(streamer.ended)
+		streamer.closeProgress()
+	})
 }
 
 // Read implements an io.Reader wrapper around *Streamer.Stdout.
 func (streamer *Streamer) Read(data []byte) (n int, err error) {
-	if streamer.closed {
-		return 0, errors.New("ffgoconv: streamer: closed")
+	return streamer.read(data, true)
+}
+
+// read reads the output of ffmpeg into data, carrying on with the output of a new ffmpeg process whenever it is
+// restarted. Unless waitRestart is set, errRestarting is returned while ffmpeg is waiting to be restarted after failing.
+func (streamer *Streamer) read(data []byte, waitRestart bool) (n int, err error) {
+	if streamer.closed.Load() {
+		return 0, ErrStreamerClosed
 	}
 
-	n, err = streamer.Stdout.Read(data)
-	return n, err
+	for {
+		stdout := streamer.stdout.Load()
+		n, err = stdout.Read(data)
+		if err == nil || n > 0 {
+			return n, err
+		}
+		// ffmpeg was restarted while reading, so the rest of its output is read from the new process instead.
+		if streamer.stdout.Load() != stdout {
+			continue
+		}
+		if err != io.EOF && !errors.Is(err, os.ErrClosed) {
+			return n, err
+		}
+
+		restarted, restartErr := streamer.awaitRestart(stdout, waitRestart)
+		if restartErr != nil {
+			return 0, restartErr
+		}
+		if restarted {
+			continue
+		}
+		if err != io.EOF || !streamer.loop() {
+			return n, err
+		}
+	}
 }
 
-// ReadSample returns the next audio sample from the streaming session.
-func (streamer *Streamer) ReadSample() (float64, error) {
-	if streamer.closed {
-		return 0, errors.New("ffgoconv: streamer: closed")
+// readMix reads up to len(dst) samples to be mixed into dst, through the stall reader once a stall policy has been set.
+// It returns how many samples were read before the streamer failed, in which case it is closed. In real time, silence is
+// mixed while ffmpeg is waiting to be restarted after failing.
+func (streamer *Streamer) readMix(dst []float64, realtime bool) int {
+	stall := streamer.stall.Load()
+
+	n := 0
+	for n < len(dst) && !streamer.closed.Load() {
+		var err error
+		if stall != nil {
+			dst[n], err = stall.next()
+			if err == nil {
+				n++
+			}
+		} else {
+			startWaiting(&streamer.waiting)
+			var read int
+			read, err = streamer.readSamples(dst[n:], !realtime)
+			if read > 0 {
+				streamer.waiting.Store(0)
+			}
+			n += read
+			streamer.consumed.Add(int64(read))
+		}
+
+		if errors.Is(err, errRestarting) {
+			for i := range dst[n:] {
+				dst[n+i] = 0
+			}
+			return len(dst)
+		}
+
+		if err != nil {
+			streamer.setError(err)
+			streamer.closeAsync()
+			break
+		}
 	}
+	return n
+}
 
-	sample := make([]byte, 8) // sizeof(float64) == 8
+// mixBlock fills the streamer's mix buffer with the next size samples to be mixed, with its gain, volume and processors
+// applied, and returns how many of them were read. Once the streamer fails, it is closed and the rest of the buffer is
+// filled with silence.
+func (streamer *Streamer) mixBlock(size int, meterWindow int64, realtime bool) int {
+	if cap(streamer.mixBuffer) < size {
+		streamer.mixBuffer = make([]float64, size)
+	}
+	streamer.mixBuffer = streamer.mixBuffer[:size]
 
-	n, err := streamer.Read(sample)
-	if err != nil {
-		return 0, err
+	read := streamer.readMix(streamer.mixBuffer, realtime)
+	volume := streamer.Volume()
+	for i := range streamer.mixBuffer {
+		if i >= read {
+			streamer.mixBuffer[i] = 0
+			continue
+		}
+
+		sample, err := streamer.sanitize(streamer.mixBuffer[i])
+		if err != nil {
+			streamer.setError(err)
+			streamer.closeAsync()
+			streamer.mixBuffer[i] = 0
+			read = i
+			continue
+		}
+
+		streamer.mixBuffer[i] = streamer.autoGain.apply(sample) * volume
+	}
+
+	streamer.Lock()
+	processors := streamer.processors
+	streamer.Unlock()
+
+	if len(processors) > 0 {
+		copy(streamer.mixBuffer, process(processors, streamer.mixBuffer))
+	}
+
+	for _, sample := range streamer.mixBuffer {
+		streamer.meter.add(sample, meterWindow)
+	}
+	return read
+}
+
+// prebuffer blocks until the streamer has decoded enough audio to fill its read buffer, or until its output ends.
+func (streamer *Streamer) prebuffer() error {
+	// The output of a streamer read ahead by its stall reader is no longer touched outside of it.
+	if stall := streamer.stall.Load(); stall != nil {
+		return stall.prebuffer()
+	}
+
+	data, err := streamer.stdout.Load().Peek(streamerBufferSize)
+	if err != nil && (err != io.EOF || len(data) == 0) {
+		return fmt.Errorf("ffgoconv: streamer: error buffering ffmpeg output: %v", err)
+	}
+	return nil
+}
+
+// ReadSample returns the next audio sample from the streaming session, converting it from float32 if the streamer
+// outputs pcm_f32le.
+func (streamer *Streamer) ReadSample() (float64, error) {
+	if streamer.closed.Load() {
+		return 0, ErrStreamerClosed
+	}
+
+	if streamer.precision == PrecisionFloat32 {
+		sample, err := streamer.ReadSampleFloat32()
+		return float64(sample), err
 	}
-	if n != 8 {
-		return 0, errors.New("streamer: read: size of sample must be 8")
+
+	var sample [8]byte // sizeof(float64) == 8
+
+	// Pipes don't guarantee that whole samples are returned by a single read, so partial reads are completed. The output
+	// only ends with io.ErrUnexpectedEOF if it genuinely ends partway through a sample.
+	if _, err := io.ReadFull(streamer, sample[:]); err != nil {
+		return 0, streamer.readErr(err)
 	}
 
-	u64 := binary.LittleEndian.Uint64(sample)
+	u64 := binary.LittleEndian.Uint64(sample[:])
 	fSample := math.Float64frombits(u64)
 
 	return fSample, nil
 }
 
-// Write implements an io.Writer wrapper around *Streamer.Stdin.
-func (streamer *Streamer) Write(data []byte) error {
-	if streamer.closed {
-		return errors.New("ffgoconv: streamer: closed")
+// ReadSamples reads as many complete audio samples into dst as are available, blocking until at least one is, and
+// returns how many were read. Samples are converted from float32 if the streamer outputs pcm_f32le. Reading samples in
+// batches is considerably cheaper than reading them one at a time with ReadSample.
+func (streamer *Streamer) ReadSamples(dst []float64) (int, error) {
+	return streamer.readSamples(dst, true)
+}
+
+// readSamples implements ReadSamples, returning errRestarting while ffmpeg is waiting to be restarted after failing
+// unless waitRestart is set.
+func (streamer *Streamer) readSamples(dst []float64, waitRestart bool) (int, error) {
+	if streamer.closed.Load() {
+		return 0, ErrStreamerClosed
+	}
+	if len(dst) == 0 {
+		return 0, nil
+	}
+
+	size := streamer.precision.size()
+	if cap(streamer.readBuffer) < len(dst)*size {
+		streamer.readBuffer = make([]byte, len(dst)*size)
 	}
+	data := streamer.readBuffer[:len(dst)*size]
+
+	n, err := streamer.read(data, waitRestart)
+	if n == 0 {
+		if errors.Is(err, errRestarting) {
+			return 0, err
+		}
+		return 0, streamer.readErr(err)
+	}
+	// Complete the last sample if it was only partially read, so that the samples stay aligned.
+	if partial := n % size; partial != 0 {
+		if _, err := io.ReadFull(streamer, data[n:n+size-partial]); err != nil {
+			return n / size, streamer.readErr(err)
+		}
+		n += size - partial
+	}
+
+	samples := n / size
+	for i := 0; i < samples; i++ {
+		if size == 4 {
+			dst[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(data[i*4:])))
+		} else {
+			dst[i] = math.Float64frombits(binary.LittleEndian.Uint64(data[i*8:]))
+		}
+	}
+	return samples, nil
+}
+
+// ReadSampleFloat32 returns the next audio sample from a streaming session outputting pcm_f32le.
+func (streamer *Streamer) ReadSampleFloat32() (float32, error) {
+	if streamer.closed.Load() {
+		return 0, ErrStreamerClosed
+	}
+
+	var sample [4]byte // sizeof(float32) == 4
+
+	// Pipes don't guarantee that whole samples are returned by a single read, so partial reads are completed. The output
+	// only ends with io.ErrUnexpectedEOF if it genuinely ends partway through a sample.
+	if _, err := io.ReadFull(streamer, sample[:]); err != nil {
+		return 0, streamer.readErr(err)
+	}
+
+	u32 := binary.LittleEndian.Uint32(sample[:])
+	fSample := math.Float32frombits(u32)
+
+	return fSample, nil
+}
+
+// Write implements io.Writer around *Streamer.Stdin, writing all of p unless an error occurs. Writes are buffered, see
+// Flush.
+func (streamer *Streamer) Write(p []byte) (int, error) {
+	if streamer.closed.Load() {
+		return 0, ErrStreamerClosed
+	}
+
+	if streamer.stdin == nil {
+		return 0, errors.New("ffgoconv: streamer: generated streamers can't be written to")
+	}
+
+	streamer.writeMu.Lock()
+	n, err := streamer.stdin.Write(p)
+	streamer.writeMu.Unlock()
 
-	_, err := streamer.Stdin.Write(data)
 	if err != nil {
-		return err
+		return n, streamer.withOutput(err)
+	}
+	return n, nil
+}
+
+// Flush writes any buffered data to ffmpeg's stdin. A transmuxing session flushes its final stream once per block, and
+// Close flushes the streamer before closing it.
+func (streamer *Streamer) Flush() error {
+	if streamer.stdin == nil {
+		return nil
+	}
+
+	streamer.writeMu.Lock()
+	err := streamer.stdin.Flush()
+	streamer.writeMu.Unlock()
+
+	if err != nil {
+		return streamer.withOutput(err)
 	}
 	return nil
 }
 
-// WriteSample writes a new audio sample to the streaming session.
+// WriteSample writes a new audio sample to the streaming session, converting it to float32 if the streamer expects
+// pcm_f32le.
 func (streamer *Streamer) WriteSample(sample float64) error {
-	if streamer.closed {
-		return errors.New("ffgoconv: streamer: closed")
+	if streamer.closed.Load() {
+		return ErrStreamerClosed
+	}
+
+	if streamer.precision == PrecisionFloat32 {
+		return streamer.WriteSampleFloat32(float32(sample))
 	}
 
 	var bs [8]byte
 	u64 := math.Float64bits(sample)
 	binary.LittleEndian.PutUint64(bs[:], u64)
 
-	if _, err := streamer.Stdin.Write(bs[:]); err != nil {
+	if _, err := streamer.Write(bs[:]); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-// Err returns the latest streaming error.
+// WriteSampleFloat32 writes a new audio sample to a streaming session expecting pcm_f32le.
+func (streamer *Streamer) WriteSampleFloat32(sample float32) error {
+	if streamer.closed.Load() {
+		return ErrStreamerClosed
+	}
+
+	var bs [4]byte
+	u32 := math.Float32bits(sample)
+	binary.LittleEndian.PutUint32(bs[:], u32)
+
+	if _, err := streamer.Write(bs[:]); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Err returns the error that ended the streaming session, if any.
 func (streamer *Streamer) Err() error {
+	streamer.Lock()
+	defer streamer.Unlock()
+
 	return streamer.Error
 }
 
 // SetVolume sets the volume of the finalized audio.
 func (streamer *Streamer) SetVolume(volume float64) error {
-	if streamer.closed {
-		return errors.New("ffgoconv: streamer: closed")
+	if streamer.closed.Load() {
+		return ErrStreamerClosed
 	}
 	if volume < 0.0 || volume > 2.0 {
 		return errors.New("ffgoconv: volume: volume must not be less than 0.0 (0%) or greater than 2.0 (200%)")
 	}
-	streamer.Volume = volume
+	streamer.volume.Store(math.Float64bits(volume))
 	return nil
 }
 
-// Close closes the streaming session and renders the streamer unusable.
-func (streamer *Streamer) Close() {
-	if streamer.closed {
-		return
+// Volume returns the volume of the finalized audio.
+func (streamer *Streamer) Volume() float64 {
+	return math.Float64frombits(streamer.volume.Load())
+}
+
+// SetCallback sets a function to be called in a new goroutine once the streamer is closed, receiving the error that
+// ended the streaming session, if any.
+func (streamer *Streamer) SetCallback(callback func(err error)) error {
+	if streamer.closed.Load() {
+		return ErrStreamerClosed
 	}
-	streamer.Process.Process.Kill()
-	streamer.Stderr.Close()
-	streamer.Stdin.Close()
-	streamer.Stdout.Close()
-	streamer.closed = true
+
+	streamer.Lock()
+	streamer.callback = callback
+	streamer.Unlock()
+	return nil
+}
+
+// Levels returns the peak and RMS levels of the streamer over the last metering window of the transmuxing session it
+// is mixed into, after its volume has been applied.
+//
+// Levels are linear, where 1.0 is full scale, and drop to zero once the streamer is closed.
+func (streamer *Streamer) Levels() (peak, rms float64) {
+	return streamer.meter.levels()
+}
+
+// Close closes the streaming session and renders the streamer unusable. Any errors killing ffmpeg or closing its pipes
+// are joined together, returned and recorded as the error that ended the streaming session. Closing an already closed
+// streamer does nothing and returns nil.
+//
+// Close blocks for up to the grace period while ffmpeg is given a chance to exit on its own.
+func (streamer *Streamer) Close() error {
+	if !streamer.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	return streamer.shutdown()
+}
+
+// closeAsync closes the streamer without waiting for ffmpeg to exit, for closing it from the mix loop or while holding a
+// lock. The streamer is marked as closed straight away, so that it is removed from the mix, while ffmpeg is shut down
+// in the background.
+func (streamer *Streamer) closeAsync() {
+	if streamer.closed.CompareAndSwap(false, true) {
+		go streamer.shutdown()
+	}
+}
+
+// shutdown stops ffmpeg and releases everything held by the streamer once it has been marked as closed.
+func (streamer *Streamer) shutdown() error {
+	// The streamer no longer takes up a slot in the transmuxing session once it has been marked as closed, so that a
+	// queued streamer can take its place without waiting for ffmpeg to exit.
+	streamer.Lock()
+	released := streamer.released
+	streamer.Unlock()
+	if released != nil {
+		go released()
+	}
+
+	grace := time.Duration(streamer.gracePeriod.Load())
+	if grace > 0 && streamer.stdin != nil {
+		// Whatever is left in the write buffer is handed over to ffmpeg while it is given a chance to exit on its own.
+		// ffmpeg may no longer be reading its stdin, so the flush is given up on once the grace period is up.
+		flushed := make(chan struct{})
+		go func() {
+			streamer.Flush()
+			close(flushed)
+		}()
+
+		select {
+		case <-flushed:
+		case <-time.After(grace):
+		}
+	}
+
+	// The ffmpeg process is no longer restarted once the streamer has been closed, so it can be safely stopped.
+	streamer.Lock()
+	ffmpeg, stdin, exited := streamer.Process, streamer.Stdin, streamer.exited
+	var pipes []io.Closer
+	if ffmpeg != nil {
+		pipes = []io.Closer{streamer.Stderr, streamer.Stdin, streamer.Stdout}
+	}
+	streamer.Unlock()
+
+	var errs []error
+	if ffmpeg == nil {
+		streamer.end()
+	} else if err := stop(ffmpeg, stdin, exited, grace); err != nil {
+		errs = append(errs, err)
+	} else {
+		<-exited
+		streamer.end()
+	}
+	for _, pipe := range pipes {
+		// The pipes may have already been closed by ffmpeg exiting on its own.
+		if err := pipe.Close(); err != nil && !errors.Is(err, os.ErrClosed) {
+			errs = append(errs, fmt.Errorf("ffgoconv: streamer: error closing pipe: %w", err))
+		}
+	}
+	if streamer.source != nil {
+		if err := streamer.source.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("ffgoconv: streamer: error closing sample source: %w", err))
+		}
+	}
+	closeErr := errors.Join(errs...)
+	if closeErr != nil {
+		streamer.setError(closeErr)
+	}
+
+	streamer.meter.reset()
 	streamer.running = false
+
+	streamer.Lock()
+	stall := streamer.stall.Load()
+	callback := streamer.callback
+	err := streamer.Error
+	streamer.Unlock()
+
+	if stall != nil {
+		close(stall.done)
+	}
+	if callback != nil {
+		go callback(err)
+	}
+
+	return closeErr
+}
+
+// Wait blocks until ffmpeg has exited and returns the error it exited with, which is an *exec.ExitError if it exited
+// with a non-zero status. When looping by restarting ffmpeg, Wait only returns once the final repetition has exited.
+func (streamer *Streamer) Wait() error {
+	<-streamer.ended
+
+	streamer.Lock()
+	defer streamer.Unlock()
+
+	return streamer.exitErr
 }
 
+// ExitCode returns the exit code of ffmpeg and true if it has exited, or false if it is still running. The exit code is
+// -1 if ffmpeg was terminated by a signal.
+func (streamer *Streamer) ExitCode() (int, bool) {
+	select {
+	case <-streamer.ended:
+		streamer.Lock()
+		defer streamer.Unlock()
+
+		if streamer.Process == nil {
+			return 0, true
+		}
+		return streamer.Process.ProcessState.ExitCode(), true
+	default:
+		return 0, false
+	}
+}
+
+// setError records err as the error that ended the streaming session and logs it, unless one was already recorded.
 func (streamer *Streamer) setError(err error) {
-	streamer.Error = err
+	streamer.Lock()
+	first := streamer.Error == nil
+	if first {
+		streamer.Error = err
+	}
+	streamer.Unlock()
+
+	if !first {
+		return
+	}
+	// A clean end isn't a failure, so it is only logged at debug level.
+	if errors.Is(err, ErrStreamEnded) {
+		streamer.log().Debugf("%v", err)
+		return
+	}
+	streamer.log().Errorf("%v", err)
 }
@@ -0,0 +1,625 @@
+package ffgoconv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// chunkReader returns at most size bytes from r with every read, like a pipe that is written to in small pieces.
+type chunkReader struct {
+	r    io.Reader
+	size int
+}
+
+func (reader *chunkReader) Read(p []byte) (int, error) {
+	if len(p) > reader.size {
+		p = p[:reader.size]
+	}
+	return reader.r.Read(p)
+}
+
+// encodePCM returns samples as little-endian PCM of the given precision.
+func encodePCM(samples []float64, precision Precision) []byte {
+	data := make([]byte, len(samples)*precision.size())
+	for i, sample := range samples {
+		if precision == PrecisionFloat32 {
+			binary.LittleEndian.PutUint32(data[i*4:], math.Float32bits(float32(sample)))
+		} else {
+			binary.LittleEndian.PutUint64(data[i*8:], math.Float64bits(sample))
+		}
+	}
+	return data
+}
+
+// newChunkedStreamer returns a streamer decoding data of the given precision, read 3 bytes at a time so that no read
+// ever returns a whole sample.
+func newChunkedStreamer(data []byte, precision Precision) *Streamer {
+	streamer := newPCMStreamer(&chunkReader{r: bytes.NewReader(data), size: 3})
+	streamer.precision = precision
+	return streamer
+}
+
+func TestReadSampleShortReads(t *testing.T) {
+	samples := []float64{0, 0.5, -0.5, 1, -1, 0.125, -0.0078125, 0.333251953125}
+
+	for _, precision := range []Precision{PrecisionFloat64, PrecisionFloat32} {
+		t.Run(precision.format(), func(t *testing.T) {
+			streamer := newChunkedStreamer(encodePCM(samples, precision), precision)
+			defer streamer.Close()
+
+			for i, want := range samples {
+				sample, err := streamer.ReadSample()
+				if err != nil {
+					t.Fatalf("sample %d: %v", i, err)
+				}
+				if sample != want {
+					t.Fatalf("sample %d is %v, want %v", i, sample, want)
+				}
+			}
+
+			if _, err := streamer.ReadSample(); !errors.Is(err, ErrStreamEnded) {
+				t.Errorf("reading past the end returned %v, want %v", err, ErrStreamEnded)
+			}
+		})
+	}
+}
+
+func TestReadSampleTruncated(t *testing.T) {
+	data := encodePCM([]float64{0.5, 0.25}, PrecisionFloat64)
+	streamer := newChunkedStreamer(data[:13], PrecisionFloat64)
+	defer streamer.Close()
+
+	if sample, err := streamer.ReadSample(); err != nil || sample != 0.5 {
+		t.Fatalf("first sample is %v, %v, want 0.5", sample, err)
+	}
+	if _, err := streamer.ReadSample(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("reading the partial sample returned %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestReadSamplesShortReads(t *testing.T) {
+	samples := make([]float64, 1000)
+	for i := range samples {
+		samples[i] = float64(i) / 1000
+	}
+
+	for _, precision := range []Precision{PrecisionFloat64, PrecisionFloat32} {
+		t.Run(precision.format(), func(t *testing.T) {
+			streamer := newChunkedStreamer(encodePCM(samples, precision), precision)
+			defer streamer.Close()
+
+			var read []float64
+			dst := make([]float64, 7)
+			for {
+				n, err := streamer.ReadSamples(dst)
+				read = append(read, dst[:n]...)
+				if err != nil {
+					if !errors.Is(err, ErrStreamEnded) {
+						t.Fatal(err)
+					}
+					break
+				}
+			}
+
+			if len(read) != len(samples) {
+				t.Fatalf("read %d samples, want %d", len(read), len(samples))
+			}
+			for i, sample := range read {
+				want := samples[i]
+				if precision == PrecisionFloat32 {
+					want = float64(float32(want))
+				}
+				if sample != want {
+					t.Fatalf("sample %d is %v, want %v", i, sample, want)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildArgs(t *testing.T) {
+	ffmpeg4 := ffmpegVersion{major: 4, minor: 4, raw: "4.4.2"}
+	ffmpeg6 := ffmpegVersion{major: 6, minor: 1, raw: "6.1.1"}
+	output := []string{"-map", "0:a", "-acodec", "pcm_f64le", "-f", "f64le", "-vol", "256", "-ar", "48000", "-ac", "2", "-threads", "1"}
+
+	tests := []struct {
+		name    string
+		options StreamerOptions
+		version ffmpegVersion
+		want    []string
+	}{
+		{
+			name:    "defaults",
+			options: StreamerOptions{Input: "song.mp3"},
+			version: ffmpeg4,
+			want:    concatArgs([]string{"-stats", "-i", "song.mp3"}, output, []string{"pipe:1"}),
+		},
+		{
+			name:    "defaults with an unknown version",
+			options: StreamerOptions{Input: "song.mp3"},
+			want:    concatArgs([]string{"-stats", "-i", "song.mp3"}, output, []string{"pipe:1"}),
+		},
+		{
+			name:    "defaults on ffmpeg 5 and later",
+			options: StreamerOptions{Input: "song.mp3"},
+			version: ffmpeg6,
+			want: []string{
+				"-stats", "-i", "song.mp3", "-map", "0:a", "-acodec", "pcm_f64le", "-f", "f64le", "-ar", "48000", "-ac", "2",
+				"-threads", "1", "-af", "volume=1.0", "pipe:1",
+			},
+		},
+		{
+			name:    "reader",
+			options: StreamerOptions{Reader: bytes.NewReader(nil)},
+			version: ffmpeg4,
+			want:    concatArgs([]string{"-stats", "-i", "pipe:0"}, output, []string{"pipe:1"}),
+		},
+		{
+			name:    "seek and duration",
+			options: StreamerOptions{Input: "song.mp3", Seek: 90500 * time.Millisecond, Duration: 10 * time.Second},
+			version: ffmpeg4,
+			want:    concatArgs([]string{"-stats", "-t", "10", "-ss", "90.5", "-i", "song.mp3"}, output, []string{"pipe:1"}),
+		},
+		{
+			name:    "output format",
+			options: StreamerOptions{Input: "song.mp3", Precision: PrecisionFloat32, SampleRate: 44100, Channels: 1},
+			version: ffmpeg4,
+			want: []string{
+				"-stats", "-i", "song.mp3", "-map", "0:a", "-acodec", "pcm_f32le", "-f", "f32le", "-vol", "256", "-ar", "44100",
+				"-ac", "1", "-threads", "1", "pipe:1",
+			},
+		},
+		{
+			name: "http input",
+			options: StreamerOptions{
+				Input:             "https://example.com/stream.mp3",
+				Reconnect:         true,
+				ReconnectDelayMax: 2500 * time.Millisecond,
+				Headers:           map[string]string{"X-Token": "secret", "Accept": "*/*"},
+				UserAgent:         "ffgoconv-test",
+				Cookies:           "session=1; path=/",
+			},
+			version: ffmpeg4,
+			want: concatArgs([]string{
+				"-stats", "-reconnect", "1", "-reconnect_streamed", "1", "-reconnect_delay_max", "3",
+				"-headers", "Accept: */*\r\nX-Token: secret\r\n", "-user_agent", "ffgoconv-test", "-cookies", "session=1; path=/",
+				"-i", "https://example.com/stream.mp3",
+			}, output, []string{"pipe:1"}),
+		},
+		{
+			name:    "hls input",
+			options: StreamerOptions{Input: "https://example.com/live.m3u8", Reconnect: true, UserAgent: "ffgoconv-test"},
+			version: ffmpeg4,
+			want: concatArgs([]string{"-stats", "-user_agent", "ffgoconv-test", "-i", "https://example.com/live.m3u8"}, output,
+				[]string{"pipe:1"}),
+		},
+		{
+			name:    "network options ignored for files",
+			options: StreamerOptions{Input: "song.mp3", Reconnect: true, UserAgent: "ffgoconv-test"},
+			version: ffmpeg4,
+			want:    concatArgs([]string{"-stats", "-i", "song.mp3"}, output, []string{"pipe:1"}),
+		},
+		{
+			name:    "loop",
+			options: StreamerOptions{Input: "song.mp3", Loop: -1},
+			version: ffmpeg4,
+			want:    concatArgs([]string{"-stats", "-stream_loop", "-1", "-i", "song.mp3"}, output, []string{"pipe:1"}),
+		},
+		{
+			name:    "loop by restart",
+			options: StreamerOptions{Input: "song.mp3", Loop: 3, LoopByRestart: true},
+			version: ffmpeg4,
+			want:    concatArgs([]string{"-stats", "-i", "song.mp3"}, output, []string{"pipe:1"}),
+		},
+		{
+			name:    "audio filter and tempo",
+			options: StreamerOptions{Input: "song.mp3", AudioFilter: "highpass=f=200", Tempo: 3},
+			version: ffmpeg4,
+			want: concatArgs([]string{"-stats", "-i", "song.mp3"}, output,
+				[]string{"-af", "highpass=f=200,atempo=2.0,atempo=1.5", "pipe:1"}),
+		},
+		{
+			name:    "audio filter and tempo on ffmpeg 5 and later",
+			options: StreamerOptions{Input: "song.mp3", AudioFilter: "highpass=f=200", Tempo: 0.2},
+			version: ffmpeg6,
+			want: []string{
+				"-stats", "-i", "song.mp3", "-map", "0:a", "-acodec", "pcm_f64le", "-f", "f64le", "-ar", "48000", "-ac", "2",
+				"-threads", "1", "-af", "volume=1.0,highpass=f=200,atempo=0.5,atempo=0.5,atempo=0.8", "pipe:1",
+			},
+		},
+		{
+			name: "extra args and progress",
+			options: StreamerOptions{
+				Input:           "song.mp3",
+				Seek:            time.Second,
+				Progress:        true,
+				ExtraInputArgs:  []string{"-analyzeduration", "0"},
+				ExtraOutputArgs: []string{"-fflags", "+flush_packets"},
+			},
+			version: ffmpeg4,
+			want: concatArgs([]string{"-stats", "-analyzeduration", "0", "-progress", "pipe:2", "-ss", "1", "-i", "song.mp3"},
+				output, []string{"-fflags", "+flush_packets", "pipe:1"}),
+		},
+		{
+			name: "custom args",
+			options: StreamerOptions{
+				Input:           "song.mp3",
+				Args:            []string{"-i", "song.mp3", "-f", "f64le", "-vol", "256", "pipe:1"},
+				Seek:            2 * time.Second,
+				AudioFilter:     "volume=0.5",
+				ExtraOutputArgs: []string{"-ac", "2"},
+			},
+			version: ffmpeg6,
+			want:    []string{"-ss", "2", "-i", "song.mp3", "-f", "f64le", "-vol", "256", "-af", "volume=0.5", "-ac", "2", "pipe:1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := test.options
+			args := withSeek(options.versionArgs(options.input(), test.version), options.Seek)
+
+			if !reflect.DeepEqual(args, test.want) {
+				t.Fatalf("got args\n%q\nwant\n%q", args, test.want)
+			}
+
+			// Building the args must never modify the options, so that they can be built over and over.
+			if again := withSeek(options.versionArgs(options.input(), test.version), options.Seek); !reflect.DeepEqual(again, test.want) {
+				t.Errorf("building the args again returned\n%q", again)
+			}
+		})
+	}
+}
+
+// concatArgs returns the args of every part one after another.
+func concatArgs(parts ...[]string) []string {
+	var args []string
+	for _, part := range parts {
+		args = append(args, part...)
+	}
+	return args
+}
+
+// repeatReader endlessly repeats data, standing in for an ffmpeg process that never ends.
+type repeatReader struct {
+	data   []byte
+	offset int
+}
+
+func (reader *repeatReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		copied := copy(p[n:], reader.data[reader.offset:])
+		n += copied
+		reader.offset = (reader.offset + copied) % len(reader.data)
+	}
+	return n, nil
+}
+
+// newRepeatStreamer returns a streamer decoding a tone of the given precision forever.
+func newRepeatStreamer(precision Precision) *Streamer {
+	streamer := newPCMStreamer(&repeatReader{data: encodePCM(tone(440, 0.5, time.Second), precision)})
+	streamer.precision = precision
+	return streamer
+}
+
+func BenchmarkReadSample(b *testing.B) {
+	for _, precision := range []Precision{PrecisionFloat64, PrecisionFloat32} {
+		b.Run(precision.format(), func(b *testing.B) {
+			streamer := newRepeatStreamer(precision)
+			defer streamer.Close()
+
+			b.SetBytes(int64(mixBlockSize * precision.size()))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for j := 0; j < mixBlockSize; j++ {
+					if _, err := streamer.ReadSample(); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkReadSamples(b *testing.B) {
+	for _, precision := range []Precision{PrecisionFloat64, PrecisionFloat32} {
+		b.Run(precision.format(), func(b *testing.B) {
+			streamer := newRepeatStreamer(precision)
+			defer streamer.Close()
+
+			block := make([]float64, mixBlockSize)
+			b.SetBytes(int64(mixBlockSize * precision.size()))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for read := 0; read < mixBlockSize; {
+					n, err := streamer.ReadSamples(block[read:])
+					if err != nil {
+						b.Fatal(err)
+					}
+					read += n
+				}
+			}
+		})
+	}
+}
+
+// recordLogger records the messages logged to it at each level.
+type recordLogger struct {
+	sync.Mutex
+	debug []string
+	info  []string
+	error []string
+}
+
+func (logger *recordLogger) Debugf(format string, args ...any) {
+	logger.Lock()
+	logger.debug = append(logger.debug, fmt.Sprintf(format, args...))
+	logger.Unlock()
+}
+
+func (logger *recordLogger) Infof(format string, args ...any) {
+	logger.Lock()
+	logger.info = append(logger.info, fmt.Sprintf(format, args...))
+	logger.Unlock()
+}
+
+func (logger *recordLogger) Errorf(format string, args ...any) {
+	logger.Lock()
+	logger.error = append(logger.error, fmt.Sprintf(format, args...))
+	logger.Unlock()
+}
+
+func TestStreamEndLogging(t *testing.T) {
+	tests := []struct {
+		name   string
+		reader io.Reader
+		err    error
+		failed bool
+	}{
+		{"clean end", bytes.NewReader(encodePCM(tone(440, 0.5, 50*time.Millisecond), PrecisionFloat64)), ErrStreamEnded, false},
+		{"failure", io.MultiReader(bytes.NewReader(encodePCM(tone(440, 0.5, 50*time.Millisecond), PrecisionFloat64)),
+			iotest.ErrReader(errors.New("broken pipe"))), nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logger := &recordLogger{}
+			streamer := newPCMStreamer(test.reader)
+			if err := streamer.SetLogger(logger); err != nil {
+				t.Fatal(err)
+			}
+			mixAll(t, 100*time.Millisecond, streamer)
+
+			if streamer.Error == nil || (test.err != nil && !errors.Is(streamer.Error, test.err)) {
+				t.Fatalf("streamer error is %v, want %v", streamer.Error, test.err)
+			}
+
+			logger.Lock()
+			defer logger.Unlock()
+			if failed := len(logger.error) > 0; failed != test.failed {
+				t.Errorf("logged errors %q, want errors logged to be %v", logger.error, test.failed)
+			}
+			if !test.failed && len(logger.debug) == 0 {
+				t.Error("the end of the stream wasn't logged at debug level")
+			}
+		})
+	}
+}
+
+// stubbornDecoder is a script standing in for ffmpeg that outputs NaN samples forever and ignores SIGTERM, so that it
+// only exits once the grace period is up and it is killed.
+const stubbornDecoder = `#!/bin/sh
+trap '' TERM
+while :; do printf '\000\000\000\000\000\000\370\177'; done
+`
+
+func TestMixLoopCloseDoesNotWaitForFFmpeg(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake ffmpeg is a shell script")
+	}
+
+	path := filepath.Join(t.TempDir(), "ffmpeg")
+	if err := os.WriteFile(path, []byte(stubbornDecoder), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	corrupt, err := NewStreamerWithOptions(&StreamerOptions{
+		Input:      "song.mp3",
+		Args:       []string{"-i", "song.mp3", "-f", "f64le", "-ar", "48000", "-ac", "2", "pipe:1"},
+		Volume:     1.0,
+		FFmpegPath: path,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer corrupt.Close()
+	if err := corrupt.SetMaxCorruptSamples(100); err != nil {
+		t.Fatal(err)
+	}
+	if err := corrupt.SetGracePeriod(1500 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	tone, err := NewToneStreamer(440, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The corrupt streamer is closed by the mix loop, which must carry on mixing while ffmpeg is given its grace period.
+	start := time.Now()
+	samples := mixAll(t, 500*time.Millisecond, corrupt, tone)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("mixing took %v, the mix loop waited for ffmpeg to exit", elapsed)
+	}
+	if len(samples) != int(durationSamples(500*time.Millisecond)) {
+		t.Errorf("mixed %d samples, want %d", len(samples), durationSamples(500*time.Millisecond))
+	}
+	if !corrupt.closed.Load() || corrupt.Error == nil || !strings.Contains(corrupt.Error.Error(), "corrupt samples") {
+		t.Errorf("the corrupt streamer ended with %v", corrupt.Error)
+	}
+	corrupt.Wait()
+}
+
+func TestAutoGain(t *testing.T) {
+	target := dbToLinear(-18)
+	tests := []struct {
+		name      string
+		amplitude float64
+		want      float64 // RMS level of the output
+	}{
+		{"quiet", 0.05, target},
+		{"loud", 0.8, target},
+		{"boost capped", 0.005, 0.005 / math.Sqrt2 * dbToLinear(12)},
+		{"silent", 0, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			streamer, err := NewToneStreamer(1000, test.amplitude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := streamer.SetAutoGain(true); err != nil {
+				t.Fatal(err)
+			}
+			samples := mixAll(t, 5*time.Second, streamer)
+
+			// By the end, the gain has long settled on the loudness of the whole tone.
+			var sumSquares float64
+			last := samples[len(samples)-int(durationSamples(time.Second)):]
+			for _, sample := range last {
+				sumSquares += sample * sample
+			}
+			rms := math.Sqrt(sumSquares / float64(len(last)))
+			if math.Abs(rms-test.want) > test.want*0.02+1e-9 {
+				t.Errorf("the output settled at %.4f RMS, want %.4f", rms, test.want)
+			}
+
+			wantGain := 1.0
+			if test.amplitude > 0 {
+				wantGain = test.want / (test.amplitude / math.Sqrt2)
+			}
+			if gain := streamer.AppliedGain(); math.Abs(gain-wantGain) > wantGain*0.02 {
+				t.Errorf("applied a gain of %.4f, want %.4f", gain, wantGain)
+			}
+		})
+	}
+}
+
+// countingSource is a SampleSource producing the position of every sample within its output, plus one, in millionths,
+// which only starts producing them after a delay.
+type countingSource struct {
+	delay time.Duration
+	count int
+}
+
+// ReadSamples implements SampleSource.
+func (source *countingSource) ReadSamples(dst []float64) (int, error) {
+	if source.delay > 0 {
+		time.Sleep(source.delay)
+		source.delay = 0
+	}
+	for i := range dst {
+		source.count++
+		dst[i] = float64(source.count) / 1e6
+	}
+	return len(dst), nil
+}
+
+// Close implements SampleSource.
+func (source *countingSource) Close() error {
+	return nil
+}
+
+func TestStallSilence(t *testing.T) {
+	steady, err := NewSourceStreamer(&trackSource{value: 0.25, remaining: -1}, 1.0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stalled, err := NewSourceStreamer(newStalledSource(), 1.0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stalled.SetStallPolicy(StallSilence, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// The mix carries on without the stalled streamer instead of freezing.
+	for i, sample := range mixAll(t, 200*time.Millisecond, steady, stalled) {
+		if sample != 0.25 {
+			t.Fatalf("sample %d is %v, want only the steady streamer", i, sample)
+		}
+	}
+	if errors.Is(stalled.Err(), ErrStreamStalled) {
+		t.Error("the stalled streamer was dropped")
+	}
+}
+
+func TestStallSilenceSkipsBacklog(t *testing.T) {
+	late, err := NewSourceStreamer(&countingSource{delay: 100 * time.Millisecond}, 1.0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := late.SetStallPolicy(StallSilence, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	samples := mixAllWith(t, 300*time.Millisecond, func(transmuxer *Transmuxer) error {
+		return transmuxer.SetRealtime(true)
+	}, late)
+
+	// Once the streamer recovers, as much of its output as it fell behind by is skipped, so that it plays in line with
+	// the time that has passed rather than from where it stalled.
+	silence := 0
+	for silence < len(samples) && samples[silence] == 0 {
+		silence++
+	}
+	if silence == 0 || silence == len(samples) {
+		t.Fatalf("mixed %d samples of silence, want the streamer to stall and then recover", silence)
+	}
+	if skipped := int(math.Round(samples[silence]*1e6)) - 1; skipped < silence-2*mixBlockSize || skipped > silence {
+		t.Errorf("skipped %d samples after %d samples of silence", skipped, silence)
+	}
+}
+
+func TestStallDrop(t *testing.T) {
+	steady, err := NewSourceStreamer(&trackSource{value: 0.25, remaining: -1}, 1.0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stalled, err := NewSourceStreamer(newStalledSource(), 1.0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stalled.SetStallPolicy(StallDrop, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	samples := mixAllWith(t, 300*time.Millisecond, func(transmuxer *Transmuxer) error {
+		return transmuxer.SetRealtime(true)
+	}, steady, stalled)
+	for i, sample := range samples {
+		if sample != 0.25 {
+			t.Fatalf("sample %d is %v, want only the steady streamer", i, sample)
+		}
+	}
+
+	if !stalled.closed.Load() {
+		t.Fatal("the stalled streamer wasn't dropped")
+	}
+	if err := stalled.Err(); !errors.Is(err, ErrStreamStalled) {
+		t.Errorf("the stalled streamer failed with %v, want %v", err, ErrStreamStalled)
+	}
+}
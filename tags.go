@@ -0,0 +1,81 @@
+package ffgoconv
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// taggedFormats lists the output formats that carry metadata tags.
+var taggedFormats = map[string]bool{
+	"flac":     true,
+	"ipod":     true,
+	"matroska": true,
+	"mov":      true,
+	"mp3":      true,
+	"mp4":      true,
+	"ogg":      true,
+	"opus":     true,
+	"wav":      true,
+	"webm":     true,
+}
+
+// coverFormats lists the output formats that can embed cover art as an attached picture.
+var coverFormats = map[string]bool{
+	"flac": true,
+	"ipod": true,
+	"mov":  true,
+	"mp3":  true,
+	"mp4":  true,
+}
+
+// validateTags returns an error if the metadata tags of the options can't be passed to ffmpeg.
+func (options *TransmuxerOptions) validateTags() error {
+	for key := range options.Metadata {
+		if key == "" || strings.ContainsAny(key, "=\r\n") {
+			return errors.New("ffgoconv: transmuxer: metadata keys must not be empty or contain '=' or line breaks")
+		}
+	}
+	return nil
+}
+
+// tagArgs returns the ffmpeg args writing the metadata tags and cover art of the options, split into the args of the
+// cover art input and the output args. Tags and cover art are ignored for formats that don't support them, such as
+// raw streams.
+func (options *TransmuxerOptions) tagArgs() (inputArgs, outputArgs []string) {
+	if len(options.Metadata) > 0 {
+		if taggedFormats[options.Format] {
+			keys := make([]string, 0, len(options.Metadata))
+			for key := range options.Metadata {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+
+			// Every tag is passed as a single arg, so that values don't need any quoting.
+			for _, key := range keys {
+				outputArgs = append(outputArgs, "-metadata", key+"="+options.Metadata[key])
+			}
+			if options.Format == "mp3" {
+				outputArgs = append(outputArgs, "-id3v2_version", "3")
+			}
+		} else {
+			packageLog().Debugf("ffgoconv: transmuxer: ignoring metadata tags, which format %s doesn't support", options.Format)
+		}
+	}
+
+	if options.CoverPath != "" {
+		if coverFormats[options.Format] {
+			inputArgs = []string{"-i", options.CoverPath}
+			outputArgs = append(outputArgs,
+				"-map", "0:a",
+				"-map", "1:v",
+				"-c:v", "copy",
+				"-disposition:v", "attached_pic",
+			)
+		} else {
+			packageLog().Debugf("ffgoconv: transmuxer: ignoring cover art, which format %s doesn't support", options.Format)
+		}
+	}
+
+	return inputArgs, outputArgs
+}
@@ -0,0 +1,75 @@
+package ffgoconv
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+const (
+	minTempo = 0.25
+	maxTempo = 4.0
+)
+
+// validateTempo returns an error if tempo isn't supported, where 0 means the original speed.
+func validateTempo(tempo float64) error {
+	if tempo != 0 && (tempo < minTempo || tempo > maxTempo) {
+		return errors.New("ffgoconv: streamer: tempo must not be less than 0.25 or greater than 4.0")
+	}
+	return nil
+}
+
+// tempo returns the tempo the input is played at, defaulting to the original speed.
+func (options *StreamerOptions) tempo() float64 {
+	if options.Tempo == 0 {
+		return 1.0
+	}
+	return options.Tempo
+}
+
+// atempoFilter returns an ffmpeg audio filter changing the tempo of audio, chaining atempo filters as each one is only
+// guaranteed to support a tempo between 0.5 and 2.0.
+func atempoFilter(tempo float64) string {
+	var stages []string
+	for tempo > 2.0 {
+		stages = append(stages, "atempo=2.0")
+		tempo /= 2.0
+	}
+	for tempo < 0.5 {
+		stages = append(stages, "atempo=0.5")
+		tempo /= 0.5
+	}
+	stages = append(stages, "atempo="+strconv.FormatFloat(tempo, 'f', -1, 64))
+	return strings.Join(stages, ",")
+}
+
+// Tempo returns the tempo the streamer plays its input at, where 1.0 is the original speed.
+func (streamer *Streamer) Tempo() float64 {
+	streamer.Lock()
+	defer streamer.Unlock()
+
+	return streamer.tempo
+}
+
+// RestartWithTempo restarts ffmpeg at the current position with a new tempo, for changing the tempo during playback.
+// The new output replaces the old one without the streamer being closed, although whatever audio was already decoded
+// at the old tempo is dropped. Inputs read from an io.Reader can't be restarted.
+func (streamer *Streamer) RestartWithTempo(tempo float64) error {
+	if streamer.closed.Load() {
+		return ErrStreamerClosed
+	}
+	if err := validateTempo(tempo); err != nil {
+		return err
+	}
+
+	streamer.Lock()
+	options := streamer.options
+	streamer.Unlock()
+
+	if options.Reader != nil || options.Input == "" {
+		return errors.New("ffgoconv: streamer: inputs read from an io.Reader can't be restarted")
+	}
+	options.Tempo = tempo
+
+	return streamer.restart(&options, streamer.Position())
+}
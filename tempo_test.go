@@ -0,0 +1,62 @@
+package ffgoconv
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeDecoder is a script standing in for ffmpeg, which reports no version and otherwise runs without any output until
+// it is stopped.
+const fakeDecoder = `#!/bin/sh
+case "$*" in
+*-version*) exit 0 ;;
+esac
+exec sleep 60
+`
+
+func TestRestartWithTempoRestartArgs(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake ffmpeg is a shell script")
+	}
+
+	path := filepath.Join(t.TempDir(), "ffmpeg")
+	if err := os.WriteFile(path, []byte(fakeDecoder), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	streamer, err := NewStreamerWithOptions(&StreamerOptions{
+		Input:         "song.mp3",
+		Volume:        1.0,
+		FFmpegPath:    path,
+		MaxRestarts:   3,
+		LoopByRestart: true,
+		Loop:          2,
+		Seek:          10 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer streamer.Close()
+
+	if err := streamer.RestartWithTempo(2.0); err != nil {
+		t.Fatal(err)
+	}
+
+	streamer.Lock()
+	startArgs := strings.Join(streamer.startArgs, " ")
+	loopArgs := strings.Join(streamer.loopArgs, " ")
+	streamer.Unlock()
+
+	// ffmpeg restarted after a failure resumes at the new tempo from where the tempo was changed, while repetitions
+	// start over from the beginning at the new tempo.
+	if !strings.Contains(startArgs, "-ss ") || !strings.Contains(startArgs, "atempo=2") {
+		t.Errorf("ffmpeg is restarted after failing with %q, want a seek and the new tempo", startArgs)
+	}
+	if strings.Contains(loopArgs, "-ss ") || !strings.Contains(loopArgs, "atempo=2") {
+		t.Errorf("ffmpeg is restarted to loop with %q, want the new tempo without a seek", loopArgs)
+	}
+}
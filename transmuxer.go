@@ -3,109 +3,607 @@ package ffgoconv
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"math"
+	"regexp"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+const (
+	defaultMeterWindow = 100 * time.Millisecond
+
+	// mixBlockSize is the number of interleaved samples mixed at once, 10ms at 48kHz stereo.
+	mixBlockSize = 960
+
+	// stopTimeout is how long Stop waits for Run to return, which it only does between blocks.
+	stopTimeout = 5 * time.Second
+)
+
+// ErrStopTimeout is returned by Stop when Run hasn't returned in time, as it is blocked on a stalled streamer or on a
+// final stream whose output isn't being read. Run still returns once it gets unblocked, without mixing another block.
+var ErrStopTimeout = errors.New("ffgoconv: transmuxer: timed out waiting for Run to return")
+
 // Transmuxer contains all the data required to run a transmuxing session.
 type Transmuxer struct {
 	sync.Mutex
 
-	Streamers   []*Streamer
+	streamers   []*Streamer
 	FinalStream *Streamer
 	running     bool
 	closed      bool
+	stopping    atomic.Bool
+	stopped     chan struct{}
 	Error       error
 
-	buffer []float64
+	buffer        []float64
+	bufferReady   *sync.Cond
+	bufferDrained *sync.Cond
+	maxBuffered   int64
+
+	masterVolume atomic.Uint64 // Float64bits of the master volume, as it is set by API callers while being read by the mix loop
+
+	meter       meter
+	meterWindow atomic.Int64
+
+	retired   []*Streamer
+	broadcast *broadcaster
+	duckers   []*ducker
+
+	maxStreamers int
+	starting     int
+	queue        []*QueuedStreamer
+	queueID      uint64
+
+	realtime atomic.Bool
+	pacer    pacer
 
-	MasterVolume float64
+	synchronized atomic.Bool
 
-	Stderr io.ReadCloser
+	processors []SampleProcessor
+
+	codec      string
+	format     string
+	precision  Precision
+	ffmpegPath string
+	dither     atomic.Bool
+	ditherer   *ditherer
+	sixteenBit bool // Whether or not the final stream encodes 16-bit samples
+
+	recorder  atomic.Pointer[recorder]
+	segmenter atomic.Pointer[segmenter]
+
+	mixed atomic.Int64
+	limit atomic.Int64
+	done  chan struct{}
+
+	watchdog        atomic.Int64
+	watchdogRunning atomic.Bool
+	runStarted      atomic.Int64
+	validate        atomic.Bool
+	logger          atomic.Pointer[Logger]
+
+	Stderr io.ReadCloser // Deprecated: The final stream's stderr is drained internally, see EncodeStats and EncodeOutput.
 	Stdin  io.WriteCloser
 	Stdout io.ReadCloser
+
+	stderrLog *stderrLog
+	sdp       string
+}
+
+// TransmuxerOptions contains the options used to create a transmuxing session.
+type TransmuxerOptions struct {
+	OutputFilepath string
+	Codec          string
+	Format         string
+	Bitrate        string
+	MasterVolume   float64
+
+	// FFmpegPath overrides the ffmpeg executable set with SetFFmpegPath, for the final stream as well as for any
+	// streamer or recording started by the session.
+	FFmpegPath string
+
+	// Precision is the sample format of the raw PCM piped between ffmpeg and the transmuxing session. It applies to the
+	// final stream as well as to the default args of streamers added to the session.
+	Precision Precision
+
+	// ExtraOutputArgs are passed to the final stream's ffmpeg right before the output, for codec-specific options such
+	// as "-application", "lowdelay" for libopus.
+	ExtraOutputArgs []string
+
+	// Metadata are the tags written to the output, such as "title" or "artist", for formats that carry tags such as
+	// mp3, mp4, ogg and flac. They are ignored for other formats.
+	Metadata map[string]string
+	// CoverPath is the location of an image embedded into the output as its cover art, for formats that can embed it
+	// such as mp3, mp4 and flac. It is ignored for other formats.
+	CoverPath string
+
+	// RTPPayloadType and RTPSSRC set the payload type and synchronization source of the RTP stream when Format is
+	// "rtp", with OutputFilepath being the rtp:// address to stream to. They are chosen by ffmpeg if left at 0. The
+	// session description of the stream is available from SDP.
+	RTPPayloadType int
+	RTPSSRC        uint32
+
+	// Strict makes Validate check that the local ffmpeg build can encode Codec and write Format, so that unsupported
+	// combinations are rejected before ffmpeg is ever started.
+	Strict bool
+}
+
+// losslessCodecs lists the lossless codecs besides PCM, which encode at whatever bitrate the audio takes and so take no
+// bitrate option.
+var losslessCodecs = map[string]bool{
+	"alac":    true,
+	"flac":    true,
+	"tta":     true,
+	"wavpack": true,
+}
+
+// codecHasBitrate returns whether or not codec encodes at a configurable bitrate.
+func codecHasBitrate(codec string) bool {
+	return !strings.HasPrefix(codec, "pcm_") && !losslessCodecs[codec]
+}
+
+// bitratePattern matches the bitrates accepted by ffmpeg, such as "320k" or "1M".
+var bitratePattern = regexp.MustCompile(`^[0-9]+[kKM]?$`)
+
+// Validate returns an error if the options can't be used to create a transmuxing session.
+func (options *TransmuxerOptions) Validate() error {
+	if options.MasterVolume < 0.0 || options.MasterVolume > 2.0 {
+		return errors.New("ffgoconv: volume: volume must not be less than 0.0 (0%) or greater than 2.0 (200%)")
+	}
+
+	if options.Precision != PrecisionFloat64 && options.Precision != PrecisionFloat32 {
+		return errors.New("ffgoconv: transmuxer: unknown precision")
+	}
+
+	// Without an output, the finalized audio is buffered as raw PCM and never encoded.
+	if options.OutputFilepath == "" {
+		return nil
+	}
+
+	if options.Codec == "" {
+		return errors.New("ffgoconv: transmuxer: codec must not be empty string")
+	}
+	if options.Format == "" {
+		return errors.New("ffgoconv: transmuxer: format must not be empty string")
+	}
+	if (codecHasBitrate(options.Codec) || options.Bitrate != "") && !bitratePattern.MatchString(options.Bitrate) {
+		return fmt.Errorf("ffgoconv: transmuxer: bitrate %q must be a number of bits per second with an optional k, K or M suffix, such as \"320k\"", options.Bitrate)
+	}
+
+	if err := options.validateTags(); err != nil {
+		return err
+	}
+	if err := options.validateRTP(); err != nil {
+		return err
+	}
+
+	if options.Strict {
+		if err := checkEncoding(options.FFmpegPath, options.Codec, options.Format); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // NewTransmuxer returns an initialized *Transmuxer or an error if one could not be created.
 //
-// If streamers is nil, it will be initialized automatically with an empty slice of *Streamer.
+// If streamers is nil, it will be initialized automatically with an empty slice of *Streamer. Otherwise, it is copied into the transmuxing session.
 //
-// If codec is not specified, the ffmpeg process will not start. A list of possible codecs can be found with "ffmpeg -codecs".
+// If codec is not specified, an error is returned. A list of possible codecs can be found with "ffmpeg -codecs".
 //
-// If format is not specified, the ffmpeg process will not start. A list of possible formats can be found with "ffmpeg -formats".
+// If format is not specified, an error is returned. A list of possible formats can be found with "ffmpeg -formats".
 //
-// If bitrate is not specified, the ffmpeg process will not start.
+// If bitrate is not specified as a number of bits per second with an optional k, K or M suffix, such as "320k", an error is returned.
+// PCM and other lossless codecs take no bitrate, in which case it may be left empty.
 //
-// The variable masterVolume must be a floating-point number between 0 and 1, representing a percentage value. For example, 20% volume would be 0.2.
+// The variable masterVolume must be a floating-point number between 0 and 2, representing a percentage value. For example, 20% volume would be 0.2.
 //
 // If outputFilepath is empty, a buffer of float64 PCM values will be initialized and the returned *Transmuxer can be then used as an io.Reader.
 //
 // If outputFilepath is "pipe:1", the FinalStream *Streamer can be used as an io.Reader to receive encoded audio data of the chosen codec in the chosen format.
 func NewTransmuxer(streamers []*Streamer, outputFilepath, codec, format, bitrate string, masterVolume float64) (*Transmuxer, error) {
-	if streamers == nil {
-		streamers = make([]*Streamer, 0)
+	return NewTransmuxerWithOptions(streamers, &TransmuxerOptions{
+		OutputFilepath: outputFilepath,
+		Codec:          codec,
+		Format:         format,
+		Bitrate:        bitrate,
+		MasterVolume:   masterVolume,
+	})
+}
+
+// NewTransmuxerWithOptions returns an initialized *Transmuxer using the given options, or an error if one could not be
+// created. See NewTransmuxer for info on supported options.
+func NewTransmuxerWithOptions(streamers []*Streamer, options *TransmuxerOptions) (*Transmuxer, error) {
+	if options == nil {
+		return nil, errors.New("ffgoconv: transmuxer: options must not be nil")
+	}
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+
+	for _, streamer := range streamers {
+		if err := checkMixFormat(streamer); err != nil {
+			return nil, err
+		}
 	}
+	streamers = append(make([]*Streamer, 0, len(streamers)), streamers...)
 
 	args := []string{
 		"-stats",
-		"-acodec", "pcm_f64le",
-		"-f", "f64le",
+		"-acodec", options.Precision.codec(),
+		"-f", options.Precision.format(),
 		"-ar", "48000",
 		"-ac", "2",
 		"-i", "-",
-		"-acodec", codec,
-		"-f", format,
+	}
+	coverArgs, tagArgs := options.tagArgs()
+	args = append(args, coverArgs...)
+	args = append(args,
+		"-acodec", options.Codec,
+		"-f", options.Format,
 		"-vol", "256",
 		"-ar", "48000",
 		"-ac", "2",
-		"-b:a", bitrate,
-		"-threads", "1",
-		outputFilepath,
+	)
+	if codecHasBitrate(options.Codec) {
+		args = append(args, "-b:a", options.Bitrate)
 	}
+	args = append(args, tagArgs...)
+	args = append(args, options.rtpArgs()...)
+	args = append(args, "-threads", "1")
+	args = append(args, options.ExtraOutputArgs...)
+	args = append(args, options.OutputFilepath)
+	args = withVolumeArgs(args, options.FFmpegPath)
 
 	var finalStream *Streamer
 	var err error
 
-	if outputFilepath != "" {
-		finalStream, err = NewStreamer(outputFilepath, args, 1.0)
+	if options.OutputFilepath != "" {
+		finalStream, err = NewStreamerWithOptions(&StreamerOptions{
+			Input:      options.OutputFilepath,
+			Args:       args,
+			Volume:     1.0,
+			FFmpegPath: options.FFmpegPath,
+		})
 		if err != nil {
 			return nil, err
 		}
+		finalStream.precision = options.Precision
 
-		return &Transmuxer{
-			Streamers:    streamers,
-			FinalStream:  finalStream,
-			Stderr:       finalStream.Stderr,
-			Stdin:        finalStream.Stdin,
-			Stdout:       finalStream.Stdout,
-			MasterVolume: masterVolume,
-		}, nil
+		transmuxer := &Transmuxer{
+			streamers:   streamers,
+			FinalStream: finalStream,
+			Stderr:      finalStream.Stderr,
+			Stdin:       finalStream.Stdin,
+			Stdout:      finalStream.Stdout,
+			codec:       options.Codec,
+			format:      options.Format,
+			precision:   options.Precision,
+			ffmpegPath:  options.FFmpegPath,
+			ditherer:    newDitherer(),
+			sixteenBit:  isSixteenBitOutput(options.Codec, options.ExtraOutputArgs),
+			done:        make(chan struct{}),
+			stderrLog:   finalStream.stderrLog,
+		}
+		transmuxer.masterVolume.Store(math.Float64bits(options.MasterVolume))
+		transmuxer.meterWindow.Store(meterWindowSamples(defaultMeterWindow))
+		if options.Format == "rtp" {
+			go transmuxer.captureSDP()
+		}
+		return transmuxer, nil
 	}
 
-	return &Transmuxer{
-		Streamers:    streamers,
-		MasterVolume: masterVolume,
-		buffer:       make([]float64, 0),
-	}, nil
+	transmuxer := &Transmuxer{
+		streamers:  streamers,
+		precision:  options.Precision,
+		ffmpegPath: options.FFmpegPath,
+		buffer:     make([]float64, 0),
+		done:       make(chan struct{}),
+	}
+	transmuxer.bufferReady = sync.NewCond(&transmuxer.Mutex)
+	transmuxer.bufferDrained = sync.NewCond(&transmuxer.Mutex)
+	transmuxer.masterVolume.Store(math.Float64bits(options.MasterVolume))
+	transmuxer.meterWindow.Store(meterWindowSamples(defaultMeterWindow))
+	return transmuxer, nil
 }
 
 // AddStreamer initializes and adds a *Streamer to the transmuxing session, or returns an error if one could not be initialized.
 // See NewStreamer for info on supported arguments.
 func (transmuxer *Transmuxer) AddStreamer(filepath string, args []string, volume float64) (*Streamer, error) {
+	return transmuxer.AddStreamerWithOptions(&StreamerOptions{
+		Input:  filepath,
+		Args:   args,
+		Volume: volume,
+	})
+}
+
+// AddStreamerReader initializes and adds a *Streamer that decodes the audio read from r to the transmuxing session, or
+// returns an error if one could not be initialized.
+// See NewStreamerFromReader for info on supported arguments.
+func (transmuxer *Transmuxer) AddStreamerReader(r io.Reader, args []string, volume float64) (*Streamer, error) {
+	if r == nil {
+		return nil, errors.New("ffgoconv: streamer: reader must not be nil")
+	}
+
+	return transmuxer.AddStreamerWithOptions(&StreamerOptions{
+		Reader: r,
+		Args:   args,
+		Volume: volume,
+	})
+}
+
+// AddStreamerWithOptions initializes and adds a *Streamer using the given options to the transmuxing session, or returns
+// an error if one could not be initialized. See NewStreamerWithOptions for info on supported options.
+//
+// The precision and ffmpeg executable of the transmuxing session are used unless the options override them.
+func (transmuxer *Transmuxer) AddStreamerWithOptions(options *StreamerOptions) (*Streamer, error) {
+	if transmuxer.closed {
+		return nil, errors.New("ffgoconv: transmuxer: closed")
+	}
+
+	if !transmuxer.reserveSlot() {
+		return nil, ErrTooManyStreamers
+	}
+
+	streamer, err := transmuxer.newStreamer(options)
+	if err != nil {
+		transmuxer.releaseSlot()
+		return nil, err
+	}
+
+	transmuxer.addStreamer(streamer)
+	return streamer, nil
+}
+
+// newStreamer validates the input of options if enabled, and initializes a *Streamer with the precision and ffmpeg
+// executable of the transmuxing session filled in.
+func (transmuxer *Transmuxer) newStreamer(options *StreamerOptions) (*Streamer, error) {
+	if options != nil && (options.SampleRate != 0 && options.SampleRate != 48000 || options.Channels != 0 && options.Channels != 2) {
+		return nil, errors.New("ffgoconv: transmuxer: streamers must output 48kHz stereo audio")
+	}
+	if err := transmuxer.validateInput(options); err != nil {
+		return nil, err
+	}
+
+	streamer, err := NewStreamerWithOptions(transmuxer.streamerOptions(options))
+	if err != nil {
+		return nil, err
+	}
+	// Custom args set the output format themselves, which the options above don't cover.
+	if err := checkMixFormat(streamer); err != nil {
+		streamer.Close()
+		return nil, err
+	}
+	return streamer, nil
+}
+
+// checkMixFormat returns an error unless streamer outputs the 48kHz stereo audio that transmuxing sessions mix.
+func checkMixFormat(streamer *Streamer) error {
+	if streamer != nil && (streamer.sampleRate != 48000 || streamer.channels != 2) {
+		return errors.New("ffgoconv: transmuxer: streamers must output 48kHz stereo audio")
+	}
+	return nil
+}
+
+// streamerOptions returns a copy of options with the precision and ffmpeg executable of the transmuxing session filled in.
+func (transmuxer *Transmuxer) streamerOptions(options *StreamerOptions) *StreamerOptions {
+	if options == nil {
+		return nil
+	}
+
+	copied := *options
+	if copied.Precision == PrecisionFloat64 {
+		copied.Precision = transmuxer.precision
+	}
+	if copied.FFmpegPath == "" {
+		copied.FFmpegPath = transmuxer.ffmpegPath
+	}
+	if copied.Logger == nil {
+		if logger := transmuxer.logger.Load(); logger != nil {
+			copied.Logger = *logger
+		}
+	}
+	return &copied
+}
+
+// ReplaceStreamer initializes a *Streamer and swaps it into the transmuxing session in place of old, or returns an error
+// if one could not be initialized. See NewStreamer for info on supported arguments.
+//
+// The new streamer inherits the volume of old and is pre-buffered before the swap, which happens on a frame boundary so
+// that the mix continues without a gap. The old streamer is closed once it has been swapped out.
+func (transmuxer *Transmuxer) ReplaceStreamer(old *Streamer, filepath string, args []string) (*Streamer, error) {
 	if transmuxer.closed {
 		return nil, errors.New("ffgoconv: transmuxer: closed")
 	}
 
-	streamer, err := NewStreamer(filepath, args, volume)
+	if !transmuxer.hasStreamer(old) {
+		return nil, errors.New("ffgoconv: transmuxer: streamer not found")
+	}
+
+	streamer, err := transmuxer.newStreamer(&StreamerOptions{
+		Input:  filepath,
+		Args:   args,
+		Volume: old.Volume(),
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	transmuxer.Streamers = append(transmuxer.Streamers, streamer)
+	if err := streamer.prebuffer(); err != nil {
+		streamer.Close()
+		return nil, err
+	}
+
+	transmuxer.Lock()
+	if !transmuxer.swapStreamerLocked(old, streamer) {
+		transmuxer.Unlock()
+		streamer.Close()
+		return nil, errors.New("ffgoconv: transmuxer: streamer not found")
+	}
+
+	running := transmuxer.running
+	if running {
+		transmuxer.retired = append(transmuxer.retired, old)
+	}
+	transmuxer.Unlock()
+
+	if !running {
+		old.Close()
+	}
+
 	return streamer, nil
 }
 
+// swapStreamerLocked puts streamer in the place of old in the transmuxing session, including any ducking it is part
+// of, returning false if old isn't part of the session. The caller must hold the lock.
+func (transmuxer *Transmuxer) swapStreamerLocked(old, streamer *Streamer) bool {
+	index := -1
+	for i, existing := range transmuxer.streamers {
+		if existing == old {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return false
+	}
+
+	streamer.setReleased(transmuxer.startQueued)
+
+	streamers := make([]*Streamer, len(transmuxer.streamers))
+	copy(streamers, transmuxer.streamers)
+	streamers[index] = streamer
+	transmuxer.streamers = streamers
+
+	// The targets are copied rather than updated in place, as the mix loop may be reading them without the lock.
+	for _, ducker := range transmuxer.duckers {
+		if ducker.trigger == old {
+			ducker.trigger = streamer
+		}
+		for i, target := range ducker.targets {
+			if target == old {
+				targets := append([]*Streamer(nil), ducker.targets...)
+				targets[i] = streamer
+				ducker.targets = targets
+			}
+		}
+	}
+	return true
+}
+
+// handOff fills the rest of the mix buffer of a streamer whose output ended after read samples with the start of its
+// successor, if it has one, and puts the successor in its place so that the mix continues without a gap.
+func (transmuxer *Transmuxer) handOff(streamer *Streamer, read int, meterWindow int64, realtime bool) {
+	successor := streamer.successor.Swap(nil)
+	if successor == nil {
+		return
+	}
+
+	successor.mixBlock(len(streamer.mixBuffer)-read, meterWindow, realtime)
+	copy(streamer.mixBuffer[read:], successor.mixBuffer)
+
+	transmuxer.Lock()
+	swapped := transmuxer.swapStreamerLocked(streamer, successor)
+	transmuxer.Unlock()
+
+	if !swapped {
+		successor.closeAsync()
+	}
+}
+
+// GetStreamers returns a snapshot of the streamers in the transmuxing session.
+func (transmuxer *Transmuxer) GetStreamers() []*Streamer {
+	transmuxer.Lock()
+	defer transmuxer.Unlock()
+
+	streamers := make([]*Streamer, len(transmuxer.streamers))
+	copy(streamers, transmuxer.streamers)
+	return streamers
+}
+
+// Len returns the number of streamers in the transmuxing session that have not been closed.
+func (transmuxer *Transmuxer) Len() int {
+	transmuxer.Lock()
+	defer transmuxer.Unlock()
+
+	count := 0
+	for _, streamer := range transmuxer.streamers {
+		if !streamer.closed.Load() {
+			count++
+		}
+	}
+	return count
+}
+
+// addStreamer adds streamer to the transmuxing session in the slot reserved for it, to be mixed starting from the next
+// frame.
+func (transmuxer *Transmuxer) addStreamer(streamer *Streamer) {
+	streamer.setReleased(transmuxer.startQueued)
+
+	transmuxer.Lock()
+	defer transmuxer.Unlock()
+
+	transmuxer.streamers = append(transmuxer.streamers, streamer)
+	transmuxer.starting--
+}
+
+// hasStreamer returns whether or not streamer is part of the transmuxing session.
+func (transmuxer *Transmuxer) hasStreamer(streamer *Streamer) bool {
+	transmuxer.Lock()
+	defer transmuxer.Unlock()
+
+	for _, existing := range transmuxer.streamers {
+		if existing == streamer {
+			return true
+		}
+	}
+	return false
+}
+
+// nextStreamers returns the streamers to mix for the next frame, removing any streamer that has been closed and closing
+// any streamer that was swapped out since the last frame.
+func (transmuxer *Transmuxer) nextStreamers() []*Streamer {
+	pruned := false
+
+	transmuxer.Lock()
+	for i, streamer := range transmuxer.streamers {
+		if !streamer.closed.Load() {
+			continue
+		}
+
+		streamers := make([]*Streamer, 0, len(transmuxer.streamers))
+		streamers = append(streamers, transmuxer.streamers[:i]...)
+		for _, streamer := range transmuxer.streamers[i+1:] {
+			if !streamer.closed.Load() {
+				streamers = append(streamers, streamer)
+			}
+		}
+		transmuxer.streamers = streamers
+		pruned = true
+		break
+	}
+	streamers := transmuxer.streamers
+	retired := transmuxer.retired
+	transmuxer.retired = nil
+	queued := len(transmuxer.queue) > 0
+	transmuxer.Unlock()
+
+	for _, streamer := range retired {
+		streamer.closeAsync()
+	}
+
+	if pruned && queued {
+		go transmuxer.startQueued()
+	}
+
+	return streamers
+}
+
 // SetMasterVolume sets the master volume of the finalized audio.
 func (transmuxer *Transmuxer) SetMasterVolume(volume float64) error {
 	if transmuxer.closed {
@@ -116,80 +614,389 @@ func (transmuxer *Transmuxer) SetMasterVolume(volume float64) error {
 		return errors.New("ffgoconv: volume: volume must not be less than 0.0 (0%) or greater than 2.0 (200%)")
 	}
 
-	transmuxer.MasterVolume = volume
+	transmuxer.masterVolume.Store(math.Float64bits(volume))
+	return nil
+}
+
+// MasterVolume returns the master volume of the finalized audio.
+func (transmuxer *Transmuxer) MasterVolume() float64 {
+	return math.Float64frombits(transmuxer.masterVolume.Load())
+}
+
+// SetMeterWindow sets the window over which the peak and RMS levels of every streamer and the master mix are measured.
+// The default window is 100 milliseconds.
+func (transmuxer *Transmuxer) SetMeterWindow(window time.Duration) error {
+	if transmuxer.closed {
+		return errors.New("ffgoconv: transmuxer: closed")
+	}
+
+	if window <= 0 {
+		return errors.New("ffgoconv: meter: window must be greater than 0")
+	}
+
+	transmuxer.meterWindow.Store(meterWindowSamples(window))
+	return nil
+}
+
+// MasterLevels returns the peak and RMS levels of the finalized audio over the last metering window, after the master
+// volume has been applied.
+//
+// Levels are linear, where 1.0 is full scale.
+func (transmuxer *Transmuxer) MasterLevels() (peak, rms float64) {
+	return transmuxer.meter.levels()
+}
+
+// SetStartSynchronized sets whether or not Run waits for every streamer in the transmuxing session to have buffered a
+// full block of audio before mixing, so that all of them are aligned from the first sample. Streamers added while the
+// session is running join the mix as soon as they are added regardless.
+func (transmuxer *Transmuxer) SetStartSynchronized(synchronized bool) error {
+	if transmuxer.closed {
+		return errors.New("ffgoconv: transmuxer: closed")
+	}
+
+	transmuxer.synchronized.Store(synchronized)
 	return nil
 }
 
+// synchronize blocks until every streamer in the transmuxing session has buffered a full block of audio, closing any
+// streamer that fails to.
+func (transmuxer *Transmuxer) synchronize() {
+	var wg sync.WaitGroup
+	for _, streamer := range transmuxer.nextStreamers() {
+		if streamer.closed.Load() {
+			continue
+		}
+
+		wg.Add(1)
+		go func(streamer *Streamer) {
+			defer wg.Done()
+			if err := streamer.prebuffer(); err != nil {
+				streamer.setError(err)
+				streamer.closeAsync()
+			}
+		}(streamer)
+	}
+	wg.Wait()
+}
+
 // IsRunning returns whether or not the transmuxing session is running.
 func (transmuxer *Transmuxer) IsRunning() bool {
+	transmuxer.Lock()
+	defer transmuxer.Unlock()
+
 	return transmuxer.running
 }
 
-// Run starts the transmuxing session.
+// Run starts the transmuxing session, blocking until it is stopped or closed.
+//
+// A transmuxing session that was stopped with Stop may be resumed by calling Run again.
 func (transmuxer *Transmuxer) Run() {
-	if transmuxer.closed {
+	transmuxer.Lock()
+	if transmuxer.closed || transmuxer.running {
+		transmuxer.Unlock()
 		return
 	}
 
-	if transmuxer.running {
-		return
+	transmuxer.running = true
+	transmuxer.runStarted.Store(time.Now().UnixNano())
+	transmuxer.stopping.Store(false)
+	stopped := make(chan struct{})
+	transmuxer.stopped = stopped
+	transmuxer.Unlock()
+
+	defer func() {
+		transmuxer.pacer.reset()
+
+		transmuxer.Lock()
+		transmuxer.running = false
+		transmuxer.Unlock()
+
+		close(stopped)
+	}()
+
+	if transmuxer.synchronized.Load() {
+		transmuxer.synchronize()
 	}
 
-	transmuxer.running = true
+	block := make([]float64, mixBlockSize)
+	var ducking []duckingRule
 
+	// Changes to the session are only picked up between blocks, so that channels are never swapped mid-frame.
 	for {
-		var sample float64
+		if transmuxer.stopping.Load() {
+			return
+		}
 
-		for _, streamer := range transmuxer.Streamers {
-			newSample, err := streamer.ReadSample()
-			if err != nil {
-				streamer.setError(err)
-				streamer.Close()
-				continue
+		size := mixBlockSize
+		if limit := transmuxer.limit.Load(); limit > 0 {
+			remaining := limit - transmuxer.mixed.Load()
+			if remaining <= 0 {
+				transmuxer.finish()
+				return
+			}
+			if remaining < int64(size) {
+				size = int(remaining)
 			}
+		}
+
+		streamers := transmuxer.nextStreamers()
+		recorder := transmuxer.recorder.Load()
+		segmenter := transmuxer.segmenter.Load()
+		meterWindow := transmuxer.meterWindow.Load()
+		realtime := transmuxer.realtime.Load()
+		masterVolume := transmuxer.MasterVolume()
 
-			sample += newSample * streamer.Volume
+		ducking = transmuxer.duckingRules(ducking[:0])
+
+		transmuxer.Lock()
+		processors := transmuxer.processors
+		transmuxer.Unlock()
+
+		block = block[:size]
+		for _, streamer := range streamers {
+			read := streamer.mixBlock(size, meterWindow, realtime)
+			if read < size && streamer.closed.Load() {
+				transmuxer.handOff(streamer, read, meterWindow, realtime)
+			}
 		}
 
-		sample = sample * transmuxer.MasterVolume
+		for i := range block {
+			var sample float64
 
+			updateDucking(streamers, ducking)
+
+			for _, streamer := range streamers {
+				sample += streamer.mixBuffer[i] * streamer.ducking
+			}
+
+			block[i] = sample * masterVolume
+		}
+
+		output := process(processors, block)
+
+		for _, sample := range output {
+			transmuxer.meter.add(sample, meterWindow)
+
+			if transmuxer.FinalStream != nil {
+				finalSample := sample
+				if transmuxer.dither.Load() && transmuxer.sixteenBit {
+					finalSample = transmuxer.ditherer.dither(finalSample)
+				}
+
+				err := transmuxer.FinalStream.WriteSample(finalSample)
+				if err != nil {
+					if transmuxer.stopping.Load() {
+						return
+					}
+
+					transmuxer.setError(err)
+					transmuxer.Close()
+					return
+				}
+			}
+		}
+
+		if recorder != nil {
+			recorder.write(output)
+		}
+		if segmenter != nil {
+			segmenter.write(output)
+		}
+
+		// The final stream is flushed once per block, so that its buffering never adds more than a block of latency.
 		if transmuxer.FinalStream != nil {
-			err := transmuxer.FinalStream.WriteSample(sample)
-			if err != nil {
+			if err := transmuxer.FinalStream.Flush(); err != nil {
+				if transmuxer.stopping.Load() {
+					return
+				}
+
 				transmuxer.setError(err)
 				transmuxer.Close()
 				return
 			}
 		}
 
-		if transmuxer.buffer != nil {
-			transmuxer.buffer = append(transmuxer.buffer, sample)
+		transmuxer.mixed.Add(int64(size))
+
+		if transmuxer.bufferReady != nil {
+			transmuxer.Lock()
+			for transmuxer.maxBuffered > 0 && int64(len(transmuxer.buffer)) >= transmuxer.maxBuffered && !transmuxer.stopping.Load() {
+				transmuxer.bufferDrained.Wait()
+			}
+			transmuxer.buffer = append(transmuxer.buffer, output...)
+			transmuxer.bufferReady.Signal()
+			transmuxer.Unlock()
+		}
+
+		if transmuxer.realtime.Load() {
+			transmuxer.pacer.tick(int64(size))
+		} else if !transmuxer.pacer.start.IsZero() {
+			transmuxer.pacer.reset()
 		}
 	}
 }
 
-// Read implements io.Reader using the internal buffer.
+// Read implements io.Reader using the internal buffer, filling p with as many float64 PCM samples as are available.
+//
+// Read blocks until at least one sample has been mixed, and only returns io.EOF once the transmuxing session has been
+// closed and the internal buffer has been drained.
 func (transmuxer *Transmuxer) Read(p []byte) (n int, err error) {
-	if transmuxer.closed {
-		return 0, errors.New("ffgoconv: transmuxer: closed")
+	if transmuxer.bufferReady == nil {
+		return 0, io.EOF
+	}
+
+	if len(p) < 8 { // sizeof(float64) == 8
+		return 0, io.ErrShortBuffer
+	}
+
+	transmuxer.Lock()
+	defer transmuxer.Unlock()
+
+	for len(transmuxer.buffer) == 0 && !transmuxer.closed {
+		transmuxer.bufferReady.Wait()
 	}
 
 	if len(transmuxer.buffer) == 0 {
 		return 0, io.EOF
 	}
 
-	sample := transmuxer.buffer[0]
+	samples := len(p) / 8
+	if samples > len(transmuxer.buffer) {
+		samples = len(transmuxer.buffer)
+	}
+
+	for i, sample := range transmuxer.buffer[:samples] {
+		u64 := math.Float64bits(sample)
+		binary.LittleEndian.PutUint64(p[i*8:], u64)
+	}
+	transmuxer.buffer = transmuxer.buffer[samples:]
+	transmuxer.bufferDrained.Signal()
+
+	return samples * 8, nil
+}
 
-	if len(transmuxer.buffer) > 1 {
-		transmuxer.buffer = transmuxer.buffer[1:]
-	} else {
-		transmuxer.buffer = make([]float64, 0)
+// SetMaxBuffered sets the maximum duration of mixed audio held in the internal buffer of a transmuxing session without
+// an output. Once it is reached, mixing is paused until Read has drained the buffer below it, rather than the buffer
+// growing for as long as nobody reads it. A maximum of 0 means unlimited, which is the default.
+func (transmuxer *Transmuxer) SetMaxBuffered(max time.Duration) error {
+	if transmuxer.closed {
+		return errors.New("ffgoconv: transmuxer: closed")
 	}
+	if transmuxer.bufferReady == nil {
+		return errors.New("ffgoconv: transmuxer: only sessions without an output are buffered")
+	}
+	if max < 0 {
+		return errors.New("ffgoconv: transmuxer: maximum buffered duration must not be negative")
+	}
+
+	transmuxer.Lock()
+	transmuxer.maxBuffered = durationSamples(max)
+	transmuxer.bufferDrained.Broadcast()
+	transmuxer.Unlock()
+	return nil
+}
 
-	u64 := math.Float64bits(sample)
-	binary.LittleEndian.PutUint64(p, u64)
+// Buffered returns the duration of mixed audio held in the internal buffer of a transmuxing session without an output
+// that has yet to be read, for monitoring how far behind the reader is.
+func (transmuxer *Transmuxer) Buffered() time.Duration {
+	transmuxer.Lock()
+	defer transmuxer.Unlock()
 
-	return 8, nil
+	return samplesDuration(int64(len(transmuxer.buffer)))
+}
+
+// Stop halts the transmuxing session without closing it, blocking until Run has returned. The streamers and the final
+// stream are left open, and the session may be resumed by calling Run again.
+//
+// Run only returns between blocks, so Stop gives up waiting after 5 seconds with ErrStopTimeout if it is blocked on a
+// stalled streamer or an unread final stream. Run returns as soon as it gets unblocked, which IsRunning reports, and
+// calling Run again before then does nothing.
+//
+// Stop is a no-op if the transmuxing session is not running. It must not be called from the goroutine running Run.
+func (transmuxer *Transmuxer) Stop() error {
+	transmuxer.Lock()
+	if !transmuxer.running {
+		transmuxer.Unlock()
+		return nil
+	}
+
+	transmuxer.stopping.Store(true)
+	if transmuxer.bufferDrained != nil {
+		transmuxer.bufferDrained.Broadcast()
+	}
+	stopped := transmuxer.stopped
+	transmuxer.Unlock()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-time.After(stopTimeout):
+		return ErrStopTimeout
+	}
+}
+
+// SetDurationLimit sets the duration of audio after which the transmuxing session finishes, which is sample-accurate
+// rather than based on wall time. Once the limit is reached, the final stream's stdin is closed so that the encoder can
+// flush its output, and the session is closed once it has exited. A limit of 0 means unlimited, which is the default.
+//
+// Setting a limit at or below the duration of audio that has already been mixed finishes the session immediately.
+func (transmuxer *Transmuxer) SetDurationLimit(limit time.Duration) error {
+	if transmuxer.closed {
+		return errors.New("ffgoconv: transmuxer: closed")
+	}
+
+	if limit < 0 {
+		return errors.New("ffgoconv: transmuxer: duration limit must not be negative")
+	}
+
+	samples := durationSamples(limit)
+	samples += samples % 2 // Always finish on a frame boundary
+	if limit > 0 && samples == 0 {
+		samples = 2
+	}
+	transmuxer.limit.Store(samples)
+
+	if samples > 0 && !transmuxer.IsRunning() && transmuxer.mixed.Load() >= samples {
+		transmuxer.finish()
+	}
+	return nil
+}
+
+// Done returns a channel that is closed once the transmuxing session has been closed, either by Close or by reaching
+// its duration limit.
+func (transmuxer *Transmuxer) Done() <-chan struct{} {
+	return transmuxer.done
+}
+
+// finish ends the transmuxing session gracefully, letting the final stream flush its output before closing the session.
+func (transmuxer *Transmuxer) finish() {
+	if transmuxer.FinalStream == nil {
+		transmuxer.close(false)
+		return
+	}
+
+	transmuxer.FinalStream.Flush()
+	transmuxer.FinalStream.Stdin.Close()
+	go func() {
+		<-transmuxer.FinalStream.ended
+		transmuxer.close(false)
+	}()
+}
+
+// EncodeStats returns the latest encoding progress reported by the final stream, or nil if there is no final stream.
+func (transmuxer *Transmuxer) EncodeStats() *TranscodeStats {
+	if transmuxer.stderrLog == nil {
+		return nil
+	}
+	return transmuxer.stderrLog.Stats()
+}
+
+// EncodeOutput returns the tail of the final stream's ffmpeg stderr output, which is useful when diagnosing errors.
+func (transmuxer *Transmuxer) EncodeOutput() string {
+	if transmuxer.stderrLog == nil {
+		return ""
+	}
+	return transmuxer.stderrLog.Tail()
 }
 
 // Err returns the latest transmuxing error.
@@ -199,20 +1006,53 @@ func (transmuxer *Transmuxer) Err() error {
 
 // Close closes the transmuxing session and renders the transmuxer unusable.
 func (transmuxer *Transmuxer) Close() {
+	transmuxer.close(true)
+}
+
+// close closes the transmuxing session, along with the final stream if closeFinal is set. The final stream is left
+// open once it has finished on its own, so that its remaining output can still be read.
+func (transmuxer *Transmuxer) close(closeFinal bool) {
+	transmuxer.Lock()
 	if transmuxer.closed {
+		transmuxer.Unlock()
 		return
 	}
+	transmuxer.closed = true
+	transmuxer.stopping.Store(true)
+	if transmuxer.bufferReady != nil {
+		transmuxer.bufferReady.Broadcast()
+		transmuxer.bufferDrained.Broadcast()
+	}
+	queue := transmuxer.queue
+	transmuxer.queue = nil
+	transmuxer.Unlock()
 
-	for _, streamer := range transmuxer.Streamers {
+	for _, queued := range queue {
+		queued.err = errors.New("ffgoconv: transmuxer: closed")
+		close(queued.ready)
+	}
+
+	for _, streamer := range transmuxer.GetStreamers() {
 		streamer.Close()
 	}
 
-	transmuxer.FinalStream.Close()
+	if transmuxer.FinalStream != nil && closeFinal {
+		if err := transmuxer.FinalStream.Close(); err != nil && transmuxer.Error == nil {
+			transmuxer.setError(err)
+		}
+	}
+	if recorder := transmuxer.recorder.Swap(nil); recorder != nil {
+		recorder.close()
+	}
+	if segmenter := transmuxer.segmenter.Swap(nil); segmenter != nil {
+		segmenter.close()
+	}
+	transmuxer.meter.reset()
 
-	transmuxer.closed = true
-	transmuxer.running = false
+	close(transmuxer.done)
 }
 
 func (transmuxer *Transmuxer) setError(err error) {
 	transmuxer.Error = err
+	transmuxer.log().Errorf("%v", err)
 }
@@ -0,0 +1,622 @@
+package ffgoconv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// mixAll mixes d of audio from streamers in a transmuxing session without an output, returning every sample it read.
+func mixAll(t *testing.T, d time.Duration, streamers ...*Streamer) []float64 {
+	t.Helper()
+	return mixAllWith(t, d, nil, streamers...)
+}
+
+// mixAllWith is mixAll with setup called on the transmuxing session before it is run, if it isn't nil.
+func mixAllWith(t *testing.T, d time.Duration, setup func(*Transmuxer) error, streamers ...*Streamer) []float64 {
+	t.Helper()
+
+	transmuxer, err := NewTransmuxerWithOptions(streamers, &TransmuxerOptions{MasterVolume: 1.0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transmuxer.Close()
+
+	if setup != nil {
+		if err := setup(transmuxer); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := transmuxer.SetDurationLimit(d); err != nil {
+		t.Fatal(err)
+	}
+	go transmuxer.Run()
+
+	data, err := ioutil.ReadAll(transmuxer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data)%8 != 0 {
+		t.Fatalf("read %d bytes, which isn't a whole number of samples", len(data))
+	}
+
+	samples := make([]float64, len(data)/8)
+	for i := range samples {
+		samples[i] = math.Float64frombits(binary.LittleEndian.Uint64(data[i*8:]))
+	}
+	return samples
+}
+
+func TestTransmuxerReadCopy(t *testing.T) {
+	streamer, err := NewToneStreamer(440, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transmuxer, err := NewTransmuxerWithOptions([]*Streamer{streamer}, &TransmuxerOptions{MasterVolume: 1.0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transmuxer.Close()
+
+	// A duration that isn't a whole number of blocks, so that the last block is cut short.
+	limit := 255 * time.Millisecond
+	if err := transmuxer.SetDurationLimit(limit); err != nil {
+		t.Fatal(err)
+	}
+	go transmuxer.Run()
+
+	var output bytes.Buffer
+	n, err := io.Copy(&output, transmuxer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := durationSamples(limit) * 8; n != want || int64(output.Len()) != want {
+		t.Errorf("copied %d bytes, want %d", n, want)
+	}
+
+	if n, err := transmuxer.Read(make([]byte, 8)); n != 0 || err != io.EOF {
+		t.Errorf("reading once the session was closed returned %d, %v, want 0, EOF", n, err)
+	}
+}
+
+func TestTransmuxerReadWholeSamples(t *testing.T) {
+	streamer, err := NewToneStreamer(440, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transmuxer, err := NewTransmuxerWithOptions([]*Streamer{streamer}, &TransmuxerOptions{MasterVolume: 1.0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transmuxer.Close()
+	if err := transmuxer.SetDurationLimit(10 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	go transmuxer.Run()
+
+	if _, err := transmuxer.Read(make([]byte, 7)); err != io.ErrShortBuffer {
+		t.Errorf("reading into 7 bytes returned %v, want %v", err, io.ErrShortBuffer)
+	}
+
+	total := 0
+	p := make([]byte, 12)
+	for {
+		n, err := transmuxer.Read(p)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 8 {
+			t.Fatalf("read %d bytes into a 12 byte buffer, want 8", n)
+		}
+		total += n
+	}
+	if want := int(durationSamples(10*time.Millisecond)) * 8; total != want {
+		t.Errorf("read %d bytes, want %d", total, want)
+	}
+}
+
+func TestTransmuxerBufferedSoak(t *testing.T) {
+	if testing.Short() {
+		t.Skip("soak test")
+	}
+
+	streamer, err := NewToneStreamer(440, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transmuxer, err := NewTransmuxerWithOptions([]*Streamer{streamer}, &TransmuxerOptions{MasterVolume: 1.0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transmuxer.Close()
+
+	// Unbounded, 20 minutes of audio would take up over 900MB by the time it was read.
+	limit := 20 * time.Minute
+	maxBuffered := time.Second
+	if err := transmuxer.SetDurationLimit(limit); err != nil {
+		t.Fatal(err)
+	}
+	if err := transmuxer.SetMaxBuffered(maxBuffered); err != nil {
+		t.Fatal(err)
+	}
+
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	baseline := stats.HeapInuse
+
+	go transmuxer.Run()
+
+	// The reader reads less than a block at a time, so that it falls behind and the buffer fills up to its maximum.
+	var total int64
+	var peakBuffered time.Duration
+	var peakHeap uint64
+	p := make([]byte, 4096)
+	for reads := 0; ; reads++ {
+		n, err := transmuxer.Read(p)
+		total += int64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if reads%10000 == 0 {
+			if buffered := transmuxer.Buffered(); buffered > peakBuffered {
+				peakBuffered = buffered
+			}
+			runtime.ReadMemStats(&stats)
+			if stats.HeapInuse > peakHeap {
+				peakHeap = stats.HeapInuse
+			}
+		}
+	}
+
+	if want := durationSamples(limit) * 8; total != want {
+		t.Errorf("read %d bytes, want %d", total, want)
+	}
+	// The buffer only stops growing once it has reached the maximum, so it may exceed it by a block.
+	if max := maxBuffered + samplesDuration(mixBlockSize); peakBuffered > max {
+		t.Errorf("buffered up to %v, want at most %v", peakBuffered, max)
+	}
+	if growth := int64(peakHeap) - int64(baseline); growth > 64<<20 {
+		t.Errorf("heap grew by %dMB over the session, want at most 64MB", growth>>20)
+	}
+	t.Logf("buffered up to %v, heap grew by up to %dKB", peakBuffered, (int64(peakHeap)-int64(baseline))>>10)
+}
+
+func TestTransmuxerRejectsOtherFormats(t *testing.T) {
+	mono := newPCMStreamer(bytes.NewReader(nil))
+	mono.channels = 1
+	defer mono.Close()
+	if _, err := NewTransmuxerWithOptions([]*Streamer{mono}, &TransmuxerOptions{MasterVolume: 1.0}); err == nil {
+		t.Error("a transmuxing session was created with a mono streamer")
+	}
+
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake ffmpeg is a shell script")
+	}
+	path := filepath.Join(t.TempDir(), "ffmpeg")
+	if err := os.WriteFile(path, []byte(fakeDecoder), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	transmuxer, err := NewTransmuxerWithOptions(nil, &TransmuxerOptions{MasterVolume: 1.0, FFmpegPath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transmuxer.Close()
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"mono", []string{"-i", "song.mp3", "-f", "f64le", "-ar", "48000", "-ac", "1", "pipe:1"}},
+		{"44.1kHz", []string{"-i", "song.mp3", "-f", "f64le", "-ar", "44100", "-ac", "2", "pipe:1"}},
+	}
+	for _, test := range tests {
+		if _, err := transmuxer.AddStreamer("song.mp3", test.args, 1.0); err == nil {
+			t.Errorf("%s: a streamer was added with args %q", test.name, test.args)
+		}
+	}
+
+	streamer, err := transmuxer.AddStreamer("song.mp3", []string{"-i", "song.mp3", "-f", "f64le", "-ac", "2", "pipe:1"}, 1.0)
+	if err != nil {
+		t.Fatalf("a 48kHz stereo streamer was rejected: %v", err)
+	}
+	streamer.Close()
+}
+
+// newSamplesStreamer returns a *Streamer playing samples once, without an ffmpeg process.
+func newSamplesStreamer(samples []float64) *Streamer {
+	return newPCMStreamer(bytes.NewReader(encodePCM(samples, PrecisionFloat64)))
+}
+
+// constant returns d of samples of value.
+func constant(value float64, d time.Duration) []float64 {
+	samples := make([]float64, durationSamples(d))
+	for i := range samples {
+		samples[i] = value
+	}
+	return samples
+}
+
+func TestDuckingEnvelope(t *testing.T) {
+	target, err := NewSourceStreamer(&trackSource{value: 0.5, remaining: -1}, 1.0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trigger := newSamplesStreamer(concat(constant(0, 100*time.Millisecond), constant(0.125, 200*time.Millisecond)))
+
+	samples := mixAllWith(t, 500*time.Millisecond, func(transmuxer *Transmuxer) error {
+		if err := transmuxer.SetMeterWindow(time.Millisecond); err != nil {
+			return err
+		}
+		return transmuxer.SetDucking(trigger, []*Streamer{target}, 0.01, 0.5, 20*time.Millisecond, 50*time.Millisecond)
+	}, target, trigger)
+
+	at := func(d time.Duration) int { return int(durationSamples(d)) }
+	check := func(phase string, from, to time.Duration, want float64) {
+		t.Helper()
+		for i := at(from); i < at(to); i++ {
+			if math.Abs(samples[i]-want) > 1e-9 {
+				t.Fatalf("%s: sample %d is %v, want %v", phase, i, samples[i], want)
+			}
+		}
+	}
+	ramp := func(phase string, from, to time.Duration, offset, down float64) {
+		t.Helper()
+		between := 0
+		for i := at(from); i < at(to); i++ {
+			gain := (samples[i] - offset) / 0.5
+			if i > at(from) && (gain-(samples[i-1]-offset)/0.5)*down > 1e-9 {
+				t.Fatalf("%s: the gain turned around at sample %d", phase, i)
+			}
+			if gain > 0.5+1e-9 && gain < 1-1e-9 {
+				between++
+			}
+		}
+		// The envelope ramps over the attack or release rather than jumping.
+		if between < at(10*time.Millisecond) {
+			t.Errorf("%s: only %d samples are partially ducked", phase, between)
+		}
+	}
+
+	check("before the trigger", 0, 100*time.Millisecond, 0.5)
+	ramp("attack", 100*time.Millisecond, 140*time.Millisecond, 0.125, 1)
+	check("while triggered", 150*time.Millisecond, 300*time.Millisecond, 0.5*0.5+0.125)
+	ramp("release", 300*time.Millisecond, 380*time.Millisecond, 0, -1)
+	check("after the release", 400*time.Millisecond, 500*time.Millisecond, 0.5)
+}
+
+func TestRealtimePacing(t *testing.T) {
+	mix := func(realtime bool) time.Duration {
+		streamer, err := NewToneStreamer(440, 0.5)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		start := time.Now()
+		samples := mixAllWith(t, 300*time.Millisecond, func(transmuxer *Transmuxer) error {
+			return transmuxer.SetRealtime(realtime)
+		}, streamer)
+		if int64(len(samples)) != durationSamples(300*time.Millisecond) {
+			t.Fatalf("mixed %d samples, want %d", len(samples), durationSamples(300*time.Millisecond))
+		}
+		return time.Since(start)
+	}
+
+	// Pacing holds the mix back to the sample clock, while the mix otherwise runs as fast as it is read.
+	if elapsed := mix(true); elapsed < 290*time.Millisecond || elapsed > time.Second {
+		t.Errorf("mixing 300ms in real time took %v", elapsed)
+	}
+	if elapsed := mix(false); elapsed > 200*time.Millisecond {
+		t.Errorf("mixing 300ms as fast as possible took %v", elapsed)
+	}
+}
+
+// fakeFillDecoder is a script standing in for ffmpeg, which outputs an endless stream of 0x3F bytes, each sample of
+// which decodes to the same small positive value.
+const fakeFillDecoder = `#!/bin/sh
+case "$*" in
+*-version*) exit 0 ;;
+esac
+tr '\000' '?' < /dev/zero
+`
+
+func TestReplaceStreamerWithoutAGap(t *testing.T) {
+	ffmpegPath := writeFakeFFmpeg(t, fakeFillDecoder)
+	fill := math.Float64frombits(0x3F3F3F3F3F3F3F3F)
+
+	old, err := NewSourceStreamer(&trackSource{value: 0.5, remaining: -1}, 1.0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transmuxer, err := NewTransmuxerWithOptions([]*Streamer{old}, &TransmuxerOptions{MasterVolume: 1.0, FFmpegPath: ffmpegPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transmuxer.Close()
+
+	if err := transmuxer.SetMaxBuffered(20 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	go transmuxer.Run()
+
+	var samples []float64
+	read := func(d time.Duration) {
+		t.Helper()
+		data := make([]byte, durationSamples(d)*8)
+		if _, err := io.ReadFull(transmuxer, data); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < len(data); i += 8 {
+			samples = append(samples, math.Float64frombits(binary.LittleEndian.Uint64(data[i:])))
+		}
+	}
+
+	read(100 * time.Millisecond)
+	streamer, err := transmuxer.ReplaceStreamer(old, "song.mp3", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read(200 * time.Millisecond)
+
+	// The mix switches from one streamer to the other on a frame boundary, and never goes silent in between.
+	swap := -1
+	for i, sample := range samples {
+		if swap < 0 && sample != 0.5 {
+			swap = i
+		}
+		if swap >= 0 && sample != fill {
+			t.Fatalf("sample %d is %v after the swap at %d, want %v", i, sample, swap, fill)
+		}
+	}
+	if swap < 0 {
+		t.Fatal("the mix never switched to the new streamer")
+	}
+	if swap%2 != 0 {
+		t.Errorf("the swap happened at sample %d, which isn't on a frame boundary", swap)
+	}
+
+	if !old.closed.Load() {
+		t.Error("the old streamer wasn't closed")
+	}
+	if !transmuxer.hasStreamer(streamer) || transmuxer.hasStreamer(old) {
+		t.Error("the new streamer didn't take the place of the old one")
+	}
+}
+
+// delayedSource is a SampleSource producing a constant value, which only starts producing it after a delay.
+type delayedSource struct {
+	value float64
+	delay time.Duration
+}
+
+// ReadSamples implements SampleSource.
+func (source *delayedSource) ReadSamples(dst []float64) (int, error) {
+	if source.delay > 0 {
+		time.Sleep(source.delay)
+		source.delay = 0
+	}
+	for i := range dst {
+		dst[i] = source.value
+	}
+	return len(dst), nil
+}
+
+// Close implements SampleSource.
+func (source *delayedSource) Close() error {
+	return nil
+}
+
+func TestStartSynchronized(t *testing.T) {
+	for _, synchronized := range []bool{true, false} {
+		ready, err := NewSourceStreamer(&trackSource{value: 0.25, remaining: -1}, 1.0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		late, err := NewSourceStreamer(&delayedSource{value: 0.5, delay: 100 * time.Millisecond}, 1.0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The late streamer is mixed as silence while it is behind, rather than holding up the mix until it catches up.
+		if err := late.SetStallPolicy(StallSilence, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		// Mixing in real time leaves the late streamer enough time to keep ahead of the mix once it has started.
+		samples := mixAllWith(t, 200*time.Millisecond, func(transmuxer *Transmuxer) error {
+			if err := transmuxer.SetRealtime(true); err != nil {
+				return err
+			}
+			return transmuxer.SetStartSynchronized(synchronized)
+		}, ready, late)
+
+		if synchronized {
+			for i, sample := range samples {
+				if sample != 0.75 {
+					t.Fatalf("synchronized: sample %d is %v, want both streamers mixed from the start", i, sample)
+				}
+			}
+		} else if samples[0] != 0.25 {
+			t.Errorf("not synchronized: the first sample is %v, want only the streamer that was ready", samples[0])
+		}
+	}
+}
+
+// oggPage returns an Ogg page holding the given segments, of which only the capture pattern and the lacing values are
+// filled in.
+func oggPage(lacing []byte, payload []byte) []byte {
+	page := make([]byte, oggPageHeaderSize, oggPageHeaderSize+len(lacing)+len(payload))
+	copy(page, "OggS")
+	page[26] = byte(len(lacing))
+	page = append(page, lacing...)
+	return append(page, payload...)
+}
+
+// oggLacing returns the lacing values of a packet of size bytes that isn't continued on the next page.
+func oggLacing(size int) []byte {
+	lacing := bytes.Repeat([]byte{255}, size/255)
+	return append(lacing, byte(size%255))
+}
+
+// packet returns size bytes of value.
+func packet(value byte, size int) []byte {
+	return bytes.Repeat([]byte{value}, size)
+}
+
+func TestOpusReader(t *testing.T) {
+	head := append([]byte("OpusHead"), packet(0, 11)...)
+	tags := append([]byte("OpusTags"), packet(0, 292)...)
+	a, b, c := packet('a', 10), packet('b', 600), packet('c', 255)
+
+	// The second packet crosses over into the next page, and the third ends with a segment of 0 bytes.
+	var stream []byte
+	stream = append(stream, oggPage(oggLacing(len(head)), head)...)
+	stream = append(stream, oggPage(oggLacing(len(tags)), tags)...)
+	stream = append(stream, oggPage(append(oggLacing(len(a)), 255, 255), append(a, b[:510]...))...)
+	stream = append(stream, oggPage(append([]byte{90}, oggLacing(len(c))...), append(b[510:], c...))...)
+
+	reader := NewOpusReader(bytes.NewReader(stream))
+	for _, want := range [][]byte{a, b, c} {
+		got, err := reader.ReadPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("read a packet of %d bytes of %q, want %d bytes of %q", len(got), got[:1], len(want), want[:1])
+		}
+	}
+	if _, err := reader.ReadPacket(); err != io.EOF {
+		t.Errorf("got error %v at the end of the stream, want io.EOF", err)
+	}
+
+	// Read frames every packet with its size for DCA.
+	dca, err := ioutil.ReadAll(NewOpusReader(bytes.NewReader(stream)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []byte
+	for _, packet := range [][]byte{a, b, c} {
+		want = binary.LittleEndian.AppendUint16(want, uint16(len(packet)))
+		want = append(want, packet...)
+	}
+	if !bytes.Equal(dca, want) {
+		t.Errorf("read %d bytes of DCA, want %d", len(dca), len(want))
+	}
+
+	invalid := [][]byte{
+		oggPage(oggLacing(4), []byte("fLaC")),
+		append([]byte("OggT"), stream[4:]...),
+		stream[:len(stream)-1],
+	}
+	for _, stream := range invalid {
+		reader := NewOpusReader(bytes.NewReader(stream))
+		var err error
+		for err == nil {
+			_, err = reader.ReadPacket()
+		}
+		if err == io.EOF {
+			t.Errorf("an invalid stream of %d bytes ended cleanly", len(stream))
+		}
+	}
+}
+
+func TestWAVReader(t *testing.T) {
+	streamer, err := NewToneStreamer(440, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := mixAll(t, 10*time.Millisecond, streamer)
+
+	streamer, err = NewToneStreamer(440, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transmuxer, err := NewTransmuxerWithOptions([]*Streamer{streamer}, &TransmuxerOptions{MasterVolume: 1.0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transmuxer.Close()
+	if err := transmuxer.SetDurationLimit(10 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	go transmuxer.Run()
+
+	wav, err := NewWAVReader(transmuxer, transmuxer.WAVFormat())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(wav)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != wavHeaderSize+len(want)*8 {
+		t.Fatalf("read %d bytes, want a header and %d samples", len(data), len(want))
+	}
+
+	header := data[:wavHeaderSize]
+	fields := []struct {
+		name      string
+		got, want uint32
+	}{
+		{"RIFF size", binary.LittleEndian.Uint32(header[4:]), wavStreamingSize},
+		{"format tag", uint32(binary.LittleEndian.Uint16(header[20:])), wavFormatFloat},
+		{"channels", uint32(binary.LittleEndian.Uint16(header[22:])), 2},
+		{"sample rate", binary.LittleEndian.Uint32(header[24:]), 48000},
+		{"byte rate", binary.LittleEndian.Uint32(header[28:]), 48000 * 2 * 8},
+		{"block align", uint32(binary.LittleEndian.Uint16(header[32:])), 16},
+		{"bits per sample", uint32(binary.LittleEndian.Uint16(header[34:])), 64},
+		{"data size", binary.LittleEndian.Uint32(header[40:]), wavStreamingSize},
+	}
+	if string(header[:4]) != "RIFF" || string(header[8:16]) != "WAVEfmt " || string(header[36:40]) != "data" {
+		t.Errorf("the header is laid out as %q", header)
+	}
+	for _, field := range fields {
+		if field.got != field.want {
+			t.Errorf("%s is %d, want %d", field.name, field.got, field.want)
+		}
+	}
+	if !bytes.Equal(data[wavHeaderSize:], encodePCM(want, PrecisionFloat64)) {
+		t.Error("the PCM following the header isn't the mixed audio")
+	}
+
+	// Once the length is known, the sizes of a streamed file are patched in place.
+	file, err := os.Create(filepath.Join(t.TempDir(), "mix.wav"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := FinalizeWAV(file, int64(len(want)*8)); err != nil {
+		t.Fatal(err)
+	}
+	finalized, err := os.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := binary.LittleEndian.Uint32(finalized[4:]); size != uint32(len(data)-8) {
+		t.Errorf("the finalized RIFF size is %d, want %d", size, len(data)-8)
+	}
+	if size := binary.LittleEndian.Uint32(finalized[40:]); size != uint32(len(want)*8) {
+		t.Errorf("the finalized data size is %d, want %d", size, len(want)*8)
+	}
+	if !bytes.Equal(finalized[wavHeaderSize:], data[wavHeaderSize:]) {
+		t.Error("finalizing changed the PCM")
+	}
+}
@@ -0,0 +1,98 @@
+package ffgoconv
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ffmpegVersion is the version of an ffmpeg executable, as reported by "ffmpeg -version".
+type ffmpegVersion struct {
+	major, minor int
+	raw          string
+}
+
+// FFmpegVersion returns the major and minor version of the ffmpeg executable set with SetFFmpegPath, along with the raw
+// version string it reports, such as "6.1.1-3ubuntu5". The version is only read from ffmpeg once and then cached.
+//
+// Builds from git, which report a version such as "N-109421-g0a1b2c3d", have no release version, in which case the raw
+// version is returned along with an error.
+func FFmpegVersion() (major, minor int, raw string, err error) {
+	version, err := lookVersion("")
+	return version.major, version.minor, version.raw, err
+}
+
+// lookVersion returns the cached version of the ffmpeg executable at path, or of the package-level ffmpeg if path is
+// empty, reading it first if it hasn't been yet.
+func lookVersion(path string) (ffmpegVersion, error) {
+	caps, ffmpegPath, err := cachedCapabilities(path)
+	if err != nil {
+		return ffmpegVersion{}, err
+	}
+
+	caps.versionOnce.Do(func() {
+		var output []byte
+		if output, caps.versionErr = listCapabilities(ffmpegPath, "-version"); caps.versionErr != nil {
+			return
+		}
+		caps.version, caps.versionErr = parseVersion(output)
+	})
+	return caps.version, caps.versionErr
+}
+
+// parseVersion parses the output of "ffmpeg -version", which starts with a line such as
+// "ffmpeg version 6.1.1-3ubuntu5 Copyright (c) 2000-2023 the FFmpeg developers".
+func parseVersion(output []byte) (ffmpegVersion, error) {
+	_, line, _ := bufio.ScanLines(output, true)
+	fields := strings.Fields(string(bytes.TrimSpace(line)))
+	if len(fields) < 3 || fields[1] != "version" {
+		return ffmpegVersion{}, errors.New("ffgoconv: ffmpeg: unrecognized -version output")
+	}
+
+	version := ffmpegVersion{raw: fields[2]}
+
+	// Release builds may prefix their version with an n, as in "n5.1.2".
+	release := strings.TrimPrefix(version.raw, "n")
+	if i := strings.IndexAny(release, "-+~ "); i >= 0 {
+		release = release[:i]
+	}
+	parts := strings.Split(release, ".")
+
+	var err error
+	if version.major, err = strconv.Atoi(parts[0]); err != nil {
+		return version, fmt.Errorf("ffgoconv: ffmpeg: version %s isn't a release version", version.raw)
+	}
+	if len(parts) > 1 {
+		version.minor, _ = strconv.Atoi(parts[1])
+	}
+	return version, nil
+}
+
+// withVolumeArgs returns args adapted to the ffmpeg executable at path, replacing "-vol 256", which is deprecated as of
+// ffmpeg 5 and warns on every run, with the equivalent volume filter. The args are returned as they are if the version
+// of ffmpeg can't be determined.
+func withVolumeArgs(args []string, path string) []string {
+	version, err := lookVersion(path)
+	if err != nil {
+		return args
+	}
+	return adaptVolumeArgs(args, version)
+}
+
+// adaptVolumeArgs is withVolumeArgs for the given version of ffmpeg.
+func adaptVolumeArgs(args []string, version ffmpegVersion) []string {
+	if version.major < 5 {
+		return args
+	}
+
+	for i := 0; i < len(args)-1; i++ {
+		if args[i] == "-vol" && args[i+1] == "256" {
+			adapted := append(append([]string(nil), args[:i]...), args[i+2:]...)
+			return appendAudioFilter(adapted, "volume=1.0")
+		}
+	}
+	return args
+}
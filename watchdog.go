@@ -0,0 +1,116 @@
+package ffgoconv
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// ErrStreamStalled is reported by a streamer that was closed for having produced no audio for too long.
+var ErrStreamStalled = errors.New("ffgoconv: streamer: stream stalled")
+
+// dataReader records the time of every read from r that returns data.
+type dataReader struct {
+	r        io.Reader
+	lastData *atomic.Int64
+}
+
+// Read implements io.Reader.
+func (reader *dataReader) Read(p []byte) (n int, err error) {
+	n, err = reader.r.Read(p)
+	if n > 0 {
+		reader.lastData.Store(time.Now().UnixNano())
+	}
+	return n, err
+}
+
+// LastDataTime returns the last time ffmpeg produced audio for the streamer, or the time it was started at if it has yet
+// to produce any.
+func (streamer *Streamer) LastDataTime() time.Time {
+	return time.Unix(0, streamer.lastData.Load())
+}
+
+// startWaiting records the current time in waiting as the time the mix loop started waiting on a streamer for audio,
+// unless it already was waiting.
+func startWaiting(waiting *atomic.Int64) {
+	if waiting.Load() == 0 {
+		waiting.CompareAndSwap(0, time.Now().UnixNano())
+	}
+}
+
+// waitingSince returns the time the mix loop has been waiting on the streamer for audio since, and false if it isn't.
+func (streamer *Streamer) waitingSince() (time.Time, bool) {
+	since := streamer.waiting.Load()
+	return time.Unix(0, since), since != 0
+}
+
+// SetWatchdog sets how long the transmuxing session may wait on a streamer to produce audio before it is closed with
+// ErrStreamStalled and removed from the mix. Only time spent waiting on the streamer itself counts, so that streamers
+// aren't considered stalled while the session is stopped or held up by its output. A timeout of 0 disables the
+// watchdog, which is the default.
+func (transmuxer *Transmuxer) SetWatchdog(timeout time.Duration) error {
+	if transmuxer.closed {
+		return errors.New("ffgoconv: transmuxer: closed")
+	}
+
+	if timeout < 0 {
+		return errors.New("ffgoconv: watchdog: timeout must not be negative")
+	}
+
+	transmuxer.watchdog.Store(int64(timeout))
+	if timeout > 0 && transmuxer.watchdogRunning.CompareAndSwap(false, true) {
+		go transmuxer.runWatchdog()
+	}
+	return nil
+}
+
+// runWatchdog periodically closes every streamer that has stalled for longer than the watchdog timeout, until the
+// watchdog is disabled or the transmuxing session is closed. Only a single watchdog runs at a time.
+func (transmuxer *Transmuxer) runWatchdog() {
+	for {
+		timeout := time.Duration(transmuxer.watchdog.Load())
+		if timeout <= 0 {
+			transmuxer.watchdogRunning.Store(false)
+			// The watchdog may have been enabled again before it was marked as stopped, without starting another.
+			if transmuxer.watchdog.Load() <= 0 || !transmuxer.watchdogRunning.CompareAndSwap(false, true) {
+				return
+			}
+			continue
+		}
+
+		interval := timeout / 4
+		if interval < 10*time.Millisecond {
+			interval = 10 * time.Millisecond
+		}
+
+		select {
+		case <-transmuxer.done:
+			return
+		case <-time.After(interval):
+		}
+
+		if !transmuxer.IsRunning() {
+			continue
+		}
+
+		// A wait that began before the session was stopped may only be counted since it last started running.
+		started := time.Unix(0, transmuxer.runStarted.Load())
+
+		for _, streamer := range transmuxer.GetStreamers() {
+			since, waiting := streamer.waitingSince()
+			if !waiting {
+				continue
+			}
+			if since.Before(started) {
+				since = started
+			}
+
+			if time.Since(since) > timeout {
+				streamer.setError(fmt.Errorf("%w: no audio produced for longer than %v", ErrStreamStalled, timeout))
+				streamer.closeAsync()
+			}
+		}
+	}
+}
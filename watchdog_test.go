@@ -0,0 +1,89 @@
+package ffgoconv
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stalledSource is a SampleSource that never produces any samples, blocking every read until it is closed.
+type stalledSource struct {
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newStalledSource() *stalledSource {
+	return &stalledSource{closed: make(chan struct{})}
+}
+
+func (source *stalledSource) ReadSamples(dst []float64) (int, error) {
+	<-source.closed
+	return 0, io.EOF
+}
+
+func (source *stalledSource) Close() error {
+	source.once.Do(func() { close(source.closed) })
+	return nil
+}
+
+func TestWatchdogClosesStalledStreamer(t *testing.T) {
+	transmuxer, err := NewTransmuxerWithOptions(nil, &TransmuxerOptions{MasterVolume: 1.0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transmuxer.Close()
+
+	stalled, err := transmuxer.AddSource(newStalledSource(), 1.0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := transmuxer.SetWatchdog(100 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	go transmuxer.Run()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !stalled.closed.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("the stalled streamer wasn't closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !errors.Is(stalled.Error, ErrStreamStalled) {
+		t.Errorf("the stalled streamer failed with %v, want %v", stalled.Error, ErrStreamStalled)
+	}
+}
+
+func TestWatchdogIgnoresOutputBackpressure(t *testing.T) {
+	streamer, err := NewToneStreamer(440, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transmuxer, err := NewTransmuxerWithOptions([]*Streamer{streamer}, &TransmuxerOptions{MasterVolume: 1.0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transmuxer.Close()
+
+	if err := transmuxer.SetMaxBuffered(50 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	// Turning the watchdog off and on again must leave a single watchdog running.
+	for _, timeout := range []time.Duration{100 * time.Millisecond, 0, 100 * time.Millisecond} {
+		if err := transmuxer.SetWatchdog(timeout); err != nil {
+			t.Fatal(err)
+		}
+	}
+	go transmuxer.Run()
+
+	// Nobody reads the session, so it is held up by its full buffer for several times the watchdog timeout.
+	time.Sleep(500 * time.Millisecond)
+	if streamer.closed.Load() {
+		t.Fatalf("the streamer was closed with %v while the session was held up by its output", streamer.Error)
+	}
+	if _, err := transmuxer.Read(make([]byte, 4096)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,130 @@
+package ffgoconv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	// wavHeaderSize is the size of the canonical RIFF/WAVE header written before the PCM data.
+	wavHeaderSize = 44
+	// wavStreamingSize is the size written for chunks of unknown length, as is the convention for streamed WAV.
+	wavStreamingSize = 0xFFFFFFFF
+
+	wavFormatPCM   = 1
+	wavFormatFloat = 3
+)
+
+// WAVFormat describes the interleaved raw PCM held by a WAV file.
+type WAVFormat struct {
+	SampleRate    int
+	Channels      int
+	BitsPerSample int  // Size of every sample, such as 16 for s16le or 64 for f64le
+	Float         bool // Whether the samples are IEEE floating point rather than signed integers
+}
+
+// WAVFormat returns the format of the raw PCM read from the transmuxing session, which is 48kHz stereo float64.
+func (transmuxer *Transmuxer) WAVFormat() WAVFormat {
+	return WAVFormat{SampleRate: 48000, Channels: 2, BitsPerSample: 64, Float: true}
+}
+
+// WAVFormat returns the format of the raw PCM output by the streamer's ffmpeg.
+func (streamer *Streamer) WAVFormat() WAVFormat {
+	return WAVFormat{
+		SampleRate:    streamer.sampleRate,
+		Channels:      streamer.channels,
+		BitsPerSample: streamer.precision.size() * 8,
+		Float:         true,
+	}
+}
+
+// validate returns an error if the format can't be described by a WAV header.
+func (format WAVFormat) validate() error {
+	if format.SampleRate <= 0 || format.Channels <= 0 {
+		return errors.New("ffgoconv: wav: sample rate and channels must be positive")
+	}
+	if format.BitsPerSample <= 0 || format.BitsPerSample%8 != 0 {
+		return errors.New("ffgoconv: wav: bits per sample must be a positive multiple of 8")
+	}
+	return nil
+}
+
+// WriteWAVHeader writes a RIFF/WAVE header for dataSize bytes of PCM in the given format to w. If dataSize is negative,
+// the sizes are written as 0xFFFFFFFF, which is how WAV of unknown length is streamed, and may be patched with
+// FinalizeWAV once the length is known.
+func WriteWAVHeader(w io.Writer, format WAVFormat, dataSize int64) error {
+	if err := format.validate(); err != nil {
+		return err
+	}
+	_, err := w.Write(wavHeader(format, dataSize))
+	return err
+}
+
+// FinalizeWAV patches the sizes of a WAV file written with a streaming header once its PCM data is known to be
+// dataSize bytes long.
+func FinalizeWAV(w io.WriteSeeker, dataSize int64) error {
+	if dataSize < 0 || dataSize > wavStreamingSize-wavHeaderSize+8 {
+		return errors.New("ffgoconv: wav: data size doesn't fit in a WAV header")
+	}
+
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(dataSize+wavHeaderSize-8))
+	if _, err := w.Seek(4, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := w.Write(size[:]); err != nil {
+		return err
+	}
+
+	binary.LittleEndian.PutUint32(size[:], uint32(dataSize))
+	if _, err := w.Seek(wavHeaderSize-4, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := w.Write(size[:]); err != nil {
+		return err
+	}
+
+	_, err := w.Seek(0, io.SeekEnd)
+	return err
+}
+
+// NewWAVReader returns an io.Reader reading the raw PCM read from r in the given format as streamed WAV, prefixed with
+// a header of unknown length.
+func NewWAVReader(r io.Reader, format WAVFormat) (io.Reader, error) {
+	if err := format.validate(); err != nil {
+		return nil, err
+	}
+	return io.MultiReader(bytes.NewReader(wavHeader(format, -1)), r), nil
+}
+
+// wavHeader returns a RIFF/WAVE header for dataSize bytes of PCM in the given format, or of unknown length if dataSize
+// is negative.
+func wavHeader(format WAVFormat, dataSize int64) []byte {
+	riffSize, chunkSize := uint32(wavStreamingSize), uint32(wavStreamingSize)
+	if dataSize >= 0 && dataSize <= wavStreamingSize-wavHeaderSize+8 {
+		riffSize, chunkSize = uint32(dataSize+wavHeaderSize-8), uint32(dataSize)
+	}
+
+	formatTag := uint16(wavFormatPCM)
+	if format.Float {
+		formatTag = wavFormatFloat
+	}
+	blockAlign := format.Channels * format.BitsPerSample / 8
+
+	header := make([]byte, 0, wavHeaderSize)
+	header = append(header, "RIFF"...)
+	header = binary.LittleEndian.AppendUint32(header, riffSize)
+	header = append(header, "WAVEfmt "...)
+	header = binary.LittleEndian.AppendUint32(header, 16)
+	header = binary.LittleEndian.AppendUint16(header, formatTag)
+	header = binary.LittleEndian.AppendUint16(header, uint16(format.Channels))
+	header = binary.LittleEndian.AppendUint32(header, uint32(format.SampleRate))
+	header = binary.LittleEndian.AppendUint32(header, uint32(format.SampleRate*blockAlign))
+	header = binary.LittleEndian.AppendUint16(header, uint16(blockAlign))
+	header = binary.LittleEndian.AppendUint16(header, uint16(format.BitsPerSample))
+	header = append(header, "data"...)
+	header = binary.LittleEndian.AppendUint32(header, chunkSize)
+	return header
+}